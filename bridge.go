@@ -0,0 +1,238 @@
+package tornago
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Pluggable transport names understood by Bridge validation.
+const (
+	// TransportObfs4 disguises traffic as random bytes. Bridges need cert= and iat-mode=.
+	TransportObfs4 = "obfs4"
+	// TransportSnowflake tunnels traffic through volunteer WebRTC proxies.
+	TransportSnowflake = "snowflake"
+	// TransportWebTunnel disguises traffic as HTTPS WebSocket. Bridges need url=.
+	TransportWebTunnel = "webtunnel"
+	// TransportMeekLite tunnels traffic through domain-fronted HTTPS. Bridges need url=.
+	TransportMeekLite = "meek_lite"
+)
+
+// bridgeRequiredArgs lists the arguments each transport needs to connect.
+var bridgeRequiredArgs = map[string][]string{
+	TransportObfs4:     {"cert", "iat-mode"},
+	TransportWebTunnel: {"url"},
+	TransportMeekLite:  {"url"},
+}
+
+// Bridge is a Tor bridge line such as the ones handed out by
+// bridges.torproject.org:
+//
+//	obfs4 192.0.2.1:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=... iat-mode=0
+//
+// Transport is empty for vanilla bridges.
+type Bridge struct {
+	// Transport is the pluggable transport name, or "" for a vanilla bridge.
+	Transport string
+	// Address is the bridge "host:port".
+	Address string
+	// Fingerprint is the bridge identity fingerprint, if known.
+	Fingerprint string
+	// Args are transport arguments as "key=value" in their original order.
+	Args []string
+}
+
+// ParseBridge parses a bridge line. A leading "Bridge" keyword, as found in
+// torrc files, is accepted.
+func ParseBridge(line string) (Bridge, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "Bridge") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return Bridge{}, newError(ErrInvalidConfig, "ParseBridge", "empty bridge line", nil)
+	}
+	var b Bridge
+	if !strings.Contains(fields[0], ":") {
+		b.Transport, fields = fields[0], fields[1:]
+		if len(fields) == 0 {
+			return Bridge{}, newError(ErrInvalidConfig, "ParseBridge", fmt.Sprintf("bridge line %q has no address", line), nil)
+		}
+	}
+	b.Address, fields = fields[0], fields[1:]
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		b.Fingerprint, fields = fields[0], fields[1:]
+	}
+	b.Args = fields
+	if err := b.Validate(); err != nil {
+		return Bridge{}, err
+	}
+	return b, nil
+}
+
+// ParseBridges parses one bridge per line, skipping blank lines and "#"
+// comments, so text copied from bridges.torproject.org can be used as is.
+func ParseBridges(text string) ([]Bridge, error) {
+	var bridges []Bridge
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := ParseBridge(line)
+		if err != nil {
+			return nil, err
+		}
+		bridges = append(bridges, b)
+	}
+	return bridges, nil
+}
+
+// Arg returns the value of a transport argument such as "cert".
+func (b Bridge) Arg(key string) (string, bool) {
+	for _, arg := range b.Args {
+		if k, v, ok := strings.Cut(arg, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// String renders the bridge line without the "Bridge" keyword.
+func (b Bridge) String() string {
+	parts := make([]string, 0, 3+len(b.Args))
+	if b.Transport != "" {
+		parts = append(parts, b.Transport)
+	}
+	parts = append(parts, b.Address)
+	if b.Fingerprint != "" {
+		parts = append(parts, b.Fingerprint)
+	}
+	parts = append(parts, b.Args...)
+	return strings.Join(parts, " ")
+}
+
+// Validate checks the address, fingerprint and the arguments the transport
+// needs to connect.
+func (b Bridge) Validate() error {
+	if b.Transport != "" && !validTransportName(b.Transport) {
+		return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("invalid transport name %q", b.Transport), nil)
+	}
+	host, port, err := net.SplitHostPort(b.Address)
+	if err != nil || host == "" {
+		return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("invalid bridge address %q, expected host:port", b.Address), err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("invalid bridge port in %q", b.Address), nil)
+	}
+	if b.Fingerprint != "" {
+		if _, err := normalizeFingerprint(b.Fingerprint); err != nil {
+			return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("invalid bridge fingerprint %q", b.Fingerprint), err)
+		}
+	}
+	for _, arg := range b.Args {
+		if k, _, ok := strings.Cut(arg, "="); !ok || k == "" || strings.ContainsAny(arg, "\r\n") {
+			return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("invalid transport argument %q, expected key=value", arg), nil)
+		}
+	}
+	for _, key := range bridgeRequiredArgs[b.Transport] {
+		if _, ok := b.Arg(key); !ok {
+			return newError(ErrInvalidConfig, "Bridge", fmt.Sprintf("%s bridge %s is missing %s=", b.Transport, b.Address, key), nil)
+		}
+	}
+	return nil
+}
+
+// validTransportName reports whether name is a valid pluggable transport
+// identifier (C identifier syntax, as required by the PT specification).
+func validTransportName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// TransportPlugin is a ClientTransportPlugin: an executable providing one or
+// more pluggable transports, such as lyrebird (obfs4, webtunnel, meek_lite)
+// or snowflake-client.
+type TransportPlugin struct {
+	// Transports are the transport names the executable provides.
+	Transports []string
+	// Path is the executable, either absolute or looked up in PATH.
+	Path string
+	// Args are passed to the executable.
+	Args []string
+}
+
+// String renders the ClientTransportPlugin value, e.g. "obfs4 exec /usr/bin/lyrebird".
+func (p TransportPlugin) String() string {
+	parts := append([]string{strings.Join(p.Transports, ","), "exec", p.Path}, p.Args...)
+	return strings.Join(parts, " ")
+}
+
+// provides reports whether the plugin provides transport.
+func (p TransportPlugin) provides(transport string) bool {
+	return slices.Contains(p.Transports, transport)
+}
+
+// bridgeArgs renders the bridge options of cfg as tor CLI args.
+func bridgeArgs(cfg TorLaunchConfig) []string {
+	if len(cfg.bridges) == 0 {
+		return nil
+	}
+	args := []string{"--UseBridges", "1"}
+	for _, b := range cfg.bridges {
+		args = append(args, "--Bridge", b.String())
+	}
+	for _, p := range cfg.transportPlugins {
+		args = append(args, "--ClientTransportPlugin", p.String())
+	}
+	return args
+}
+
+// validateBridgeOptions checks bridges and that every transport they use has
+// a plugin.
+func validateBridgeOptions(cfg TorLaunchConfig) error {
+	for _, p := range cfg.transportPlugins {
+		if len(p.Transports) == 0 || p.Path == "" {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				"transport plugin needs a name and an executable. Use WithTorTransportPlugin(\"obfs4\", \"/usr/bin/lyrebird\")", nil)
+		}
+		for _, name := range p.Transports {
+			if !validTransportName(name) {
+				return newError(ErrInvalidConfig, "validateTorLaunchConfig", fmt.Sprintf("invalid transport name %q", name), nil)
+			}
+		}
+	}
+	for _, b := range cfg.bridges {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+		if b.Transport == "" {
+			continue
+		}
+		if !slices.ContainsFunc(cfg.transportPlugins, func(p TransportPlugin) bool { return p.provides(b.Transport) }) {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				fmt.Sprintf("bridge %s uses transport %q but no plugin provides it. Use WithTorTransportPlugin(%q, path)", b.Address, b.Transport, b.Transport), nil)
+		}
+	}
+	return nil
+}
+
+// findTransportPlugins resolves every transport plugin executable so a
+// missing one is reported before tor starts, instead of as a bootstrap that
+// never completes.
+func findTransportPlugins(cfg TorLaunchConfig) error {
+	for _, p := range cfg.transportPlugins {
+		if _, err := exec.LookPath(p.Path); err != nil {
+			msg := fmt.Sprintf("pluggable transport %s not found at %q. Install it (e.g. apt-get install obfs4proxy snowflake-client) or fix the path", strings.Join(p.Transports, ","), p.Path)
+			return newError(ErrTransportNotFound, opStartTorDaemon, msg, err)
+		}
+	}
+	return nil
+}
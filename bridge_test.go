@@ -0,0 +1,179 @@
+package tornago
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testObfs4Line     = "obfs4 192.0.2.1:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=AbCd+ef/0123 iat-mode=0"
+	testSnowflakeLine = "snowflake 192.0.2.3:80 2B280B23E1107BB62ABFC40DDCC8824814F80A72 fingerprint=2B280B23E1107BB62ABFC40DDCC8824814F80A72 url=https://snowflake-broker.torproject.net/ ice=stun:stun.l.google.com:19302"
+	testWebTunnelLine = "webtunnel [2001:db8::1]:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 url=https://example.com/secret-path ver=0.0.1"
+	testVanillaLine   = "198.51.100.7:9001 4352E58420E68F5E40BF7C74FADDCCD9D1349413"
+)
+
+func TestParseBridge(t *testing.T) {
+	t.Run("should parse every bridge flavour", func(t *testing.T) {
+		tests := []struct {
+			line, transport, address string
+			args                     int
+		}{
+			{testObfs4Line, TransportObfs4, "192.0.2.1:443", 2},
+			{"Bridge " + testSnowflakeLine, TransportSnowflake, "192.0.2.3:80", 3},
+			{testWebTunnelLine, TransportWebTunnel, "[2001:db8::1]:443", 2},
+			{testVanillaLine, "", "198.51.100.7:9001", 0},
+		}
+		for _, tt := range tests {
+			b, err := ParseBridge(tt.line)
+			if err != nil {
+				t.Fatalf("ParseBridge(%q) failed: %v", tt.line, err)
+			}
+			if b.Transport != tt.transport || b.Address != tt.address || len(b.Args) != tt.args {
+				t.Errorf("ParseBridge(%q) = %+v", tt.line, b)
+			}
+			if got := b.String(); got != strings.TrimPrefix(tt.line, "Bridge ") {
+				t.Errorf("String() = %q", got)
+			}
+		}
+		b, _ := ParseBridge(testObfs4Line) //nolint:errcheck
+		if cert, ok := b.Arg("cert"); !ok || cert != "AbCd+ef/0123" {
+			t.Errorf("Arg(cert) = %q, %v", cert, ok)
+		}
+	})
+
+	t.Run("should reject malformed bridges", func(t *testing.T) {
+		for _, line := range []string{
+			"",
+			"obfs4",
+			"obfs4 192.0.2.1 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=x iat-mode=0",
+			"obfs4 192.0.2.1:0 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=x iat-mode=0",
+			"obfs4 192.0.2.1:443 NOTAFINGERPRINT cert=x iat-mode=0",
+			"obfs4 192.0.2.1:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 iat-mode=0",
+			"webtunnel 192.0.2.1:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 ver=0.0.1",
+			"obfs-4 192.0.2.1:443",
+		} {
+			if _, err := ParseBridge(line); err == nil {
+				t.Errorf("expected error for %q", line)
+			}
+		}
+	})
+
+	t.Run("should parse pasted bridge lists", func(t *testing.T) {
+		bridges, err := ParseBridges("# from bridges.torproject.org\n" + testObfs4Line + "\n\n" + testWebTunnelLine + "\n")
+		if err != nil {
+			t.Fatalf("ParseBridges failed: %v", err)
+		}
+		if len(bridges) != 2 || bridges[1].Transport != TransportWebTunnel {
+			t.Errorf("unexpected bridges: %+v", bridges)
+		}
+	})
+}
+
+func TestBridgeLaunchOptions(t *testing.T) {
+	obfs4, _ := ParseBridge(testObfs4Line)     //nolint:errcheck
+	vanilla, _ := ParseBridge(testVanillaLine) //nolint:errcheck
+
+	t.Run("should render bridge and plugin args", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig(
+			WithTorBridges(obfs4, vanilla),
+			WithTorTransportPlugin("obfs4,webtunnel", "/usr/bin/lyrebird", "-enableLogging"),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		want := []string{
+			"--UseBridges", "1",
+			"--Bridge", testObfs4Line,
+			"--Bridge", testVanillaLine,
+			"--ClientTransportPlugin", "obfs4,webtunnel exec /usr/bin/lyrebird -enableLogging",
+		}
+		if got := bridgeArgs(cfg); !slices.Equal(got, want) {
+			t.Errorf("bridgeArgs() = %q", got)
+		}
+		if len(cfg.Bridges()) != 2 || len(cfg.TransportPlugins()) != 1 {
+			t.Errorf("unexpected accessors: %+v %+v", cfg.Bridges(), cfg.TransportPlugins())
+		}
+	})
+
+	t.Run("should require a plugin for each transport", func(t *testing.T) {
+		_, err := NewTorLaunchConfig(WithTorBridges(obfs4), WithTorTransportPlugin("snowflake", "snowflake-client"))
+		if err == nil || !strings.Contains(err.Error(), `transport "obfs4"`) {
+			t.Errorf("expected missing plugin error, got %v", err)
+		}
+		if _, err := NewTorLaunchConfig(WithTorBridges(vanilla)); err != nil {
+			t.Errorf("vanilla bridges need no plugin: %v", err)
+		}
+		if _, err := NewTorLaunchConfig(WithTorTransportPlugin("", "lyrebird")); err == nil {
+			t.Error("expected error for a plugin without transports")
+		}
+	})
+
+	t.Run("should reject invalid bridges", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorBridges(Bridge{Address: "nowhere"})); err == nil {
+			t.Error("expected error for invalid bridge")
+		}
+	})
+}
+
+func TestStartTorDaemonBridges(t *testing.T) {
+	obfs4, _ := ParseBridge(testObfs4Line) //nolint:errcheck
+
+	t.Run("should fail when the transport binary is missing", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(writeFakeTor(t, filepath.Join(t.TempDir(), "args"))),
+			WithTorBridges(obfs4),
+			WithTorTransportPlugin(TransportObfs4, filepath.Join(t.TempDir(), "no-such-lyrebird")),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		_, err = StartTorDaemon(cfg)
+		if !errors.Is(err, &TornagoError{Kind: ErrTransportNotFound}) {
+			t.Errorf("expected ErrTransportNotFound, got %v", err)
+		}
+	})
+
+	t.Run("should pass bridges to tor", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("test relies on a shell script")
+		}
+		transport := filepath.Join(t.TempDir(), "fake-lyrebird")
+		if err := os.WriteFile(transport, []byte("#!/bin/sh\nexit 0\n"), 0o700); err != nil { //nolint:gosec // test script must be executable
+			t.Fatal(err)
+		}
+		argsPath := filepath.Join(t.TempDir(), "args")
+		socksAddr := startMockControlServer(t, func(string) string { return "" })
+		controlAddr := startMockControlServer(t, func(string) string { return "" })
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(writeFakeTor(t, argsPath)),
+			WithTorSocksAddr(socksAddr),
+			WithTorControlAddr(controlAddr),
+			WithTorStartupTimeout(10*time.Second),
+			WithTorBridges(obfs4),
+			WithTorTransportPlugin(TransportObfs4, transport),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		proc, err := StartTorDaemon(cfg)
+		if err != nil {
+			t.Fatalf("StartTorDaemon failed: %v", err)
+		}
+		defer proc.Stop()
+		args, err := os.ReadFile(argsPath)
+		if err != nil {
+			t.Fatalf("failed to read tor args: %v", err)
+		}
+		for _, want := range []string{"--UseBridges 1", "--Bridge " + testObfs4Line, "--ClientTransportPlugin obfs4 exec " + transport} {
+			if !strings.Contains(string(args), want) {
+				t.Errorf("expected %q in tor args, got %q", want, args)
+			}
+		}
+	})
+}
@@ -2,6 +2,9 @@ package tornago
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -9,6 +12,11 @@ import (
 const (
 	// opCircuitManager labels errors originating from CircuitManager operations.
 	opCircuitManager = "CircuitManager"
+	// newnymRateLimit is the minimum spacing Tor enforces between NEWNYM
+	// signals. Requests arriving sooner are delayed by Tor.
+	newnymRateLimit = 10 * time.Second
+	// newnymRateLimitNotice prefixes the notice Tor logs when it delays NEWNYM.
+	newnymRateLimitNotice = "Rate limiting NEWNYM request"
 )
 
 // CircuitManager manages Tor circuits with advanced features like automatic rotation,
@@ -40,6 +48,20 @@ type CircuitManager struct {
 	mu sync.Mutex
 	// running indicates if auto-rotation is active.
 	running bool
+	// lastNewnym is when this manager last sent NEWNYM successfully.
+	lastNewnym time.Time
+	// policies decide when policy-driven rotation rotates circuits.
+	policies []RotationPolicy
+	// rotateCh carries rotation requests from Observe to the policy loop.
+	rotateCh chan struct{}
+	// probe holds circuit measurements while StartProbing runs.
+	probe *circuitProbe
+	// stopped reports whether Stop closed stopCh.
+	stopped bool
+	// stats accumulates rotation and circuit statistics.
+	stats *circuitStatsState
+	// metrics optionally receives rotation and circuit outcomes.
+	metrics *MetricsCollector
 }
 
 // NewCircuitManager creates a new CircuitManager with the given ControlClient.
@@ -48,6 +70,7 @@ func NewCircuitManager(control *ControlClient) *CircuitManager {
 		control: control,
 		logger:  noopLogger{},
 		stopCh:  make(chan struct{}),
+		stats:   newCircuitStatsState(),
 	}
 }
 
@@ -66,6 +89,8 @@ func (m *CircuitManager) WithLogger(logger Logger) *CircuitManager {
 //   - Refreshing circuits that may have become slow
 //
 // The rotation continues until Stop() is called or the context is canceled.
+// Tor delays NEWNYM signals sent less than 10 seconds apart, so shorter
+// intervals rotate no faster than that and log a warning on each tick.
 //
 // Example:
 //
@@ -118,7 +143,7 @@ func (m *CircuitManager) autoRotateLoop(ctx context.Context) {
 		case <-m.rotationTimer.C:
 			m.logger.Log("debug", "rotating circuits", "interval", m.rotationInterval)
 
-			if err := m.control.NewIdentity(ctx); err != nil {
+			if err := m.newnym(ctx); err != nil {
 				m.logger.Log("error", "circuit rotation failed", "error", err)
 			} else {
 				m.logger.Log("info", "circuits rotated successfully")
@@ -130,17 +155,19 @@ func (m *CircuitManager) autoRotateLoop(ctx context.Context) {
 	}
 }
 
-// Stop stops automatic circuit rotation if it's running.
+// Stop stops automatic circuit rotation, circuit probing and stats
+// collection if they are running. A stopped manager cannot be restarted.
 func (m *CircuitManager) Stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if !m.running {
+	if m.stopped || (!m.running && m.probe == nil && !m.stats.isCollecting()) {
 		return
 	}
 
 	m.logger.Log("info", "stopping circuit manager")
 	close(m.stopCh)
+	m.stopped = true
 	m.running = false
 }
 
@@ -153,10 +180,15 @@ func (m *CircuitManager) IsRunning() bool {
 
 // RotateNow immediately rotates circuits by calling NewIdentity().
 // This is useful for manual circuit rotation outside of the automatic schedule.
+//
+// Tor accepts one NEWNYM every 10 seconds and silently delays the rest. When
+// the previous rotation by this manager was that recent, RotateNow still
+// signals but logs a warning with the expected delay. Use RotateAndWait to
+// wait out the limit and confirm that new circuits exist.
 func (m *CircuitManager) RotateNow(ctx context.Context) error {
 	m.logger.Log("debug", "manual circuit rotation requested")
 
-	if err := m.control.NewIdentity(ctx); err != nil {
+	if err := m.newnym(ctx); err != nil {
 		m.logger.Log("error", "manual circuit rotation failed", "error", err)
 		return err
 	}
@@ -173,35 +205,316 @@ func (m *CircuitManager) RotateNow(ctx context.Context) error {
 //   - After a long idle period
 //   - When you know you'll need fresh circuits soon
 //
-// After calling this, wait a few seconds (5-10s) for Tor to build new circuits
-// before making requests.
+// PrewarmCircuits returns as soon as Tor accepted the signal; like RotateNow,
+// it warns when Tor will delay the signal because of its rate limit. Use RotateAndWait when requests must not
+// start before a fresh circuit is ready.
 func (m *CircuitManager) PrewarmCircuits(ctx context.Context) error {
 	m.logger.Log("info", "prewarming circuits")
 
-	if err := m.control.NewIdentity(ctx); err != nil {
+	if err := m.newnym(ctx); err != nil {
 		m.logger.Log("error", "circuit prewarming failed", "error", err)
 		return err
 	}
 
-	m.logger.Log("info", "circuit prewarming initiated", "wait_time", "5-10 seconds recommended")
+	m.logger.Log("info", "circuit prewarming initiated")
+	return nil
+}
+
+// LastNewnym returns when this manager last sent NEWNYM successfully, or the
+// zero time if it never did.
+func (m *CircuitManager) LastNewnym() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastNewnym
+}
+
+// newnymDelay returns how long to wait before Tor accepts another NEWNYM
+// from this manager without delaying it.
+func (m *CircuitManager) newnymDelay() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lastNewnym.IsZero() {
+		return 0
+	}
+	return max(newnymRateLimit-time.Since(m.lastNewnym), 0)
+}
+
+// newnym sends NEWNYM and records when it was sent. A NEWNYM that Tor will
+// delay because of its rate limit is still sent, with a warning.
+func (m *CircuitManager) newnym(ctx context.Context) error {
+	if delay := m.newnymDelay(); delay > 0 {
+		m.logger.Log("warn", "Tor will delay NEWNYM because of its rate limit", "delay", delay.Round(time.Millisecond))
+	}
+	err := m.control.NewIdentity(ctx)
+	now := time.Now()
+	m.stats.recordRotation(now, err)
+	if metrics := m.metricsCollector(); metrics != nil {
+		metrics.recordRotation(now, err)
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.lastNewnym = now
+	m.mu.Unlock()
 	return nil
 }
 
+// RotationResult describes a rotation confirmed by RotateAndWait.
+type RotationResult struct {
+	// CircuitID is the first general-purpose circuit built after NEWNYM.
+	CircuitID string
+	// Path lists the relays of that circuit.
+	Path []string
+	// RateLimited reports whether Tor logged that it delayed the NEWNYM.
+	RateLimited bool
+	// RateLimitDelay is the delay Tor announced when RateLimited is true.
+	RateLimitDelay time.Duration
+	// PreviousExitIP is the exit IP observed before rotating, when checked.
+	PreviousExitIP string
+	// ExitIP is the exit IP observed after rotating, when checked.
+	ExitIP string
+	// ExitIPChanged reports whether ExitIP differs from PreviousExitIP. The
+	// same exit can legitimately be picked again, though rarely.
+	ExitIPChanged bool
+	// Duration is how long the rotation took, including any wait for the
+	// rate limit.
+	Duration time.Duration
+}
+
+// RotateOption customizes RotateAndWait.
+type RotateOption func(*rotateOptions)
+
+// rotateOptions holds RotateAndWait settings.
+type rotateOptions struct {
+	// exitIP looks up the current exit IP; nil disables the exit check.
+	exitIP func(ctx context.Context) (string, error)
+}
+
+// WithRotateExitIPCheck makes RotateAndWait look up the exit IP through
+// client before and after rotating (via VerifyTorConnection) and report
+// whether it changed. Idle keep-alive connections of client are closed so
+// the second lookup uses a new circuit.
+func WithRotateExitIPCheck(client *Client) RotateOption {
+	return func(o *rotateOptions) {
+		if client == nil {
+			return
+		}
+		o.exitIP = func(ctx context.Context) (string, error) {
+			client.HTTP().CloseIdleConnections()
+			status, err := client.VerifyTorConnection(ctx)
+			if err != nil {
+				return "", err
+			}
+			return status.ExitIP(), nil
+		}
+	}
+}
+
+// RotateAndWait sends NEWNYM and returns once Tor has built a fresh
+// general-purpose circuit, so new streams are guaranteed to use a new
+// identity. If this manager sent NEWNYM less than 10 seconds ago, it first
+// waits until Tor accepts another one. A rate-limit notice from Tor (e.g.
+// because another controller sent NEWNYM) is reported in the result.
+//
+// Circuits count as fresh only when Tor reports them after its SIGNAL NEWNYM
+// event, so circuits built while the signal was in flight or delayed are not
+// mistaken for new ones.
+//
+// Tor builds the replacement circuits on its own; ctx should carry a deadline
+// in case it cannot (for example while the network is down).
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+//	defer cancel()
+//	res, err := manager.RotateAndWait(ctx, tornago.WithRotateExitIPCheck(client))
+//	if err != nil {
+//	    return err
+//	}
+//	log.Printf("new exit %s via circuit %s", res.ExitIP, res.CircuitID)
+func (m *CircuitManager) RotateAndWait(ctx context.Context, opts ...RotateOption) (RotationResult, error) {
+	var o rotateOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	start := time.Now()
+	var result RotationResult
+
+	if o.exitIP != nil {
+		ip, err := o.exitIP(ctx)
+		if err != nil {
+			return result, err
+		}
+		result.PreviousExitIP = ip
+	}
+
+	if delay := m.newnymDelay(); delay > 0 {
+		m.logger.Log("debug", "waiting for NEWNYM rate limit", "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, newError(ErrTimeout, opCircuitManager, "canceled while waiting for NEWNYM rate limit", ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	events := make(chan ControlEvent, 64)
+	sub, err := m.control.SubscribeEvents(subCtx, func(ev ControlEvent) {
+		select {
+		case events <- ev:
+		case <-subCtx.Done():
+		}
+	}, "CIRC", "NOTICE", "SIGNAL")
+	if err != nil {
+		cancel()
+		return result, err
+	}
+	defer sub.Close()
+	defer cancel()
+
+	// NEWNYM marks every existing circuit dirty, so circuits in this
+	// snapshot, and any circuit seen in an event before Tor reports the
+	// NEWNYM signal as applied, are stale. The SIGNAL event arrives on the
+	// same stream as the CIRC events, so circuits launched or built while
+	// NEWNYM is in flight are ordered correctly.
+	existing, err := m.control.GetCircuitStatus(ctx)
+	if err != nil {
+		return result, err
+	}
+	stale := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		stale[c.ID] = true
+	}
+
+	if err := m.newnym(ctx); err != nil {
+		return result, err
+	}
+
+	applied := false
+	for result.CircuitID == "" {
+		select {
+		case <-ctx.Done():
+			return result, newError(ErrTimeout, opCircuitManager, "timed out waiting for a new circuit after NEWNYM", ctx.Err())
+		case <-sub.Done():
+			return result, newError(ErrControlRequestFail, opCircuitManager, "event subscription ended while waiting for a new circuit", sub.Err())
+		case ev := <-events:
+			switch ev.Type {
+			case "SIGNAL":
+				if strings.TrimSpace(ev.Data) == "NEWNYM" {
+					applied = true
+				}
+			case "NOTICE":
+				if delay, ok := parseNewnymRateLimitNotice(ev.Data); ok {
+					result.RateLimited = true
+					result.RateLimitDelay = delay
+					m.logger.Log("warn", "Tor delayed NEWNYM", "delay", delay)
+				}
+			case "CIRC":
+				circuit := parseCircuitLine(ev.Data)
+				if !applied {
+					stale[circuit.ID] = true
+					continue
+				}
+				if circuit.Status == "BUILT" && !stale[circuit.ID] && isExitCircuit(circuit) {
+					result.CircuitID = circuit.ID
+					result.Path = circuit.Path
+				}
+			}
+		}
+	}
+
+	if o.exitIP != nil {
+		ip, err := o.exitIP(ctx)
+		if err != nil {
+			return result, err
+		}
+		result.ExitIP = ip
+		result.ExitIPChanged = ip != result.PreviousExitIP
+		if !result.ExitIPChanged {
+			m.logger.Log("warn", "exit IP unchanged after rotation", "exit_ip", ip)
+		}
+	}
+
+	result.Duration = time.Since(start)
+	m.logger.Log("info", "circuit rotation confirmed", "circuit_id", result.CircuitID, "duration", result.Duration)
+	return result, nil
+}
+
+// isExitCircuit reports whether circuit can carry ordinary exit streams.
+func isExitCircuit(circuit CircuitInfo) bool {
+	if circuit.Purpose != "" && circuit.Purpose != "GENERAL" {
+		return false
+	}
+	return !slices.Contains(circuit.BuildFlags, "IS_INTERNAL") && !slices.Contains(circuit.BuildFlags, "ONEHOP_TUNNEL")
+}
+
+// parseNewnymRateLimitNotice extracts the delay from Tor's notice
+// "Rate limiting NEWNYM request: delaying by 7 second(s)".
+func parseNewnymRateLimitNotice(msg string) (time.Duration, bool) {
+	idx := strings.Index(msg, newnymRateLimitNotice)
+	if idx < 0 {
+		return 0, false
+	}
+	var seconds int
+	rest := msg[idx+len(newnymRateLimitNotice):]
+	if _, err := fmt.Sscanf(rest, ": delaying by %d second", &seconds); err != nil {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
 // CircuitStats provides statistics about circuit management operations.
+// Circuit counts, build times and guards are only populated while
+// StartStatsCollection runs; rotation fields are always maintained.
 type CircuitStats struct {
 	// AutoRotationEnabled indicates if automatic rotation is running.
 	AutoRotationEnabled bool
 	// RotationInterval is the configured rotation interval (0 if not running).
 	RotationInterval time.Duration
+	// RotationCount is the number of successful rotations by this manager.
+	RotationCount uint64
+	// RotationFailures is the number of rotations Tor rejected or that failed
+	// to reach Tor.
+	RotationFailures uint64
+	// LastRotation is when the last rotation was attempted (zero if never).
+	LastRotation time.Time
+	// LastRotationError is the error of the last rotation, or nil if it succeeded.
+	LastRotationError error
+	// CircuitsBuilt is the number of circuits that reached BUILT.
+	CircuitsBuilt uint64
+	// CircuitsFailed is the number of circuits that FAILED.
+	CircuitsFailed uint64
+	// ActiveCircuits is the number of circuits currently BUILT.
+	ActiveCircuits int
+	// History holds built/failed counts per 10-second window, oldest first,
+	// covering up to the last hour.
+	History []CircuitCountSample
+	// BuildTimeP50 is the median circuit build time.
+	BuildTimeP50 time.Duration
+	// BuildTimeP90 is the 90th percentile circuit build time.
+	BuildTimeP90 time.Duration
+	// BuildTimeP99 is the 99th percentile circuit build time.
+	BuildTimeP99 time.Duration
+	// BuildTimeSamples is the number of build times behind the percentiles.
+	BuildTimeSamples int
+	// Guards lists the first hops of BUILT circuits, most used first.
+	Guards []GuardUsage
 }
 
 // Stats returns current statistics about circuit management.
 func (m *CircuitManager) Stats() CircuitStats {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	return CircuitStats{
+	stats := CircuitStats{
 		AutoRotationEnabled: m.running,
 		RotationInterval:    m.rotationInterval,
 	}
+	m.mu.Unlock()
+
+	m.stats.fill(&stats)
+	return stats
 }
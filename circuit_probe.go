@@ -0,0 +1,568 @@
+package tornago
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// probeEWMAWeight is the weight of a new sample in the moving averages.
+	probeEWMAWeight = 0.3
+	// defaultProbeMinSamples is how many samples a circuit needs before it
+	// may be closed as slow.
+	defaultProbeMinSamples = 3
+	// probeRoutingSpread is how many of the fastest circuits new streams are
+	// spread over when routing.
+	probeRoutingSpread = 3
+	// defaultMaxCircuitDirtiness is Tor's default MaxCircuitDirtiness, used
+	// when the option cannot be read.
+	defaultMaxCircuitDirtiness = 10 * time.Minute
+)
+
+// CircuitPerformance summarizes the measured performance of a BUILT circuit.
+type CircuitPerformance struct {
+	// CircuitID is the Tor circuit identifier.
+	CircuitID string
+	// Path lists the relays of the circuit.
+	Path []string
+	// RTT is a moving average of the time between a stream being sent to the
+	// exit and the exit confirming the connection. Zero until sampled.
+	RTT time.Duration
+	// Samples is the number of streams that contributed to RTT.
+	Samples int
+	// Failures is the number of streams that failed on this circuit.
+	Failures int
+	// Throughput is a moving average of bytes per second while the circuit
+	// carried traffic. Zero if Tor does not report CIRC_BW events.
+	Throughput float64
+}
+
+// ProbeOption customizes StartProbing.
+type ProbeOption func(*probeOptions)
+
+// probeOptions holds StartProbing settings.
+type probeOptions struct {
+	// slowThreshold closes circuits whose RTT exceeds it; zero disables.
+	slowThreshold time.Duration
+	// minSamples is required before a circuit is closed as slow.
+	minSamples int
+	// routing attaches new streams to the fastest circuit.
+	routing bool
+	// probeClient dials probeTarget to generate samples; nil disables.
+	probeClient *Client
+	// probeTarget is the address dialed by active probes.
+	probeTarget string
+	// probeInterval is the time between active probes.
+	probeInterval time.Duration
+}
+
+// WithProbeCloseSlow closes circuits whose average RTT exceeds threshold once
+// they have at least minSamples samples, so Tor replaces them. minSamples
+// below 1 uses the default of 3.
+func WithProbeCloseSlow(threshold time.Duration, minSamples int) ProbeOption {
+	return func(o *probeOptions) {
+		o.slowThreshold = threshold
+		if minSamples > 0 {
+			o.minSamples = minSamples
+		}
+	}
+}
+
+// WithProbeFastestRouting makes the manager attach new streams to the fastest
+// measured circuits instead of letting Tor choose, spreading them round-robin
+// over the three fastest. Circuits are no longer used once Tor applies a
+// NEWNYM, so rotation keeps working, or once they are older than
+// MaxCircuitDirtiness. It sets
+// __LeaveStreamsUnattached while probing runs, so it affects every stream of
+// the Tor instance, bypasses SOCKS stream isolation and leaves new streams
+// hanging if the process dies without resetting the option. Onion service
+// streams, DNS resolve streams and streams without a measured circuit are
+// still handed to Tor.
+func WithProbeFastestRouting() ProbeOption {
+	return func(o *probeOptions) {
+		o.routing = true
+	}
+}
+
+// WithProbeTarget periodically dials target (host:port) through client to
+// measure circuits even when the application is idle. With fastest routing
+// enabled, probe streams are spread over the least-measured circuits.
+func WithProbeTarget(client *Client, target string, interval time.Duration) ProbeOption {
+	return func(o *probeOptions) {
+		o.probeClient = client
+		o.probeTarget = target
+		o.probeInterval = interval
+	}
+}
+
+// circuitPerf is the mutable measurement state of a circuit.
+type circuitPerf struct {
+	// info is the circuit as last reported by Tor.
+	info CircuitInfo
+	// rtt is the moving average RTT.
+	rtt time.Duration
+	// samples counts RTT samples.
+	samples int
+	// failures counts failed streams.
+	failures int
+	// throughput is the moving average of bytes per second.
+	throughput float64
+	// created is when Tor created the circuit, or when it was first seen.
+	created time.Time
+}
+
+// newCircuitPerf starts measuring c, first seen at now.
+func newCircuitPerf(c CircuitInfo, now time.Time) *circuitPerf {
+	created, err := time.Parse(torTimeCreatedLayout, c.TimeCreated)
+	if err != nil || created.After(now) {
+		created = now
+	}
+	return &circuitPerf{info: c, created: created}
+}
+
+// streamTiming tracks a stream between SENTCONNECT and SUCCEEDED.
+type streamTiming struct {
+	// circuitID is the circuit the stream was sent on.
+	circuitID string
+	// sent is when the stream was sent to the exit.
+	sent time.Time
+}
+
+// circuitProbe measures circuits from control-port events.
+type circuitProbe struct {
+	// opts holds the probe settings.
+	opts probeOptions
+	// circuits maps circuit IDs to their measurements.
+	circuits map[string]*circuitPerf
+	// streams maps stream IDs to their timing state.
+	streams map[string]streamTiming
+	// maxDirtiness is Tor's MaxCircuitDirtiness; older circuits are not
+	// used for new streams.
+	maxDirtiness time.Duration
+	// next is the round-robin cursor over the fastest circuits.
+	next int
+	// mu protects circuits, streams and next.
+	mu sync.Mutex
+}
+
+// StartProbing measures the latency and throughput of Tor's circuits from
+// STREAM, CIRC and CIRC_BW events and keeps them ranked for RankedCircuits
+// and FastestCircuit. Options can close consistently slow circuits, route new
+// streams to the fastest circuit and generate probe traffic.
+//
+// Probing runs until ctx is canceled or Stop is called.
+//
+// Example:
+//
+//	err := manager.StartProbing(ctx,
+//	    tornago.WithProbeCloseSlow(2*time.Second, 3),
+//	    tornago.WithProbeTarget(client, "example.com:443", 30*time.Second),
+//	)
+func (m *CircuitManager) StartProbing(ctx context.Context, opts ...ProbeOption) error {
+	o := probeOptions{minSamples: defaultProbeMinSamples}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	if o.probeClient != nil && (o.probeTarget == "" || o.probeInterval <= 0) {
+		return newError(ErrInvalidConfig, opCircuitManager, "probe target requires an address and a positive interval", nil)
+	}
+
+	m.mu.Lock()
+	if m.probe != nil {
+		m.mu.Unlock()
+		return newError(ErrInvalidConfig, opCircuitManager, "probing already running", nil)
+	}
+	probe := &circuitProbe{
+		opts:         o,
+		circuits:     make(map[string]*circuitPerf),
+		streams:      make(map[string]streamTiming),
+		maxDirtiness: defaultMaxCircuitDirtiness,
+	}
+	m.probe = probe
+	m.mu.Unlock()
+
+	fail := func(err error) error {
+		m.mu.Lock()
+		m.probe = nil
+		m.mu.Unlock()
+		return err
+	}
+
+	probeCtx, cancel := context.WithCancel(ctx)
+	handler := func(ev ControlEvent) { m.handleProbeEvent(probeCtx, probe, ev) }
+	sub, err := m.control.SubscribeEvents(probeCtx, handler, "CIRC", "STREAM", "CIRC_BW", "SIGNAL")
+	if err != nil {
+		// CIRC_BW is missing from old Tor versions; measure RTT only.
+		sub, err = m.control.SubscribeEvents(probeCtx, handler, "CIRC", "STREAM", "SIGNAL")
+	}
+	if err != nil {
+		cancel()
+		return fail(err)
+	}
+
+	circuits, err := m.control.GetCircuitStatus(ctx)
+	if err != nil {
+		cancel()
+		_ = sub.Close()
+		return fail(err)
+	}
+	now := time.Now()
+	probe.mu.Lock()
+	for _, c := range circuits {
+		if c.Status == "BUILT" && isExitCircuit(c) {
+			if _, ok := probe.circuits[c.ID]; !ok {
+				probe.circuits[c.ID] = newCircuitPerf(c, now)
+			}
+		}
+	}
+	probe.mu.Unlock()
+
+	if o.routing {
+		if value, err := m.control.GetConf(ctx, "MaxCircuitDirtiness"); err == nil {
+			if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && secs > 0 {
+				probe.mu.Lock()
+				probe.maxDirtiness = time.Duration(secs) * time.Second
+				probe.mu.Unlock()
+			}
+		}
+		if err := m.control.SetConf(ctx, "__LeaveStreamsUnattached", "1"); err != nil {
+			cancel()
+			_ = sub.Close()
+			return fail(err)
+		}
+	}
+
+	m.logger.Log("info", "circuit probing started", "circuits", len(circuits), "routing", o.routing)
+	go m.probeLoop(probeCtx, cancel, probe, sub)
+	return nil
+}
+
+// probeLoop runs active probes and tears probing down when it ends.
+func (m *CircuitManager) probeLoop(ctx context.Context, cancel context.CancelFunc, probe *circuitProbe, sub *EventSubscription) {
+	defer func() {
+		cancel()
+		_ = sub.Close()
+		if probe.opts.routing {
+			resetCtx, resetCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := m.control.ResetConf(resetCtx, "__LeaveStreamsUnattached"); err != nil {
+				m.logger.Log("error", "failed to reset __LeaveStreamsUnattached", "error", err)
+			}
+			resetCancel()
+		}
+		m.mu.Lock()
+		m.probe = nil
+		m.mu.Unlock()
+		m.logger.Log("info", "circuit probing stopped")
+	}()
+
+	var tick <-chan time.Time
+	if probe.opts.probeClient != nil {
+		ticker := time.NewTicker(probe.opts.probeInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stopCh:
+			return
+		case <-sub.Done():
+			m.logger.Log("error", "circuit probing event stream ended", "error", sub.Err())
+			return
+		case <-tick:
+			go m.runProbe(ctx, probe.opts)
+		}
+	}
+}
+
+// runProbe dials the probe target once; the measurement comes from events.
+func (m *CircuitManager) runProbe(ctx context.Context, o probeOptions) {
+	conn, err := o.probeClient.DialContext(ctx, "tcp", o.probeTarget)
+	if err != nil {
+		m.logger.Log("debug", "circuit probe failed", "target", o.probeTarget, "error", err)
+		return
+	}
+	_ = conn.Close()
+}
+
+// handleProbeEvent updates measurements and attaches streams.
+func (m *CircuitManager) handleProbeEvent(ctx context.Context, probe *circuitProbe, ev ControlEvent) {
+	switch ev.Type {
+	case "CIRC":
+		probe.updateCircuit(parseCircuitLine(ev.Data))
+	case "CIRC_BW":
+		probe.recordBandwidth(ev.Data)
+	case "SIGNAL":
+		if strings.TrimSpace(ev.Data) == "NEWNYM" {
+			probe.forgetCircuits()
+		}
+	case "STREAM":
+		stream := parseStreamLine(ev.Data)
+		if slow := probe.recordStream(stream, time.Now()); slow != "" {
+			m.closeSlowCircuit(ctx, probe, slow)
+		}
+		if probe.opts.routing && (stream.Status == "NEW" || stream.Status == "NEWRESOLVE" || stream.Status == "DETACHED") {
+			m.attachProbedStream(ctx, probe, stream)
+		}
+	}
+}
+
+// attachProbedStream attaches a stream left unattached by Tor. Resolve
+// streams (SOCKS RESOLVE and DNSPort lookups) are handed back to Tor with
+// circuit 0, as are detached and non-user streams.
+func (m *CircuitManager) attachProbedStream(ctx context.Context, probe *circuitProbe, stream StreamInfo) {
+	circuitID := "0"
+	if stream.Status == "NEW" && (stream.Purpose == "" || stream.Purpose == "USER") && !isOnionTarget(stream.Target) {
+		if stream.Target == probe.opts.probeTarget && probe.opts.probeTarget != "" {
+			circuitID = probe.leastSampled(time.Now())
+		} else {
+			circuitID = probe.pickFast(time.Now())
+		}
+	}
+	if err := m.control.AttachStream(ctx, stream.ID, circuitID); err != nil && circuitID != "0" {
+		m.logger.Log("debug", "stream attach failed, deferring to Tor", "stream_id", stream.ID, "circuit_id", circuitID, "error", err)
+		_ = m.control.AttachStream(ctx, stream.ID, "0")
+	}
+}
+
+// closeSlowCircuit closes a circuit that is consistently slow.
+func (m *CircuitManager) closeSlowCircuit(ctx context.Context, probe *circuitProbe, circuitID string) {
+	m.logger.Log("info", "closing slow circuit", "circuit_id", circuitID)
+	probe.mu.Lock()
+	delete(probe.circuits, circuitID)
+	probe.mu.Unlock()
+	if err := m.control.CloseCircuit(ctx, circuitID); err != nil {
+		m.logger.Log("warn", "failed to close slow circuit", "circuit_id", circuitID, "error", err)
+	}
+}
+
+// updateCircuit tracks circuits as they are built and closed.
+func (p *circuitProbe) updateCircuit(c CircuitInfo) {
+	if c.ID == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch c.Status {
+	case "BUILT":
+		if !isExitCircuit(c) {
+			return
+		}
+		if perf, ok := p.circuits[c.ID]; ok {
+			perf.info = c
+		} else {
+			p.circuits[c.ID] = newCircuitPerf(c, time.Now())
+		}
+	case "CLOSED", "FAILED":
+		delete(p.circuits, c.ID)
+	}
+}
+
+// forgetCircuits drops every known circuit once Tor applies NEWNYM, which
+// marks them all dirty, so new streams are not attached to them.
+func (p *circuitProbe) forgetCircuits() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.circuits)
+}
+
+// recordStream records stream timings. It returns the ID of a circuit that
+// should be closed as slow, if any.
+func (p *circuitProbe) recordStream(s StreamInfo, now time.Time) string {
+	if s.ID == "" {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch s.Status {
+	case "SENTCONNECT":
+		p.streams[s.ID] = streamTiming{circuitID: s.CircuitID, sent: now}
+	case "SUCCEEDED":
+		timing, ok := p.streams[s.ID]
+		delete(p.streams, s.ID)
+		perf := p.circuits[timing.circuitID]
+		if !ok || perf == nil {
+			return ""
+		}
+		perf.rtt = ewmaDuration(perf.rtt, now.Sub(timing.sent), perf.samples == 0)
+		perf.samples++
+		o := p.opts
+		if o.slowThreshold > 0 && perf.samples >= o.minSamples && perf.rtt > o.slowThreshold {
+			return timing.circuitID
+		}
+	case "FAILED", "DETACHED":
+		if timing, ok := p.streams[s.ID]; ok {
+			if perf := p.circuits[timing.circuitID]; perf != nil {
+				perf.failures++
+			}
+		}
+		delete(p.streams, s.ID)
+	case "CLOSED":
+		delete(p.streams, s.ID)
+	}
+	return ""
+}
+
+// recordBandwidth records a CIRC_BW event
+// ("ID=5 READ=1024 WRITTEN=512 TIME=2025-01-01T00:00:00.000000").
+func (p *circuitProbe) recordBandwidth(data string) {
+	var id string
+	var total int64
+	for _, field := range strings.Fields(data) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "ID":
+			id = value
+		case "READ", "WRITTEN":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				total += n
+			}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	perf := p.circuits[id]
+	if perf == nil || total == 0 {
+		return
+	}
+	// Tor emits CIRC_BW once per second for circuits that carried traffic.
+	if perf.throughput == 0 {
+		perf.throughput = float64(total)
+		return
+	}
+	perf.throughput += probeEWMAWeight * (float64(total) - perf.throughput)
+}
+
+// ranked returns the circuits ordered fastest first. Measured circuits come
+// before unmeasured ones.
+func (p *circuitProbe) ranked() []CircuitPerformance {
+	return p.rank(time.Time{})
+}
+
+// rank returns the circuits ordered fastest first. A non-zero now skips
+// circuits older than maxDirtiness, which Tor no longer uses for new streams.
+func (p *circuitProbe) rank(now time.Time) []CircuitPerformance {
+	p.mu.Lock()
+	result := make([]CircuitPerformance, 0, len(p.circuits))
+	for id, perf := range p.circuits {
+		if !now.IsZero() && now.Sub(perf.created) >= p.maxDirtiness {
+			continue
+		}
+		result = append(result, CircuitPerformance{
+			CircuitID:  id,
+			Path:       slices.Clone(perf.info.Path),
+			RTT:        perf.rtt,
+			Samples:    perf.samples,
+			Failures:   perf.failures,
+			Throughput: perf.throughput,
+		})
+	}
+	p.mu.Unlock()
+	slices.SortFunc(result, func(a, b CircuitPerformance) int {
+		if (a.Samples == 0) != (b.Samples == 0) {
+			if a.Samples == 0 {
+				return 1
+			}
+			return -1
+		}
+		if c := cmp.Compare(a.RTT, b.RTT); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Throughput, a.Throughput); c != 0 {
+			return c
+		}
+		return compareControlIDs(a.CircuitID, b.CircuitID)
+	})
+	return result
+}
+
+// pickFast returns the ID of one of the fastest measured circuits that are
+// not too dirty at now, cycling through them, or "0".
+func (p *circuitProbe) pickFast(now time.Time) string {
+	ranked := p.rank(now)
+	n := 0
+	for n < len(ranked) && n < probeRoutingSpread && ranked[n].Samples > 0 {
+		n++
+	}
+	if n == 0 {
+		return "0"
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := ranked[p.next%n].CircuitID
+	p.next++
+	return id
+}
+
+// leastSampled returns the ID of the circuit with the fewest samples that is
+// not too dirty at now, or "0".
+func (p *circuitProbe) leastSampled(now time.Time) string {
+	ranked := p.rank(now)
+	if len(ranked) == 0 {
+		return "0"
+	}
+	best := ranked[0]
+	for _, c := range ranked[1:] {
+		if c.Samples < best.Samples {
+			best = c
+		}
+	}
+	return best.CircuitID
+}
+
+// RankedCircuits returns the BUILT exit circuits seen by StartProbing, fastest
+// first. Circuits without RTT samples are listed last, and circuits made stale
+// by a NEWNYM are dropped. It returns nil when probing is not running.
+func (m *CircuitManager) RankedCircuits() []CircuitPerformance {
+	m.mu.Lock()
+	probe := m.probe
+	m.mu.Unlock()
+	if probe == nil {
+		return nil
+	}
+	return probe.ranked()
+}
+
+// FastestCircuit returns the measured circuit with the lowest RTT. The second
+// result is false when probing is not running or no circuit was measured yet.
+func (m *CircuitManager) FastestCircuit() (CircuitPerformance, bool) {
+	ranked := m.RankedCircuits()
+	if len(ranked) == 0 || ranked[0].Samples == 0 {
+		return CircuitPerformance{}, false
+	}
+	return ranked[0], true
+}
+
+// ewmaDuration folds sample into avg. The first sample replaces avg.
+func ewmaDuration(avg, sample time.Duration, first bool) time.Duration {
+	if first {
+		return sample
+	}
+	return avg + time.Duration(probeEWMAWeight*float64(sample-avg))
+}
+
+// isOnionTarget reports whether a stream target is an onion service.
+func isOnionTarget(target string) bool {
+	host := target
+	if i := strings.LastIndex(target, ":"); i >= 0 {
+		host = target[:i]
+	}
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
+
+// compareControlIDs orders numeric control IDs numerically.
+func compareControlIDs(a, b string) int {
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,247 @@
+package tornago
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCircuitProbeMeasurements(t *testing.T) {
+	newProbe := func(opts probeOptions) *circuitProbe {
+		if opts.minSamples == 0 {
+			opts.minSamples = defaultProbeMinSamples
+		}
+		p := &circuitProbe{
+			opts:         opts,
+			circuits:     make(map[string]*circuitPerf),
+			streams:      make(map[string]streamTiming),
+			maxDirtiness: defaultMaxCircuitDirtiness,
+		}
+		for _, id := range []string{"1", "2", "10"} {
+			p.updateCircuit(CircuitInfo{ID: id, Status: "BUILT", Purpose: "GENERAL"})
+		}
+		p.updateCircuit(CircuitInfo{ID: "3", Status: "BUILT", BuildFlags: []string{"IS_INTERNAL"}, Purpose: "GENERAL"})
+		return p
+	}
+	sample := func(p *circuitProbe, stream, circuit string, rtt time.Duration) string {
+		start := time.Now()
+		p.recordStream(StreamInfo{ID: stream, Status: "SENTCONNECT", CircuitID: circuit}, start)
+		return p.recordStream(StreamInfo{ID: stream, Status: "SUCCEEDED", CircuitID: circuit}, start.Add(rtt))
+	}
+
+	t.Run("should rank measured circuits by RTT", func(t *testing.T) {
+		p := newProbe(probeOptions{})
+		sample(p, "100", "2", 300*time.Millisecond)
+		sample(p, "101", "10", 100*time.Millisecond)
+		sample(p, "102", "10", 200*time.Millisecond)
+		p.recordStream(StreamInfo{ID: "103", Status: "SENTCONNECT", CircuitID: "2"}, time.Now())
+		p.recordStream(StreamInfo{ID: "103", Status: "FAILED", CircuitID: "2"}, time.Now())
+		p.recordBandwidth("ID=10 READ=4096 WRITTEN=1024 TIME=2025-01-01T00:00:00.000000")
+
+		ranked := p.ranked()
+		ids := make([]string, 0, len(ranked))
+		for _, c := range ranked {
+			ids = append(ids, c.CircuitID)
+		}
+		if want := []string{"10", "2", "1"}; !slices.Equal(ids, want) {
+			t.Fatalf("ranking = %v, want %v", ids, want)
+		}
+		if ranked[0].RTT != 130*time.Millisecond || ranked[0].Samples != 2 || ranked[0].Throughput != 5120 {
+			t.Errorf("unexpected fastest circuit: %+v", ranked[0])
+		}
+		if ranked[1].Failures != 1 {
+			t.Errorf("expected failure to be counted, got %+v", ranked[1])
+		}
+		now := time.Now()
+		if got := p.leastSampled(now); got != "1" {
+			t.Errorf("leastSampled=%s, want 1", got)
+		}
+		// Streams are spread over the measured circuits, fastest first.
+		picks := []string{p.pickFast(now), p.pickFast(now), p.pickFast(now)}
+		if want := []string{"10", "2", "10"}; !slices.Equal(picks, want) {
+			t.Errorf("picks = %v, want %v", picks, want)
+		}
+	})
+
+	t.Run("should not pick circuits after NEWNYM", func(t *testing.T) {
+		p := newProbe(probeOptions{})
+		sample(p, "100", "10", 100*time.Millisecond)
+		p.forgetCircuits()
+		if got := p.pickFast(time.Now()); got != "0" {
+			t.Errorf("picked stale circuit %s after NEWNYM", got)
+		}
+		p.updateCircuit(CircuitInfo{ID: "11", Status: "BUILT", Purpose: "GENERAL"})
+		sample(p, "101", "11", 100*time.Millisecond)
+		if got := p.pickFast(time.Now()); got != "11" {
+			t.Errorf("pickFast = %s, want the fresh circuit 11", got)
+		}
+	})
+
+	t.Run("should skip circuits older than MaxCircuitDirtiness", func(t *testing.T) {
+		p := newProbe(probeOptions{})
+		old := time.Now().UTC().Add(-time.Hour).Format(torTimeCreatedLayout)
+		p.updateCircuit(CircuitInfo{ID: "20", Status: "BUILT", Purpose: "GENERAL", TimeCreated: old})
+		sample(p, "100", "20", 10*time.Millisecond)
+		sample(p, "101", "2", 500*time.Millisecond)
+		if got := p.pickFast(time.Now()); got != "2" {
+			t.Errorf("pickFast = %s, want 2 instead of the dirty circuit 20", got)
+		}
+		if got := p.ranked()[0].CircuitID; got != "20" {
+			t.Errorf("ranked()[0] = %s, dirty circuits should still be ranked", got)
+		}
+	})
+
+	t.Run("should forget closed circuits", func(t *testing.T) {
+		p := newProbe(probeOptions{})
+		p.updateCircuit(CircuitInfo{ID: "1", Status: "CLOSED"})
+		if len(p.ranked()) != 2 {
+			t.Errorf("expected two circuits, got %+v", p.ranked())
+		}
+		if p.pickFast(time.Now()) != "0" {
+			t.Error("expected no fastest circuit without samples")
+		}
+	})
+
+	t.Run("should flag consistently slow circuits", func(t *testing.T) {
+		p := newProbe(probeOptions{slowThreshold: time.Second, minSamples: 2})
+		if slow := sample(p, "100", "1", 3*time.Second); slow != "" {
+			t.Fatal("flagged before enough samples")
+		}
+		if slow := sample(p, "101", "1", 2*time.Second); slow != "1" {
+			t.Fatalf("expected circuit 1 to be flagged, got %q", slow)
+		}
+		if slow := sample(p, "102", "2", 100*time.Millisecond); slow != "" {
+			t.Errorf("fast circuit flagged as %q", slow)
+		}
+	})
+}
+
+func TestCircuitManagerStartProbing(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(cmd, "AUTHENTICATE") {
+			return ""
+		}
+		commands = append(commands, cmd)
+		switch cmd {
+		case "GETINFO circuit-status":
+			return "250+circuit-status=\r\n1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n.\r\n250 OK\r\n"
+		case "GETCONF MaxCircuitDirtiness":
+			return "250 MaxCircuitDirtiness=600\r\n"
+		case "SETEVENTS CIRC STREAM CIRC_BW SIGNAL":
+			return "250 OK\r\n" +
+				"650 CIRC 2 BUILT $" + testRelayFingerprintB + "~beta PURPOSE=GENERAL\r\n" +
+				"650 STREAM 20 SENTCONNECT 2 example.com:443\r\n" +
+				"650 STREAM 20 SUCCEEDED 2 example.com:443\r\n" +
+				"650 STREAM 21 NEW 0 example.net:443 SOURCE_ADDR=127.0.0.1:5000 PURPOSE=USER\r\n" +
+				"650 STREAM 22 NEW 0 exampleonionaddress.onion:80 PURPOSE=USER\r\n" +
+				"650 STREAM 23 DETACHED 2 example.org:80 REASON=EXITPOLICY\r\n" +
+				"650 STREAM 24 NEWRESOLVE 0 example.com:0 SOURCE_ADDR=127.0.0.1:5353 PURPOSE=DNS_REQUEST\r\n" +
+				"650 SIGNAL NEWNYM\r\n" +
+				"650 STREAM 25 NEW 0 example.net:443 SOURCE_ADDR=127.0.0.1:5001 PURPOSE=USER\r\n"
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+
+	manager := NewCircuitManager(ctrl)
+	if _, ok := manager.FastestCircuit(); ok {
+		t.Error("expected no fastest circuit before probing")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := manager.StartProbing(ctx, WithProbeFastestRouting()); err != nil {
+		t.Fatalf("StartProbing failed: %v", err)
+	}
+	if err := manager.StartProbing(ctx); err == nil {
+		t.Error("expected error when probing twice")
+	}
+
+	attached := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var out []string
+		for _, c := range commands {
+			if strings.HasPrefix(c, "ATTACHSTREAM") {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(attached()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []string{"ATTACHSTREAM 21 2", "ATTACHSTREAM 22 0", "ATTACHSTREAM 23 0", "ATTACHSTREAM 24 0", "ATTACHSTREAM 25 0"}
+	if got := attached(); !slices.Equal(got, want) {
+		t.Errorf("attach commands = %q, want %q", got, want)
+	}
+	// Stream 25 arrived after NEWNYM, which made circuits 1 and 2 stale.
+	if fastest, ok := manager.FastestCircuit(); ok {
+		t.Errorf("expected no fastest circuit after NEWNYM, got %+v", fastest)
+	}
+	if ranked := manager.RankedCircuits(); len(ranked) != 0 {
+		t.Errorf("expected no ranked circuits after NEWNYM, got %+v", ranked)
+	}
+
+	cancel()
+	deadline = time.Now().Add(2 * time.Second)
+	for manager.RankedCircuits() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(commands, `SETCONF __LeaveStreamsUnattached="1"`) {
+		t.Error("expected __LeaveStreamsUnattached to be set")
+	}
+	if !slices.Contains(commands, "RESETCONF __LeaveStreamsUnattached") {
+		t.Error("expected __LeaveStreamsUnattached to be reset")
+	}
+}
+
+func TestCircuitControlCommands(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(cmd, "AUTHENTICATE") {
+			commands = append(commands, cmd)
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+	ctx := context.Background()
+
+	if err := ctrl.CloseCircuit(ctx, "7"); err != nil {
+		t.Fatalf("CloseCircuit failed: %v", err)
+	}
+	if err := ctrl.AttachStream(ctx, "12", "7"); err != nil {
+		t.Fatalf("AttachStream failed: %v", err)
+	}
+	if err := ctrl.CloseCircuit(ctx, "7 IfUnused"); err == nil {
+		t.Error("expected error for invalid circuit ID")
+	}
+	if err := ctrl.AttachStream(ctx, "", "7"); err == nil {
+		t.Error("expected error for empty stream ID")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"CLOSECIRCUIT 7", "ATTACHSTREAM 12 7"}; !slices.Equal(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
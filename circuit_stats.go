@@ -0,0 +1,312 @@
+package tornago
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBuildTimeSamples bounds the build times kept for percentiles.
+	maxBuildTimeSamples = 1000
+	// maxCircuitHistory bounds the circuit count snapshots kept by Stats.
+	maxCircuitHistory = 360
+	// circuitHistoryInterval is the spacing of circuit count snapshots.
+	circuitHistoryInterval = 10 * time.Second
+	// torTimeCreatedLayout is the format of TIME_CREATED in circuit events.
+	torTimeCreatedLayout = "2006-01-02T15:04:05.999999"
+)
+
+// CircuitCountSample is a point-in-time count of circuit outcomes.
+type CircuitCountSample struct {
+	// Time is when the sample was taken.
+	Time time.Time
+	// Built is the number of circuits that reached BUILT since the previous sample.
+	Built uint64
+	// Failed is the number of circuits that FAILED since the previous sample.
+	Failed uint64
+}
+
+// circuitStatsState accumulates rotation and circuit statistics.
+type circuitStatsState struct {
+	// rotations counts successful NEWNYM rotations.
+	rotations uint64
+	// rotationFailures counts NEWNYM attempts that returned an error.
+	rotationFailures uint64
+	// lastRotation is when the last rotation was attempted.
+	lastRotation time.Time
+	// lastRotationErr is the result of the last rotation attempt.
+	lastRotationErr error
+	// built counts circuits that reached BUILT while collecting.
+	built uint64
+	// failed counts circuits that FAILED while collecting.
+	failed uint64
+	// buildTimes holds recent build durations, oldest first.
+	buildTimes []time.Duration
+	// launched maps circuit IDs to when they were launched.
+	launched map[string]time.Time
+	// active maps BUILT circuit IDs to their first hop.
+	active map[string]string
+	// history holds periodic circuit count samples, oldest first.
+	history []CircuitCountSample
+	// sampleBuilt and sampleFailed count outcomes since the last sample.
+	sampleBuilt, sampleFailed uint64
+	// collecting reports whether StartStatsCollection is running.
+	collecting bool
+	// mu protects all fields.
+	mu sync.Mutex
+}
+
+// newCircuitStatsState returns empty statistics.
+func newCircuitStatsState() *circuitStatsState {
+	return &circuitStatsState{
+		launched: make(map[string]time.Time),
+		active:   make(map[string]string),
+	}
+}
+
+// recordRotation records the result of a NEWNYM attempt.
+func (s *circuitStatsState) recordRotation(at time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRotation = at
+	s.lastRotationErr = err
+	if err != nil {
+		s.rotationFailures++
+		return
+	}
+	s.rotations++
+}
+
+// recordCircuit updates counts from a circuit event observed at now. When the
+// circuit just became BUILT, built is true and buildTime holds its build
+// duration, or -1 if it is unknown.
+func (s *circuitStatsState) recordCircuit(c CircuitInfo, now time.Time) (buildTime time.Duration, built bool) {
+	if c.ID == "" {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch c.Status {
+	case "LAUNCHED":
+		s.launched[c.ID] = now
+	case "BUILT":
+		if _, ok := s.active[c.ID]; ok {
+			return 0, false
+		}
+		s.active[c.ID] = firstHop(c)
+		s.built++
+		s.sampleBuilt++
+		start, ok := s.launched[c.ID]
+		delete(s.launched, c.ID)
+		if !ok {
+			created, err := time.Parse(torTimeCreatedLayout, c.TimeCreated)
+			if err != nil {
+				return -1, true
+			}
+			start = created
+		}
+		d := now.Sub(start)
+		if d < 0 {
+			return -1, true
+		}
+		s.buildTimes = append(s.buildTimes, d)
+		if len(s.buildTimes) > maxBuildTimeSamples {
+			s.buildTimes = s.buildTimes[len(s.buildTimes)-maxBuildTimeSamples:]
+		}
+		return d, true
+	case "FAILED":
+		s.failed++
+		s.sampleFailed++
+		delete(s.launched, c.ID)
+		delete(s.active, c.ID)
+	case "CLOSED":
+		delete(s.launched, c.ID)
+		delete(s.active, c.ID)
+	}
+	return 0, false
+}
+
+// takeSample appends a circuit count sample taken at now.
+func (s *circuitStatsState) takeSample(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, CircuitCountSample{Time: now, Built: s.sampleBuilt, Failed: s.sampleFailed})
+	if len(s.history) > maxCircuitHistory {
+		s.history = s.history[len(s.history)-maxCircuitHistory:]
+	}
+	s.sampleBuilt, s.sampleFailed = 0, 0
+}
+
+// fill copies the accumulated statistics into stats.
+func (s *circuitStatsState) fill(stats *CircuitStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats.RotationCount = s.rotations
+	stats.RotationFailures = s.rotationFailures
+	stats.LastRotation = s.lastRotation
+	stats.LastRotationError = s.lastRotationErr
+	stats.CircuitsBuilt = s.built
+	stats.CircuitsFailed = s.failed
+	stats.ActiveCircuits = len(s.active)
+	stats.History = slices.Clone(s.history)
+	stats.BuildTimeSamples = len(s.buildTimes)
+	sorted := slices.Clone(s.buildTimes)
+	slices.Sort(sorted)
+	stats.BuildTimeP50 = percentile(sorted, 50)
+	stats.BuildTimeP90 = percentile(sorted, 90)
+	stats.BuildTimeP99 = percentile(sorted, 99)
+
+	guards := make(map[string]int)
+	for _, hop := range s.active {
+		if hop != "" {
+			guards[hop]++
+		}
+	}
+	stats.Guards = make([]GuardUsage, 0, len(guards))
+	for hop, n := range guards {
+		stats.Guards = append(stats.Guards, GuardUsage{Relay: hop, Circuits: n})
+	}
+	slices.SortFunc(stats.Guards, func(a, b GuardUsage) int {
+		if c := cmp.Compare(b.Circuits, a.Circuits); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Relay, b.Relay)
+	})
+}
+
+// GuardUsage reports how many BUILT circuits use a relay as their first hop.
+type GuardUsage struct {
+	// Relay is the first hop as reported by Tor ("$FINGERPRINT~nickname").
+	Relay string
+	// Circuits is the number of BUILT circuits through Relay.
+	Circuits int
+}
+
+// isCollecting reports whether StartStatsCollection is running.
+func (s *circuitStatsState) isCollecting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.collecting
+}
+
+// StartStatsCollection subscribes to CIRC events and keeps circuit counts,
+// build-time percentiles and guard usage for Stats until ctx is canceled or
+// Stop is called. Rotation counts are recorded whether or not collection runs.
+//
+// Example:
+//
+//	_ = manager.StartStatsCollection(ctx)
+//	// later
+//	stats := manager.Stats()
+//	if stats.RotationFailures > 0 {
+//	    log.Printf("last rotation failed: %v", stats.LastRotationError)
+//	}
+func (m *CircuitManager) StartStatsCollection(ctx context.Context) error {
+	s := m.stats
+	s.mu.Lock()
+	if s.collecting {
+		s.mu.Unlock()
+		return newError(ErrInvalidConfig, opCircuitManager, "stats collection already running", nil)
+	}
+	s.collecting = true
+	s.mu.Unlock()
+
+	stop := func() {
+		s.mu.Lock()
+		s.collecting = false
+		s.mu.Unlock()
+	}
+
+	sub, err := m.control.SubscribeEvents(ctx, func(ev ControlEvent) {
+		if ev.Type != "CIRC" {
+			return
+		}
+		circuit := parseCircuitLine(ev.Data)
+		if d, ok := s.recordCircuit(circuit, time.Now()); ok {
+			if metrics := m.metricsCollector(); metrics != nil {
+				metrics.recordCircuitBuilt(d)
+			}
+		} else if circuit.Status == "FAILED" {
+			if metrics := m.metricsCollector(); metrics != nil {
+				metrics.recordCircuitFailed()
+			}
+		}
+	}, "CIRC")
+	if err != nil {
+		stop()
+		return err
+	}
+
+	circuits, err := m.control.GetCircuitStatus(ctx)
+	if err != nil {
+		_ = sub.Close()
+		stop()
+		return err
+	}
+	s.mu.Lock()
+	for _, c := range circuits {
+		if c.Status == "BUILT" {
+			s.active[c.ID] = firstHop(c)
+		}
+	}
+	s.mu.Unlock()
+
+	go func() {
+		defer stop()
+		defer sub.Close()
+		ticker := time.NewTicker(circuitHistoryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.stopCh:
+				return
+			case <-sub.Done():
+				m.logger.Log("error", "circuit stats event stream ended", "error", sub.Err())
+				return
+			case now := <-ticker.C:
+				s.takeSample(now)
+			}
+		}
+	}()
+	m.logger.Log("info", "circuit stats collection started")
+	return nil
+}
+
+// WithMetrics makes the manager report rotations and circuit outcomes to
+// metrics, typically the collector shared with the Client.
+func (m *CircuitManager) WithMetrics(metrics *MetricsCollector) *CircuitManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = metrics
+	return m
+}
+
+// metricsCollector returns the configured MetricsCollector, if any.
+func (m *CircuitManager) metricsCollector() *MetricsCollector {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metrics
+}
+
+// firstHop returns the first relay of a circuit path.
+func firstHop(c CircuitInfo) string {
+	if len(c.Path) == 0 {
+		return ""
+	}
+	return c.Path[0]
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
@@ -0,0 +1,158 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitStatsState(t *testing.T) {
+	t.Run("should track build times, failures and guards", func(t *testing.T) {
+		s := newCircuitStatsState()
+		start := time.Now()
+		guardA := "$" + testRelayFingerprintA + "~alpha"
+		guardB := "$" + testRelayFingerprintB + "~beta"
+		for i, d := range []time.Duration{100, 200, 300, 400} {
+			id := string(rune('1' + i))
+			s.recordCircuit(CircuitInfo{ID: id, Status: "LAUNCHED"}, start)
+			guard := guardA
+			if i == 3 {
+				guard = guardB
+			}
+			got, built := s.recordCircuit(CircuitInfo{ID: id, Status: "BUILT", Path: []string{guard}}, start.Add(d*time.Millisecond))
+			if !built || got != d*time.Millisecond {
+				t.Fatalf("circuit %s: got %v %v", id, got, built)
+			}
+		}
+		if _, built := s.recordCircuit(CircuitInfo{ID: "1", Status: "BUILT"}, start); built {
+			t.Error("repeated BUILT must not be counted twice")
+		}
+		created := start.UTC().Add(-time.Second).Format(torTimeCreatedLayout)
+		d, built := s.recordCircuit(CircuitInfo{ID: "9", Status: "BUILT", TimeCreated: created}, start)
+		if !built || d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Errorf("expected build time from TIME_CREATED, got %v %v", d, built)
+		}
+		if d, built := s.recordCircuit(CircuitInfo{ID: "10", Status: "BUILT"}, start); !built || d != -1 {
+			t.Errorf("expected unknown build time, got %v %v", d, built)
+		}
+		s.recordCircuit(CircuitInfo{ID: "11", Status: "FAILED"}, start)
+		s.recordCircuit(CircuitInfo{ID: "9", Status: "CLOSED"}, start)
+		s.recordCircuit(CircuitInfo{ID: "10", Status: "CLOSED"}, start)
+		s.takeSample(start)
+		s.recordRotation(start, nil)
+		s.recordRotation(start.Add(time.Second), errors.New("refused"))
+
+		var stats CircuitStats
+		s.fill(&stats)
+		if stats.CircuitsBuilt != 6 || stats.CircuitsFailed != 1 || stats.ActiveCircuits != 4 {
+			t.Errorf("unexpected counts: %+v", stats)
+		}
+		if stats.BuildTimeSamples != 5 || stats.BuildTimeP50 != 300*time.Millisecond || stats.BuildTimeP99 < 900*time.Millisecond {
+			t.Errorf("unexpected build times: p50=%v p99=%v n=%d", stats.BuildTimeP50, stats.BuildTimeP99, stats.BuildTimeSamples)
+		}
+		if len(stats.Guards) != 2 || stats.Guards[0].Relay != guardA || stats.Guards[0].Circuits != 3 {
+			t.Errorf("unexpected guards: %+v", stats.Guards)
+		}
+		if len(stats.History) != 1 || stats.History[0].Built != 6 || stats.History[0].Failed != 1 {
+			t.Errorf("unexpected history: %+v", stats.History)
+		}
+		if stats.RotationCount != 1 || stats.RotationFailures != 1 || stats.LastRotationError == nil {
+			t.Errorf("unexpected rotation stats: %+v", stats)
+		}
+	})
+
+	t.Run("should compute nearest-rank percentiles", func(t *testing.T) {
+		if percentile(nil, 50) != 0 {
+			t.Error("expected zero for no samples")
+		}
+		sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+		if percentile(sorted, 50) != 5 || percentile(sorted, 90) != 9 || percentile(sorted, 99) != 10 {
+			t.Errorf("unexpected percentiles: %v %v %v", percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99))
+		}
+	})
+}
+
+func TestCircuitManagerStatsCollection(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO circuit-status":
+			return "250+circuit-status=\r\n1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n.\r\n250 OK\r\n"
+		case "SETEVENTS CIRC":
+			return "250 OK\r\n" +
+				"650 CIRC 2 LAUNCHED PURPOSE=GENERAL\r\n" +
+				"650 CIRC 2 BUILT $" + testRelayFingerprintB + "~beta PURPOSE=GENERAL\r\n" +
+				"650 CIRC 3 LAUNCHED PURPOSE=GENERAL\r\n" +
+				"650 CIRC 3 FAILED REASON=TIMEOUT\r\n"
+		case "SIGNAL NEWNYM":
+			return "552 Unrecognized signal\r\n"
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+
+	metrics := NewMetricsCollector()
+	manager := NewCircuitManager(ctrl).WithMetrics(metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := manager.StartStatsCollection(ctx); err != nil {
+		t.Fatalf("StartStatsCollection failed: %v", err)
+	}
+	if err := manager.StartStatsCollection(ctx); err == nil {
+		t.Error("expected error when collecting twice")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.Stats().CircuitsFailed == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := manager.RotateNow(ctx); err == nil {
+		t.Fatal("expected rotation to fail")
+	}
+
+	stats := manager.Stats()
+	if stats.CircuitsBuilt != 1 || stats.CircuitsFailed != 1 || stats.ActiveCircuits != 2 || len(stats.Guards) != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.RotationFailures != 1 || stats.RotationCount != 0 || stats.LastRotation.IsZero() {
+		t.Errorf("unexpected rotation stats: %+v", stats)
+	}
+	if metrics.CircuitBuildCount() != 1 || metrics.CircuitFailureCount() != 1 || metrics.RotationFailureCount() != 1 || metrics.LastRotation().IsZero() {
+		t.Errorf("unexpected metrics: built=%d failed=%d rotfail=%d", metrics.CircuitBuildCount(), metrics.CircuitFailureCount(), metrics.RotationFailureCount())
+	}
+}
+
+func TestCircuitManagerStopEndsStatsCollection(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO circuit-status":
+			return "250+circuit-status=\r\n.\r\n250 OK\r\n"
+		case "SETEVENTS CIRC":
+			return "250 OK\r\n"
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+
+	manager := NewCircuitManager(ctrl)
+	if err := manager.StartStatsCollection(context.Background()); err != nil {
+		t.Fatalf("StartStatsCollection failed: %v", err)
+	}
+	manager.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.stats.isCollecting() {
+		if time.Now().After(deadline) {
+			t.Fatal("stats collection still running after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
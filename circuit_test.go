@@ -2,6 +2,9 @@ package tornago
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 )
@@ -220,3 +223,169 @@ func TestCircuitManager(t *testing.T) {
 		}
 	})
 }
+
+func TestCircuitManagerNewnymRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	newnyms := 0
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if cmd == "SIGNAL NEWNYM" {
+			newnyms++
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+	logger := &recordingLogger{}
+	manager := NewCircuitManager(ctrl).WithLogger(logger)
+	ctx := context.Background()
+
+	if err := manager.RotateNow(ctx); err != nil {
+		t.Fatalf("RotateNow failed: %v", err)
+	}
+	if manager.LastNewnym().IsZero() {
+		t.Error("expected LastNewnym to be recorded")
+	}
+	if err := manager.PrewarmCircuits(ctx); err != nil {
+		t.Fatalf("PrewarmCircuits failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if newnyms != 2 {
+		t.Errorf("expected both NEWNYMs to reach Tor, got %d", newnyms)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if !slices.Contains(logger.entries, "warn Tor will delay NEWNYM because of its rate limit") {
+		t.Errorf("expected a rate limit warning, got %q", logger.entries)
+	}
+}
+
+func TestCircuitManagerRotateAndWait(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO circuit-status":
+			return "250+circuit-status=\r\n1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n.\r\n250 OK\r\n"
+		case "SETEVENTS CIRC NOTICE SIGNAL":
+			return "250 OK\r\n" +
+				"650 NOTICE Rate limiting NEWNYM request: delaying by 4 second(s)\r\n" +
+				"650 CIRC 1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n" +
+				"650 SIGNAL NEWNYM\r\n" +
+				"650 CIRC 2 EXTENDED $" + testRelayFingerprintB + "~beta PURPOSE=GENERAL\r\n" +
+				"650 CIRC 3 BUILT $" + testRelayFingerprintB + "~beta BUILD_FLAGS=IS_INTERNAL,NEED_CAPACITY PURPOSE=GENERAL\r\n" +
+				"650 CIRC 4 BUILT $" + testRelayFingerprintB + "~beta PURPOSE=HS_CLIENT_INTRO\r\n" +
+				"650 CIRC 5 BUILT $" + testRelayFingerprintB + "~beta,$" + testRelayFingerprintA + "~alpha BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n"
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+
+	t.Run("should wait for a fresh exit circuit", func(t *testing.T) {
+		manager := NewCircuitManager(ctrl)
+		// Pretend a NEWNYM was sent just under the rate limit ago.
+		manager.lastNewnym = time.Now().Add(-newnymRateLimit + 200*time.Millisecond)
+		exitIPs := []string{"192.0.2.10", "192.0.2.20"}
+		checkExit := func(o *rotateOptions) {
+			o.exitIP = func(context.Context) (string, error) {
+				ip := exitIPs[0]
+				exitIPs = exitIPs[1:]
+				return ip, nil
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := manager.RotateAndWait(ctx, checkExit)
+		if err != nil {
+			t.Fatalf("RotateAndWait failed: %v", err)
+		}
+		if res.CircuitID != "5" || len(res.Path) != 2 {
+			t.Errorf("unexpected circuit: %+v", res)
+		}
+		if !res.RateLimited || res.RateLimitDelay != 4*time.Second {
+			t.Errorf("expected rate-limit notice to be surfaced, got %+v", res)
+		}
+		if res.PreviousExitIP != "192.0.2.10" || res.ExitIP != "192.0.2.20" || !res.ExitIPChanged {
+			t.Errorf("unexpected exit check: %+v", res)
+		}
+		if res.Duration < 150*time.Millisecond {
+			t.Errorf("expected RotateAndWait to wait out the rate limit, took %v", res.Duration)
+		}
+	})
+
+	t.Run("should not report circuits built while NEWNYM was in flight", func(t *testing.T) {
+		// Circuit 6 is launched and built after the circuit-status snapshot
+		// but before Tor applies NEWNYM, which marks it dirty.
+		racingAddr := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO circuit-status":
+				return "250+circuit-status=\r\n.\r\n250 OK\r\n"
+			case "SETEVENTS CIRC NOTICE SIGNAL":
+				return "250 OK\r\n" +
+					"650 CIRC 6 LAUNCHED BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n" +
+					"650 CIRC 6 BUILT $" + testRelayFingerprintA + "~alpha BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n" +
+					"650 SIGNAL NEWNYM\r\n" +
+					"650 CIRC 7 LAUNCHED BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n" +
+					"650 CIRC 7 BUILT $" + testRelayFingerprintB + "~beta BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n"
+			}
+			return ""
+		})
+		racingCtrl, err := NewControlClient(racingAddr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer racingCtrl.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := NewCircuitManager(racingCtrl).RotateAndWait(ctx)
+		if err != nil {
+			t.Fatalf("RotateAndWait failed: %v", err)
+		}
+		if res.CircuitID != "7" {
+			t.Errorf("expected circuit 7 built after NEWNYM, got %+v", res)
+		}
+	})
+
+	t.Run("should time out without a fresh circuit", func(t *testing.T) {
+		staleAddr := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO circuit-status":
+				return "250+circuit-status=\r\n1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n.\r\n250 OK\r\n"
+			case "SETEVENTS CIRC NOTICE SIGNAL":
+				return "250 OK\r\n650 SIGNAL NEWNYM\r\n650 CIRC 1 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n"
+			}
+			return ""
+		})
+		staleCtrl, err := NewControlClient(staleAddr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer staleCtrl.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err = NewCircuitManager(staleCtrl).RotateAndWait(ctx)
+		if !errors.Is(err, &TornagoError{Kind: ErrTimeout}) {
+			t.Fatalf("expected ErrTimeout, got %v", err)
+		}
+	})
+}
+
+func TestParseNewnymRateLimitNotice(t *testing.T) {
+	if d, ok := parseNewnymRateLimitNotice("Rate limiting NEWNYM request: delaying by 1 second(s)"); !ok || d != time.Second {
+		t.Errorf("unexpected result: %v %v", d, ok)
+	}
+	if _, ok := parseNewnymRateLimitNotice("Bootstrapped 100% (done): Done"); ok {
+		t.Error("expected unrelated notice to be ignored")
+	}
+}
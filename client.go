@@ -8,7 +8,10 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -44,12 +47,16 @@ const (
 type Client struct {
 	// httpClient issues HTTP requests routed through Tor.
 	httpClient *http.Client
+	// transport adds retries, rate limiting, metrics and logging to the
+	// round trips of httpClient.
+	transport *Transport
 	// control holds the optional ControlPort client.
 	control *ControlClient
 	// cfg stores the normalized client configuration.
 	cfg ClientConfig
-	// socksDialer performs SOCKS5 CONNECT handshakes to Tor.
-	socksDialer *socks5Dialer
+	// socksDialer opens connections through Tor: a *socks5Dialer, an
+	// *httpTunnelDialer, or the TorPool the Client belongs to.
+	socksDialer contextDialer
 	// retryPolicy controls retry behavior for dial/HTTP operations.
 	retryPolicy retryPolicy
 	// metrics collects request statistics (optional).
@@ -58,6 +65,20 @@ type Client struct {
 	rateLimiter *RateLimiter
 	// logger provides structured logging (optional).
 	logger Logger
+	// listeners are the listeners reported by the ControlPort when
+	// WithClientSocksFromControl is set.
+	listeners TorListeners
+}
+
+// TorListeners are the listeners a running Tor reports on its ControlPort,
+// as "host:port" or "unix:/path".
+type TorListeners struct {
+	// Socks are the SocksPort listeners.
+	Socks []string
+	// HTTPTunnel are the HTTPTunnelPort listeners.
+	HTTPTunnel []string
+	// DNS are the DNSPort listeners.
+	DNS []string
 }
 
 // NewDefaultClient creates a Client with default settings for connecting to a
@@ -97,11 +118,39 @@ func NewDefaultClient() (*Client, error) {
 //
 // Always call Close() when done to clean up resources.
 func NewClient(cfg ClientConfig) (*Client, error) {
+	return newClient(cfg, nil)
+}
+
+// contextDialer opens connections to destinations through Tor.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// newClient builds a Client that dials through dialer, or through the
+// configured SocksAddr when dialer is nil.
+func newClient(cfg ClientConfig, dialer contextDialer) (*Client, error) {
 	cfg, err := normalizeClientConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	var control *ControlClient
+	if cfg.ControlAddr() != "" {
+		control, err = NewControlClient(cfg.ControlAddr(), cfg.ControlAuth(), cfg.DialTimeout())
+		if err != nil {
+			return nil, err
+		}
+	}
+	var listeners TorListeners
+	if cfg.SocksFromControl() {
+		listeners, err = queryTorListeners(control, cfg.DialTimeout())
+		if err != nil {
+			_ = control.Close() //nolint:errcheck // the listener error is more relevant
+			return nil, err
+		}
+		cfg.socksAddr = listeners.Socks[0]
+	}
+
 	retry := retryPolicy{
 		attempts:    cfg.RetryAttempts(),
 		delay:       cfg.RetryDelay(),
@@ -109,13 +158,25 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 		shouldRetry: cfg.RetryOnError(),
 	}
 
-	dialer := &socks5Dialer{
-		addr:    cfg.SocksAddr(),
-		timeout: cfg.DialTimeout(),
+	switch {
+	case dialer != nil:
+	case cfg.HTTPTunnelAddr() != "":
+		dialer = &httpTunnelDialer{
+			addr:    cfg.HTTPTunnelAddr(),
+			timeout: cfg.DialTimeout(),
+			header:  cfg.ProxyHeader(),
+		}
+	default:
+		dialer = &socks5Dialer{
+			addr:    cfg.SocksAddr(),
+			timeout: cfg.DialTimeout(),
+		}
 	}
 
 	client := &Client{
 		cfg:         cfg,
+		control:     control,
+		listeners:   listeners,
 		socksDialer: dialer,
 		retryPolicy: retry,
 		metrics:     cfg.Metrics(),
@@ -126,47 +187,51 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 	// Log client creation
 	client.logger.Log("debug", "created Tor client",
 		"socks_addr", cfg.SocksAddr(),
+		"http_tunnel_addr", cfg.HTTPTunnelAddr(),
 		"control_addr", cfg.ControlAddr(),
 		"dial_timeout", cfg.DialTimeout(),
 		"request_timeout", cfg.RequestTimeout(),
 	)
 
-	// Optimized Transport configuration for Tor
-	// - MaxIdleConns: 100 (default is 100, but explicitly set for clarity)
-	// - MaxIdleConnsPerHost: 10 (increased from default 2 for better connection reuse)
-	// - IdleConnTimeout: 90s (keep connections alive longer through Tor circuits)
-	// - DisableKeepAlives: false (enable connection reuse to avoid circuit churn)
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
-			return client.dialContext(ctx, network, address)
-		},
-		ForceAttemptHTTP2:     true,
-		TLSHandshakeTimeout:   cfg.DialTimeout(),
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   10,
-		IdleConnTimeout:       90 * time.Second,
-		DisableKeepAlives:     false,
-		DisableCompression:    false,
-		ResponseHeaderTimeout: cfg.RequestTimeout(),
-	}
-
+	client.transport = newTransport(client)
 	client.httpClient = &http.Client{
-		Transport: transport,
+		Transport: client.transport,
 		Timeout:   cfg.RequestTimeout(),
 	}
 
-	if cfg.ControlAddr() != "" {
-		controlClient, err := NewControlClient(cfg.ControlAddr(), cfg.ControlAuth(), cfg.DialTimeout())
+	return client, nil
+}
+
+// queryTorListeners asks the ControlPort for Tor's SOCKS, HTTP tunnel and DNS
+// listeners and fails when Tor has no SocksPort.
+func queryTorListeners(control *ControlClient, timeout time.Duration) (TorListeners, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var listeners TorListeners
+	queries := []struct {
+		kind string
+		dst  *[]string
+	}{
+		{"socks", &listeners.Socks},
+		{"httptunnel", &listeners.HTTPTunnel},
+		{"dns", &listeners.DNS},
+	}
+	for _, q := range queries {
+		addrs, err := control.Listeners(ctx, q.kind)
 		if err != nil {
-			return nil, err
+			return TorListeners{}, err
 		}
-		client.control = controlClient
+		*q.dst = addrs
 	}
-
-	return client, nil
+	if len(listeners.Socks) == 0 {
+		return TorListeners{}, newError(ErrInvalidConfig, opClient,
+			"Tor at "+control.addr+" has no SocksPort listener. Enable a SocksPort or use WithClientSocksAddr", nil)
+	}
+	return listeners, nil
 }
 
-// HTTP returns the configured *http.Client that routes through Tor.
+// HTTP returns the configured *http.Client that routes through Tor. Its
+// Transport applies the same retries, rate limiting and metrics as Do.
 func (c *Client) HTTP() *http.Client {
 	return c.httpClient
 }
@@ -181,6 +246,22 @@ func (c *Client) Metrics() *MetricsCollector {
 	return c.metrics
 }
 
+// SocksAddr returns the SocksPort address the client dials through. With
+// WithClientSocksFromControl it is the address learned from the ControlPort.
+func (c *Client) SocksAddr() string {
+	return c.cfg.SocksAddr()
+}
+
+// Listeners returns the listeners the ControlPort reported when the client
+// was created with WithClientSocksFromControl, and an empty value otherwise.
+func (c *Client) Listeners() TorListeners {
+	return TorListeners{
+		Socks:      slices.Clone(c.listeners.Socks),
+		HTTPTunnel: slices.Clone(c.listeners.HTTPTunnel),
+		DNS:        slices.Clone(c.listeners.DNS),
+	}
+}
+
 // Dial establishes a TCP connection via Tor's SOCKS5 proxy.
 // This is equivalent to DialContext with context.Background().
 func (c *Client) Dial(network, addr string) (net.Conn, error) {
@@ -212,6 +293,7 @@ func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Con
 	latency := time.Since(start)
 	if c.metrics != nil {
 		c.metrics.recordRequest(latency, err)
+		c.metrics.recordOutcome(RequestOutcome{Target: addr, Err: err, Latency: latency})
 	}
 	if err != nil {
 		c.logger.Log("error", "dial failed", "network", network, "addr", addr, "latency", latency, "error", err)
@@ -232,52 +314,87 @@ func (c *Client) Dialer() func(ctx context.Context, network, addr string) (net.C
 	return c.DialContext
 }
 
-// Do performs an HTTP request via Tor with retry support.
+// Do performs an HTTP request via Tor with retry support. It is equivalent
+// to c.HTTP().Do(req), except that failures are returned as *TornagoError
+// and metrics count the call once, including any redirects it follows.
+// The RequestTimeout bounds the whole call, including every retry and the
+// backoff between them.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	if req == nil {
 		return nil, newError(ErrInvalidConfig, opClient, "request is nil", nil)
 	}
-
-	c.logger.Log("debug", "http request", "method", req.Method, "url", req.URL.String())
-
-	if c.rateLimiter != nil {
-		if err := c.rateLimiter.Wait(req.Context()); err != nil {
-			c.logger.Log("warn", "rate limit wait failed", "error", err)
-			return nil, newError(ErrHTTPFailed, opClient, "rate limit wait failed", err)
-		}
-	}
-
 	start := time.Now()
-	var resp *http.Response
-	err := c.withRetry(req.Context(), 0, func(_ context.Context) error {
-		cloned, cloneErr := cloneRequestWithContext(req.Context(), req)
-		if cloneErr != nil {
-			return newError(ErrHTTPFailed, opClient, "failed to clone request", cloneErr)
-		}
-		attemptResp, doErr := c.httpClient.Do(cloned)
-		if doErr == nil {
-			resp = attemptResp
-			return nil
-		}
-		if attemptResp != nil && attemptResp.Body != nil {
-			if closeErr := attemptResp.Body.Close(); closeErr != nil {
-				doErr = errors.Join(doErr, closeErr)
-			}
+	resp, err := c.httpClient.Do(req.WithContext(context.WithValue(req.Context(), clientDoKey{}, true)))
+	if err != nil {
+		var tornagoErr *TornagoError
+		if !errors.As(err, &tornagoErr) {
+			tornagoErr = newError(ErrHTTPFailed, opClient, "http request failed", err)
 		}
-		return newError(ErrHTTPFailed, opClient, "http request failed", doErr)
-	})
-	latency := time.Since(start)
+		err, resp = tornagoErr, nil
+	}
 	if c.metrics != nil {
+		latency := time.Since(start)
 		c.metrics.recordRequest(latency, err)
+		c.observeResponse(req, resp, latency, err)
 	}
 	if err != nil {
-		c.logger.Log("error", "http request failed", "method", req.Method, "url", req.URL.String(), "latency", latency, "error", err)
 		return nil, err
 	}
-	c.logger.Log("debug", "http request succeeded", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "latency", latency)
 	return resp, nil
 }
 
+// observeResponse reports the outcome of an HTTP request to metrics observers.
+// Successful responses are reported when their body is closed so that the
+// transferred bytes are known.
+func (c *Client) observeResponse(req *http.Request, resp *http.Response, latency time.Duration, err error) {
+	outcome := RequestOutcome{Target: req.URL.Host, Err: err, Latency: latency}
+	if req.ContentLength > 0 {
+		outcome.Bytes = req.ContentLength
+	}
+	if err != nil || resp == nil {
+		c.metrics.recordOutcome(outcome)
+		return
+	}
+	outcome.StatusCode = resp.StatusCode
+	// Upgraded connections (101) expose a writable body that must not be wrapped.
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil {
+		c.metrics.recordOutcome(outcome)
+		return
+	}
+	resp.Body = &outcomeBody{ReadCloser: resp.Body, outcome: outcome, metrics: c.metrics}
+}
+
+// outcomeBody counts response body bytes and reports the request outcome
+// when the body is closed.
+type outcomeBody struct {
+	io.ReadCloser
+	// outcome is completed with the body size and reported on Close.
+	outcome RequestOutcome
+	// metrics receives the outcome.
+	metrics *MetricsCollector
+	// read counts bytes read from the body.
+	read int64
+	// once guards against reporting the outcome twice.
+	once sync.Once
+}
+
+// Read implements io.Reader, counting bytes read.
+func (b *outcomeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// Close closes the body and reports the outcome once.
+func (b *outcomeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.outcome.Bytes += b.read
+		b.metrics.recordOutcome(b.outcome)
+	})
+	return err
+}
+
 // Close closes the ControlClient and underlying HTTP transport resources.
 func (c *Client) Close() error {
 	c.logger.Log("debug", "closing client")
@@ -288,10 +405,8 @@ func (c *Client) Close() error {
 			c.logger.Log("error", "failed to close control client", "error", closeErr)
 		}
 	}
-	if c.httpClient != nil {
-		if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
-			transport.CloseIdleConnections()
-		}
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
 	}
 	c.logger.Log("debug", "client closed")
 	return closeErr
@@ -401,6 +516,15 @@ func cloneRequestWithContext(ctx context.Context, req *http.Request) (*http.Requ
 	return cloned, nil
 }
 
+// listenerNetwork splits an address in Tor's listener notation, "host:port"
+// or "unix:/path", into the network and address for net.Dialer.
+func listenerNetwork(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
 // socks5Dialer performs minimal SOCKS5 CONNECT handshakes.
 type socks5Dialer struct {
 	// addr is the SOCKS5 proxy endpoint (Tor's SocksPort).
@@ -420,7 +544,8 @@ func (d *socks5Dialer) DialContext(ctx context.Context, network, address string)
 		dialer.Timeout = d.timeout
 	}
 
-	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	proxyNet, proxyAddr := listenerNetwork(d.addr)
+	conn, err := dialer.DialContext(ctx, proxyNet, proxyAddr)
 	if err != nil {
 		return nil, newError(ErrSocksDialFailed, opClient, "failed to connect to SOCKS proxy", err)
 	}
@@ -981,6 +981,95 @@ func TestNewClientWithControl(t *testing.T) {
 	})
 }
 
+func TestNewClientSocksFromControl(t *testing.T) {
+	t.Run("should dial through the SocksPort reported by the ControlPort", func(t *testing.T) {
+		socks := createMockSOCKS5Server(t)
+		defer socks.Close()
+		control := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO net/listeners/socks":
+				return "250-net/listeners/socks=\"" + socks.Addr().String() + "\" \"unix:/run/tor/socks\"\r\n250 OK\r\n"
+			case "GETINFO net/listeners/httptunnel":
+				return "250-net/listeners/httptunnel=\"127.0.0.1:8118\"\r\n250 OK\r\n"
+			case "GETINFO net/listeners/dns":
+				return "250-net/listeners/dns=\r\n250 OK\r\n"
+			}
+			return ""
+		})
+
+		cfg, err := NewClientConfig(WithClientControlAddr(control), WithClientSocksFromControl())
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		if cfg.SocksAddr() != "" {
+			t.Errorf("SocksAddr = %q, want no default before NewClient", cfg.SocksAddr())
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+
+		if client.SocksAddr() != socks.Addr().String() {
+			t.Errorf("SocksAddr = %q, want %q", client.SocksAddr(), socks.Addr())
+		}
+		listeners := client.Listeners()
+		if len(listeners.Socks) != 2 || listeners.Socks[1] != "unix:/run/tor/socks" {
+			t.Errorf("Socks listeners = %v", listeners.Socks)
+		}
+		if len(listeners.HTTPTunnel) != 1 || listeners.HTTPTunnel[0] != "127.0.0.1:8118" {
+			t.Errorf("HTTPTunnel listeners = %v", listeners.HTTPTunnel)
+		}
+		if len(listeners.DNS) != 0 {
+			t.Errorf("DNS listeners = %v, want none", listeners.DNS)
+		}
+		conn, err := client.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		_ = conn.Close()
+	})
+
+	t.Run("should fail when Tor has no SocksPort", func(t *testing.T) {
+		control := startMockControlServer(t, func(cmd string) string {
+			if strings.HasPrefix(cmd, "GETINFO net/listeners/") {
+				return "250-" + strings.TrimPrefix(cmd, "GETINFO ") + "=\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		cfg, err := NewClientConfig(WithClientControlAddr(control), WithClientSocksFromControl())
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		_, err = NewClient(cfg)
+		if !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) || !strings.Contains(err.Error(), "no SocksPort") {
+			t.Errorf("error = %v, want missing SocksPort error", err)
+		}
+	})
+
+	t.Run("should not learn listeners without the option", func(t *testing.T) {
+		control := startMockControlServer(t, func(cmd string) string {
+			t.Errorf("unexpected control command %q", cmd)
+			return ""
+		})
+		cfg, err := NewClientConfig(WithClientControlAddr(control))
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+		if client.SocksAddr() != defaultSocksAddr {
+			t.Errorf("SocksAddr = %q, want default %q", client.SocksAddr(), defaultSocksAddr)
+		}
+		if got := client.Listeners(); got.Socks != nil {
+			t.Errorf("Listeners = %+v, want empty", got)
+		}
+	})
+}
+
 func TestConsumeConnectReplyIPv6(t *testing.T) {
 	t.Run("should handle IPv6 address in CONNECT reply", func(t *testing.T) {
 		// Create a pipe to simulate connection
@@ -2,6 +2,8 @@ package tornago
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net/http"
 	"os"
@@ -203,6 +205,83 @@ func TestNewClientConfig(t *testing.T) {
 	})
 }
 
+func TestClientConfigTransportOptions(t *testing.T) {
+	t.Run("should default to connection settings tuned for Tor", func(t *testing.T) {
+		cfg, err := NewClientConfig()
+		if err != nil {
+			t.Fatalf("NewClientConfig returned error: %v", err)
+		}
+		if cfg.MaxIdleConns() != 100 || cfg.MaxIdleConnsPerHost() != 10 || cfg.IdleConnTimeout() != 90*time.Second {
+			t.Errorf("pool = %d/%d/%v, want 100/10/90s", cfg.MaxIdleConns(), cfg.MaxIdleConnsPerHost(), cfg.IdleConnTimeout())
+		}
+		if !cfg.HTTP2() || cfg.TLSConfig() != nil || cfg.MaxResponseBodySize() != 0 || cfg.TransportHook() != nil {
+			t.Error("HTTP/2 should be on and TLS, body cap and hook unset by default")
+		}
+	})
+
+	t.Run("should keep explicit zero pool settings", func(t *testing.T) {
+		cfg, err := NewClientConfig(
+			WithClientMaxIdleConns(0),
+			WithClientMaxIdleConnsPerHost(0),
+			WithClientIdleConnTimeout(0),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig returned error: %v", err)
+		}
+		if cfg.MaxIdleConns() != 0 || cfg.MaxIdleConnsPerHost() != 0 || cfg.IdleConnTimeout() != 0 {
+			t.Errorf("pool = %d/%d/%v, want 0/0/0s", cfg.MaxIdleConns(), cfg.MaxIdleConnsPerHost(), cfg.IdleConnTimeout())
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient returned error: %v", err)
+		}
+		defer client.Close()
+		if base := client.Transport().base; base.MaxIdleConns != 0 || base.IdleConnTimeout != 0 {
+			t.Errorf("transport pool = %d/%v, want no limits", base.MaxIdleConns, base.IdleConnTimeout)
+		}
+	})
+
+	t.Run("should combine TLS options", func(t *testing.T) {
+		base := &tls.Config{ServerName: "partner.example", MinVersion: tls.VersionTLS12}
+		pool := x509.NewCertPool()
+		cert := tls.Certificate{Certificate: [][]byte{{1}}}
+		cfg, err := NewClientConfig(
+			WithClientTLSConfig(base),
+			WithClientRootCAs(pool),
+			WithClientCertificates(cert),
+			WithClientTLSMinVersion(tls.VersionTLS13),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig returned error: %v", err)
+		}
+		got := cfg.TLSConfig()
+		if got.ServerName != "partner.example" || got.RootCAs != pool || len(got.Certificates) != 1 || got.MinVersion != tls.VersionTLS13 {
+			t.Errorf("TLSConfig = %+v", got)
+		}
+		if base.MinVersion != tls.VersionTLS12 || base.RootCAs != nil {
+			t.Error("WithClientTLSConfig should not modify the caller's config")
+		}
+	})
+
+	t.Run("should keep proxy headers for the HTTPTunnelPort", func(t *testing.T) {
+		cfg, err := NewClientConfig(
+			WithClientHTTPTunnelAddr("127.0.0.1:9080"),
+			WithClientProxyHeader("x-tor-stream-isolation", "job-1"),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig returned error: %v", err)
+		}
+		header := cfg.ProxyHeader()
+		if header.Get("X-Tor-Stream-Isolation") != "job-1" {
+			t.Errorf("ProxyHeader = %v", header)
+		}
+		header.Set("X-Tor-Stream-Isolation", "changed")
+		if cfg.ProxyHeader().Get("X-Tor-Stream-Isolation") != "job-1" {
+			t.Error("ProxyHeader should return a copy")
+		}
+	})
+}
+
 func TestControlAuth(t *testing.T) {
 	t.Run("should store and return cookie bytes defensively", func(t *testing.T) {
 		cookie := []byte{0x01, 0x02, 0x03}
@@ -312,6 +391,32 @@ func TestValidateClientConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("should reject SocksFromControl without a ControlPort or with a SocksAddr", func(t *testing.T) {
+		if _, err := NewClientConfig(WithClientSocksFromControl()); err == nil {
+			t.Error("expected error without ControlAddr")
+		}
+		if _, err := NewClientConfig(WithClientSocksFromControl(),
+			WithClientControlAddr("127.0.0.1:9051"), WithClientSocksAddr("127.0.0.1:9050")); err == nil {
+			t.Error("expected error when combined with WithClientSocksAddr")
+		}
+	})
+
+	t.Run("should reject invalid HTTP transport options", func(t *testing.T) {
+		invalid := map[string]ClientOption{
+			"proxy header without tunnel": WithClientProxyHeader("X-Tor-Stream-Isolation", "a"),
+			"unknown TLS version":         WithClientTLSMinVersion(0x0200),
+			"negative idle conns":         WithClientMaxIdleConns(-1),
+			"negative conns per host":     WithClientMaxConnsPerHost(-1),
+			"negative idle timeout":       WithClientIdleConnTimeout(-time.Second),
+			"negative body size":          WithClientMaxResponseBodySize(-1),
+		}
+		for name, opt := range invalid {
+			if _, err := NewClientConfig(opt); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+				t.Errorf("%s: error = %v, want ErrInvalidConfig", name, err)
+			}
+		}
+	})
+
 	t.Run("should reject negative dial timeout", func(t *testing.T) {
 		cfg := ClientConfig{
 			socksAddr:   "127.0.0.1:9050",
@@ -45,6 +45,8 @@ const (
 //	ctrl.Authenticate()
 //	ctrl.NewIdentity(context.Background())  // Request new circuits
 type ControlClient struct {
+	// addr is the ControlPort address the client dialed.
+	addr string
 	// conn is the underlying TCP connection to the ControlPort.
 	conn net.Conn
 	// rw buffers reads/writes for the control protocol.
@@ -55,6 +57,8 @@ type ControlClient struct {
 	auth ControlAuth
 	// authenticated reports whether AUTHENTICATE succeeded.
 	authenticated bool
+	// authMu serializes authentication and guards authenticated.
+	authMu sync.Mutex
 	// mu serializes command writes/reads.
 	mu sync.Mutex
 }
@@ -72,12 +76,14 @@ func NewControlClient(addr string, auth ControlAuth, timeout time.Duration) (*Co
 	defer cancel()
 
 	dialer := &net.Dialer{}
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	network, address := listenerNetwork(addr)
+	conn, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, newError(ErrControlRequestFail, opControlClient, "failed to dial ControlPort", err)
 	}
 
 	client := &ControlClient{
+		addr:    addr,
 		conn:    conn,
 		rw:      bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
 		timeout: timeout,
@@ -88,6 +94,13 @@ func NewControlClient(addr string, auth ControlAuth, timeout time.Duration) (*Co
 
 // Authenticate performs AUTHENTICATE using ControlAuth credentials.
 func (c *ControlClient) Authenticate() error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.authenticate()
+}
+
+// authenticate performs AUTHENTICATE; callers must hold authMu.
+func (c *ControlClient) authenticate() error {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
@@ -118,11 +131,7 @@ func (c *ControlClient) Authenticate() error {
 // Note: Tor rate-limits NEWNYM requests to once per 10 seconds by default.
 // Calling this more frequently will not create new circuits.
 func (c *ControlClient) NewIdentity(ctx context.Context) error {
-	if err := c.ensureAuthenticated(); err != nil {
-		return err
-	}
-	_, err := c.execCommand(ctx, "SIGNAL NEWNYM")
-	return err
+	return c.Signal(ctx, SignalNewnym)
 }
 
 // GetInfo runs GETINFO and returns the associated value.
@@ -165,6 +174,31 @@ func (c *ControlClient) getInfo(ctx context.Context, key string, requireAuth boo
 	return result, nil
 }
 
+// getInfoLines runs GETINFO for a key whose value may span multiple lines
+// (e.g. "ns/all") and returns the value split into lines. Single-line values
+// ("key=value") are returned as a one-element slice.
+func (c *ControlClient) getInfoLines(ctx context.Context, key string) ([]string, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+	lines, err := c.execCommand(ctx, "GETINFO "+key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := key + "="
+	for i, line := range lines {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		value := strings.TrimPrefix(line, prefix)
+		if value != "" {
+			return []string{value}, nil
+		}
+		return lines[i+1:], nil
+	}
+	return nil, newError(ErrControlRequestFail, opControlClient, "key not found in GETINFO response", nil)
+}
+
 // GetConf retrieves the current value of a Tor configuration option.
 // The key should be a valid Tor configuration option name (e.g., "SocksPort", "ORPort").
 //
@@ -226,6 +260,146 @@ func (c *ControlClient) ResetConf(ctx context.Context, key string) error {
 	return err
 }
 
+// ConfEntry is a single configuration option used by SetConfMany and
+// returned by GetConfMany. Repeated keys (e.g. several "Bridge" lines) are
+// expressed as separate entries with the same Key.
+type ConfEntry struct {
+	// Key is the Tor configuration option name (e.g. "UseBridges").
+	Key string
+	// Value is the option value. An empty Value resets the option to its
+	// default when passed to SetConfMany.
+	Value string
+}
+
+// SetConfMany sets several configuration options in a single SETCONF command.
+// Tor validates and applies the whole set atomically, so interdependent
+// options (e.g. UseBridges together with its Bridge lines) never pass through
+// a rejected intermediate state. Values are quoted automatically.
+//
+// Example:
+//
+//	err := ctrl.SetConfMany(ctx, []tornago.ConfEntry{
+//	    {Key: "Bridge", Value: "obfs4 192.0.2.1:443 ..."},
+//	    {Key: "Bridge", Value: "obfs4 192.0.2.2:443 ..."},
+//	    {Key: "UseBridges", Value: "1"},
+//	})
+func (c *ControlClient) SetConfMany(ctx context.Context, entries []ConfEntry) error {
+	if len(entries) == 0 {
+		return newError(ErrInvalidConfig, opControlClient, "SetConfMany requires at least one entry", nil)
+	}
+	parts := make([]string, 0, len(entries)+1)
+	parts = append(parts, "SETCONF")
+	for _, entry := range entries {
+		if !validConfKey(entry.Key) {
+			return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid SetConfMany key %q", entry.Key), nil)
+		}
+		if entry.Value == "" {
+			parts = append(parts, entry.Key)
+			continue
+		}
+		parts = append(parts, entry.Key+"="+quotedString(entry.Value))
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, strings.Join(parts, " "))
+	return err
+}
+
+// GetConfMany retrieves several configuration options in a single GETCONF
+// command. Options with multiple values (e.g. "Bridge") yield one entry per
+// value; options that are unset yield an entry with an empty Value.
+func (c *ControlClient) GetConfMany(ctx context.Context, keys ...string) ([]ConfEntry, error) {
+	if len(keys) == 0 {
+		return nil, newError(ErrInvalidConfig, opControlClient, "GetConfMany requires at least one key", nil)
+	}
+	for _, key := range keys {
+		if !validConfKey(key) {
+			return nil, newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid GetConfMany key %q", key), nil)
+		}
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+	lines, err := c.execCommand(ctx, "GETCONF "+strings.Join(keys, " "))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ConfEntry, 0, len(lines))
+	for _, line := range lines {
+		key, value, _ := strings.Cut(line, "=")
+		if key == "" {
+			continue
+		}
+		entries = append(entries, ConfEntry{Key: key, Value: unquoteString(value)})
+	}
+	return entries, nil
+}
+
+// ResetConfMany resets several configuration options to their defaults in a
+// single RESETCONF command.
+func (c *ControlClient) ResetConfMany(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return newError(ErrInvalidConfig, opControlClient, "ResetConfMany requires at least one key", nil)
+	}
+	for _, key := range keys {
+		if !validConfKey(key) {
+			return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid ResetConfMany key %q", key), nil)
+		}
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "RESETCONF "+strings.Join(keys, " "))
+	return err
+}
+
+// LoadConf replaces Tor's entire configuration with torrcText using LOADCONF,
+// exactly as if Tor had been restarted with that torrc. Options missing from
+// torrcText revert to their defaults, so include everything the running
+// daemon needs (ports, DataDirectory, authentication).
+func (c *ControlClient) LoadConf(ctx context.Context, torrcText string) error {
+	if strings.TrimSpace(torrcText) == "" {
+		return newError(ErrInvalidConfig, opControlClient, "LoadConf configuration is empty", nil)
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "+LOADCONF\r\n"+encodeDataBlock(torrcText)+".")
+	return err
+}
+
+// validConfKey reports whether key looks like a Tor option name. Spaces, "="
+// and quotes would otherwise let callers smuggle extra options into a command.
+func validConfKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeDataBlock converts text into the body of a control-protocol data
+// block: CRLF line endings with leading dots doubled. The result ends with a
+// CRLF so the terminating "." can follow directly.
+func encodeDataBlock(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	var b strings.Builder
+	for _, line := range lines {
+		if strings.HasPrefix(line, ".") {
+			b.WriteByte('.')
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
 // SaveConf saves the current configuration to the torrc file.
 // This persists any changes made with SetConf.
 func (c *ControlClient) SaveConf(ctx context.Context) error {
@@ -250,6 +424,8 @@ type CircuitInfo struct {
 	Purpose string
 	// TimeCreated is when the circuit was created.
 	TimeCreated string
+	// RendQuery is the onion address (without ".onion") of onion service circuits.
+	RendQuery string
 }
 
 // GetCircuitStatus retrieves information about all current Tor circuits.
@@ -303,6 +479,8 @@ func parseCircuitLine(line string) CircuitInfo {
 			circuit.Purpose = strings.TrimPrefix(part, "PURPOSE=")
 		} else if strings.HasPrefix(part, "TIME_CREATED=") {
 			circuit.TimeCreated = strings.TrimPrefix(part, "TIME_CREATED=")
+		} else if strings.HasPrefix(part, "REND_QUERY=") {
+			circuit.RendQuery = strings.TrimPrefix(part, "REND_QUERY=")
 		}
 	}
 	return circuit
@@ -371,6 +549,49 @@ func parseStreamLine(line string) StreamInfo {
 	return stream
 }
 
+// CloseCircuit asks Tor to close the circuit with the given ID. Streams on
+// the circuit are closed as well.
+func (c *ControlClient) CloseCircuit(ctx context.Context, circuitID string) error {
+	if !isControlID(circuitID) {
+		return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid circuit ID %q", circuitID), nil)
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "CLOSECIRCUIT "+circuitID)
+	return err
+}
+
+// AttachStream attaches a stream to a circuit. A circuitID of "0" lets Tor
+// choose the circuit itself. Tor only accepts this for streams it left
+// unattached, which requires __LeaveStreamsUnattached=1.
+func (c *ControlClient) AttachStream(ctx context.Context, streamID, circuitID string) error {
+	if !isControlID(streamID) {
+		return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid stream ID %q", streamID), nil)
+	}
+	if !isControlID(circuitID) {
+		return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid circuit ID %q", circuitID), nil)
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "ATTACHSTREAM "+streamID+" "+circuitID)
+	return err
+}
+
+// isControlID reports whether id is a valid circuit or stream identifier.
+func isControlID(id string) bool {
+	if id == "" || len(id) > 16 {
+		return false
+	}
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
 // MapAddress creates a mapping from a virtual address to a target address.
 // This allows you to access services using custom addresses through Tor.
 //
@@ -401,6 +622,57 @@ func (c *ControlClient) MapAddress(ctx context.Context, fromAddr, toAddr string)
 	return toAddr, nil
 }
 
+// Listeners returns the addresses Tor is listening on for the given listener
+// kind using GETINFO net/listeners/<kind>. Kind is one of "socks", "control",
+// "httptunnel", "dns", "trans", "natd", "or", "dir" or "extor". Addresses are
+// "host:port" or "unix:/path"; an empty slice means no listener of that kind.
+//
+// Example:
+//
+//	socks, _ := ctrl.Listeners(ctx, "socks")
+//	// socks == []string{"127.0.0.1:9050"}
+func (c *ControlClient) Listeners(ctx context.Context, kind string) ([]string, error) {
+	if !validConfKey(kind) {
+		return nil, newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid listener kind %q", kind), nil)
+	}
+	lines, err := c.getInfoLines(ctx, "net/listeners/"+strings.ToLower(kind))
+	if err != nil {
+		return nil, err
+	}
+	return splitQuotedList(strings.Join(lines, " ")), nil
+}
+
+// splitQuotedList splits a space-separated list of possibly quoted strings
+// such as `"127.0.0.1:9050" "unix:/run/tor/socks"`.
+func splitQuotedList(s string) []string {
+	items := []string{}
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return items
+		}
+		end := strings.IndexByte(s, ' ')
+		if s[0] == '"' {
+			end = -1
+			for i := 1; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+					continue
+				}
+				if s[i] == '"' {
+					end = i + 1
+					break
+				}
+			}
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		items = append(items, unquoteString(s[:end]))
+		s = s[end:]
+	}
+}
+
 // Close closes the underlying ControlPort connection.
 func (c *ControlClient) Close() error {
 	if c.conn == nil {
@@ -411,10 +683,12 @@ func (c *ControlClient) Close() error {
 
 // ensureAuthenticated runs Authenticate if it has not been performed yet.
 func (c *ControlClient) ensureAuthenticated() error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
 	if c.authenticated {
 		return nil
 	}
-	return c.Authenticate()
+	return c.authenticate()
 }
 
 // authToken derives the authentication token based on ControlAuth settings.
@@ -618,10 +892,19 @@ func (c *ControlClient) readDataBlock() ([]string, error) {
 
 // quotedString escapes special characters per control protocol expectations.
 func quotedString(s string) string {
-	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", `\r`, "\n", `\n`, "\t", `\t`)
 	return fmt.Sprintf(`"%s"`, replacer.Replace(s))
 }
 
+// unquoteString reverses quotedString. Unquoted input is returned unchanged.
+func unquoteString(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	replacer := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\r`, "\r", `\n`, "\n", `\t`, "\t")
+	return replacer.Replace(s[1 : len(s)-1])
+}
+
 // WaitForControlPort waits until Tor's control port is usable.
 // Tor may accept TCP connections before it can respond to PROTOCOLINFO,
 // because the cookie might not be created yet. This function verifies that
@@ -1,12 +1,15 @@
 package tornago
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -60,6 +63,14 @@ func TestQuotedString(t *testing.T) {
 		}
 	})
 
+	t.Run("should escape control characters", func(t *testing.T) {
+		result := quotedString("line1\nline2\t\r")
+		expected := `"line1\nline2\t\r"`
+		if result != expected {
+			t.Fatalf("expected %s, got %s", expected, result)
+		}
+	})
+
 	t.Run("should correctly quote string with only double quotes", func(t *testing.T) {
 		result := quotedString(`"""`)
 		expected := `"\"\"\""`
@@ -1057,6 +1068,14 @@ func TestParseCircuitLine(t *testing.T) {
 		}
 	})
 
+	t.Run("should parse the onion address of onion service circuits", func(t *testing.T) {
+		line := "3 BUILT $AAAA,$BBBB PURPOSE=HS_CLIENT_REND HS_STATE=HSCR_JOINED REND_QUERY=exampleonion"
+		circuit := parseCircuitLine(line)
+		if circuit.RendQuery != "exampleonion" {
+			t.Errorf("expected RendQuery exampleonion, got %q", circuit.RendQuery)
+		}
+	})
+
 	t.Run("should handle minimal circuit line", func(t *testing.T) {
 		line := "2 LAUNCHED"
 		circuit := parseCircuitLine(line)
@@ -1723,3 +1742,215 @@ func TestGetStreamStatus(t *testing.T) {
 		}
 	})
 }
+
+// startMockControlServer starts a fake ControlPort that answers every command
+// line with handler's reply. An empty reply is sent as "250 OK". Multi-line
+// commands ("+LOADCONF" ...) are passed to handler as one string joined with
+// "\n", including the terminating ".". It returns the listener address; the
+// server stops when the test finishes.
+func startMockControlServer(t *testing.T, handler func(cmd string) string) string {
+	t.Helper()
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.TrimRight(line, "\r\n")
+					for strings.HasPrefix(cmd, "+") && !strings.HasSuffix(cmd, "\n.") {
+						next, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						cmd += "\n" + strings.TrimRight(next, "\r\n")
+					}
+					reply := handler(cmd)
+					if reply == "" {
+						reply = "250 OK\r\n"
+					}
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestConfMany(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(cmd, "AUTHENTICATE") {
+			return ""
+		}
+		commands = append(commands, cmd)
+		if strings.HasPrefix(cmd, "GETCONF") {
+			return "250-Bridge=obfs4 192.0.2.1:443 cert=abc\r\n" +
+				"250-Bridge=obfs4 192.0.2.2:443 cert=def\r\n" +
+				"250-Nickname=\"quoted \\\"name\\\"\"\r\n" +
+				"250 ExitNodes\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	t.Run("should set repeated keys in one command", func(t *testing.T) {
+		err := client.SetConfMany(ctx, []ConfEntry{
+			{Key: "Bridge", Value: "obfs4 192.0.2.1:443 cert=abc"},
+			{Key: "Bridge", Value: `odd"value`},
+			{Key: "UseBridges", Value: "1"},
+			{Key: "ExitNodes"},
+		})
+		if err != nil {
+			t.Fatalf("SetConfMany failed: %v", err)
+		}
+	})
+
+	t.Run("should get several keys", func(t *testing.T) {
+		entries, err := client.GetConfMany(ctx, "Bridge", "Nickname", "ExitNodes")
+		if err != nil {
+			t.Fatalf("GetConfMany failed: %v", err)
+		}
+		want := []ConfEntry{
+			{Key: "Bridge", Value: "obfs4 192.0.2.1:443 cert=abc"},
+			{Key: "Bridge", Value: "obfs4 192.0.2.2:443 cert=def"},
+			{Key: "Nickname", Value: `quoted "name"`},
+			{Key: "ExitNodes"},
+		}
+		if !slices.Equal(entries, want) {
+			t.Errorf("unexpected entries: %+v", entries)
+		}
+	})
+
+	t.Run("should reset several keys", func(t *testing.T) {
+		if err := client.ResetConfMany(ctx, "UseBridges", "Bridge"); err != nil {
+			t.Fatalf("ResetConfMany failed: %v", err)
+		}
+	})
+
+	t.Run("should load a whole configuration", func(t *testing.T) {
+		if err := client.LoadConf(ctx, "SocksPort 9050\n.hidden value\nLog notice stdout\n"); err != nil {
+			t.Fatalf("LoadConf failed: %v", err)
+		}
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		`SETCONF Bridge="obfs4 192.0.2.1:443 cert=abc" Bridge="odd\"value" UseBridges="1" ExitNodes`,
+		`GETCONF Bridge Nickname ExitNodes`,
+		`RESETCONF UseBridges Bridge`,
+		"+LOADCONF\nSocksPort 9050\n..hidden value\nLog notice stdout\n.",
+	}
+	if !slices.Equal(commands, want) {
+		t.Errorf("unexpected commands:\n got %q\nwant %q", commands, want)
+	}
+}
+
+func TestConfManyValidation(t *testing.T) {
+	client := &ControlClient{authenticated: true}
+	ctx := context.Background()
+
+	if err := client.SetConfMany(ctx, nil); err == nil {
+		t.Error("expected error for empty SetConfMany")
+	}
+	if err := client.SetConfMany(ctx, []ConfEntry{{Key: "ExitNodes=x StrictNodes", Value: "1"}}); err == nil {
+		t.Error("expected error for key with separators")
+	}
+	if _, err := client.GetConfMany(ctx); err == nil {
+		t.Error("expected error for empty GetConfMany")
+	}
+	if _, err := client.GetConfMany(ctx, "bad key"); err == nil {
+		t.Error("expected error for invalid GetConfMany key")
+	}
+	if err := client.ResetConfMany(ctx); err == nil {
+		t.Error("expected error for empty ResetConfMany")
+	}
+	if err := client.ResetConfMany(ctx, ""); err == nil {
+		t.Error("expected error for empty ResetConfMany key")
+	}
+	if err := client.LoadConf(ctx, "  \n"); err == nil {
+		t.Error("expected error for empty LoadConf")
+	}
+}
+
+func TestUnquoteString(t *testing.T) {
+	inputs := []string{"plain", `back\slash`, `with "quotes"`, "multi\nline\ttab\r", ""}
+	for _, in := range inputs {
+		if got := unquoteString(quotedString(in)); got != in {
+			t.Errorf("unquoteString(quotedString(%q)) = %q", in, got)
+		}
+	}
+	if got := unquoteString("unquoted value"); got != "unquoted value" {
+		t.Errorf("expected unquoted input unchanged, got %q", got)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO net/listeners/socks":
+			return "250-net/listeners/socks=\"127.0.0.1:9050\" \"unix:/run/tor/my \\\"socks\\\"\"\r\n250 OK\r\n"
+		case "GETINFO net/listeners/dns":
+			return "250-net/listeners/dns=\r\n250 OK\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	t.Run("should split quoted listener addresses", func(t *testing.T) {
+		got, err := client.Listeners(ctx, "SOCKS")
+		if err != nil {
+			t.Fatalf("Listeners failed: %v", err)
+		}
+		want := []string{"127.0.0.1:9050", `unix:/run/tor/my "socks"`}
+		if !slices.Equal(got, want) {
+			t.Errorf("Listeners() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should return an empty list without listeners", func(t *testing.T) {
+		got, err := client.Listeners(ctx, "dns")
+		if err != nil {
+			t.Fatalf("Listeners failed: %v", err)
+		}
+		if got == nil || len(got) != 0 {
+			t.Errorf("expected empty list, got %q", got)
+		}
+	})
+
+	t.Run("should reject an invalid kind", func(t *testing.T) {
+		if _, err := client.Listeners(ctx, "socks\r\nSIGNAL HALT"); err == nil {
+			t.Error("expected error for invalid kind")
+		}
+	})
+}
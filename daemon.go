@@ -5,10 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +21,9 @@ import (
 const (
 	// opStartTorDaemon labels errors originating from StartTorDaemon.
 	opStartTorDaemon = "StartTorDaemon"
+	// gracefulStopTimeout bounds how long Stop waits for tor to exit after
+	// SIGNAL SHUTDOWN before falling back to killing the process.
+	gracefulStopTimeout = 10 * time.Second
 )
 
 // TorProcess represents a running tor daemon launched by Tornago. It is immutable
@@ -28,6 +35,12 @@ type TorProcess struct {
 	socksAddr string
 	// controlAddr is the resolved address of the ControlPort.
 	controlAddr string
+	// socksPorts maps SocksPort names to their resolved addresses.
+	socksPorts map[string]string
+	// httpTunnelAddr is the resolved HTTPTunnelPort address, empty when disabled.
+	httpTunnelAddr string
+	// dnsAddr is the resolved DNSPort address, empty when disabled.
+	dnsAddr string
 	// cmd references the exec.Cmd used to launch tor so we can stop it later.
 	cmd *exec.Cmd
 	// process points to the running os.Process for cleanup.
@@ -36,28 +49,105 @@ type TorProcess struct {
 	dataDir string
 	// cleanupDataDir signals whether Tornago owns the data directory lifecycle.
 	cleanupDataDir bool
+	// wait reaps the process and reports when it exits.
+	wait *processWait
+	// proxyAuthFile holds upstream proxy credentials, removed on Stop.
+	proxyAuthFile string
 }
 
-// PID returns the process identifier of the launched tor daemon.
-func (p TorProcess) PID() int { return p.pid }
+// PID returns the process identifier of the launched tor daemon, or 0 once
+// the process has exited.
+func (p TorProcess) PID() int {
+	if p.Exited() {
+		return 0
+	}
+	return p.pid
+}
+
+// Done returns a channel that is closed when the tor process exits, whether
+// it crashed or was stopped. It returns nil (a channel that never closes) for
+// a TorProcess that was not started by StartTorDaemon.
+func (p TorProcess) Done() <-chan struct{} {
+	if p.wait == nil {
+		return nil
+	}
+	return p.wait.done
+}
+
+// Exited reports whether the tor process has exited.
+func (p TorProcess) Exited() bool {
+	if p.wait == nil {
+		return false
+	}
+	select {
+	case <-p.wait.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExitErr returns the error reported when the tor process exited, such as an
+// *exec.ExitError for a non-zero exit status. It is nil while the process is
+// running or when it exited cleanly.
+func (p TorProcess) ExitErr() error {
+	if !p.Exited() {
+		return nil
+	}
+	return p.wait.err
+}
 
 // SocksAddr returns the resolved SocksPort address of the launched tor daemon.
 func (p TorProcess) SocksAddr() string { return p.socksAddr }
 
+// SocksPorts returns the resolved address of every SocksPort by name: the one
+// set with WithTorSocksAddr as DefaultSocksPort and those added with
+// WithTorSocksPort.
+func (p TorProcess) SocksPorts() map[string]string {
+	if p.socksPorts == nil {
+		return map[string]string{DefaultSocksPort: p.socksAddr}
+	}
+	return maps.Clone(p.socksPorts)
+}
+
+// SocksPortAddr returns the resolved address of the named SocksPort.
+//
+// Example:
+//
+//	addr, _ := proc.SocksPortAddr("onion-only")
+//	cfg, _ := tornago.NewClientConfig(tornago.WithClientSocksAddr(addr))
+func (p TorProcess) SocksPortAddr(name string) (string, bool) {
+	addr, ok := p.SocksPorts()[name]
+	return addr, ok
+}
+
 // ControlAddr returns the resolved ControlPort address of the launched tor daemon.
 func (p TorProcess) ControlAddr() string { return p.controlAddr }
 
+// HTTPTunnelAddr returns the resolved HTTPTunnelPort address, or "" when
+// WithTorHTTPTunnelAddr was not used.
+func (p TorProcess) HTTPTunnelAddr() string { return p.httpTunnelAddr }
+
+// DNSAddr returns the resolved DNSPort address, or "" when WithTorDNSAddr was
+// not used.
+func (p TorProcess) DNSAddr() string { return p.dnsAddr }
+
 // DataDir returns the Tor data directory path used by this process.
 func (p TorProcess) DataDir() string { return p.dataDir }
 
 // Stop terminates the tor process and cleans up temporary resources.
+//
+// Stop first asks tor to exit through SIGNAL SHUTDOWN on its ControlPort so
+// it can close circuits and withdraw onion service descriptors cleanly. If
+// the ControlPort is unreachable or tor does not exit in time, the process
+// is killed.
 func (p *TorProcess) Stop() error {
 	if p == nil {
 		return nil
 	}
 	var err error
-	if p.cmd != nil {
-		if stopErr := terminateCmd(p.cmd); stopErr != nil {
+	if p.wait != nil && p.cmd != nil {
+		if stopErr := stopCmd(p.wait, p.controlAddr); stopErr != nil {
 			err = errors.Join(err, stopErr)
 		}
 		p.cmd = nil
@@ -69,6 +159,10 @@ func (p *TorProcess) Stop() error {
 		}
 		p.process = nil
 	}
+	if rmErr := removeProxyCredentials(p.proxyAuthFile); rmErr != nil {
+		err = errors.Join(err, rmErr)
+	}
+	p.proxyAuthFile = ""
 	if p.cleanupDataDir && p.dataDir != "" {
 		if rmErr := os.RemoveAll(p.dataDir); rmErr != nil {
 			err = errors.Join(err, rmErr)
@@ -86,7 +180,7 @@ func (p *TorProcess) Stop() error {
 // This function is useful when you want your application to manage its own Tor instance
 // rather than relying on a system-wide Tor daemon. StartTorDaemon handles:
 //   - Finding the tor binary in PATH (install via: apt install tor, brew install tor, choco install tor)
-//   - Allocating free ports when using ":0" addresses
+//   - Letting tor choose ports for ":0" addresses and discovering them via ControlPortWriteToFile
 //   - Configuring cookie authentication automatically
 //   - Waiting for Tor to become ready before returning
 //   - Creating/managing the Tor DataDirectory
@@ -112,11 +206,38 @@ func (p *TorProcess) Stop() error {
 //	)
 //	client, _ := tornago.NewClient(clientCfg)
 //	defer client.Close()
-func StartTorDaemon(cfg TorLaunchConfig) (_ *TorProcess, err error) {
+func StartTorDaemon(cfg TorLaunchConfig) (*TorProcess, error) {
+	return StartTorDaemonContext(context.Background(), cfg)
+}
+
+// StartTorDaemonContext is like StartTorDaemon but aborts startup when ctx is
+// canceled: the tor child is killed, a temporary DataDirectory is removed and
+// an error wrapping ctx.Err() is returned.
+//
+// With WithTorStopWithContext, the returned TorProcess is also stopped once
+// ctx is done. With WithTorExitWithParent, tor exits when the current process
+// dies, so it cannot be orphaned by a crashed test binary.
+//
+// Example:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//	torProc, err := tornago.StartTorDaemonContext(ctx, cfg)
+//	if err != nil {
+//	    return err // also returned when interrupted during startup
+//	}
+//	defer torProc.Stop()
+func StartTorDaemonContext(ctx context.Context, cfg TorLaunchConfig) (_ *TorProcess, err error) {
 	cfg, err = normalizeTorLaunchConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, newError(ErrTorLaunchFailed, opStartTorDaemon, "tor startup canceled", ctxErr)
+	}
 
 	logger := cfg.Logger()
 	logger.Log("info", "starting Tor daemon", "socks_addr", cfg.SocksAddr(), "control_addr", cfg.ControlAddr())
@@ -155,68 +276,142 @@ func StartTorDaemon(cfg TorLaunchConfig) (_ *TorProcess, err error) {
 		msg := fmt.Sprintf("tor binary not found. Install tor via your package manager (e.g. apt-get install tor, brew install tor, pacman -S tor). attempted: %q", cfg.TorBinary())
 		return nil, newError(ErrTorBinaryNotFound, opStartTorDaemon, msg, err)
 	}
-
-	socksAddr, err := resolveAddr(cfg.SocksAddr())
-	if err != nil {
-		return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid SocksAddr", err)
+	if err := findTransportPlugins(cfg); err != nil {
+		return nil, err
 	}
-	controlAddr, err := resolveAddr(cfg.ControlAddr())
+	proxyAuthFile, err := writeProxyCredentials(cfg, dataDir)
 	if err != nil {
-		return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid ControlAddr", err)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if rmErr := removeProxyCredentials(proxyAuthFile); rmErr != nil {
+				err = errors.Join(err, rmErr)
+			}
+		}
+	}()
+
+	// Without a torrc, ":0" ports are passed to tor as "auto" and the ports it
+	// picks are discovered after launch, so no other process can grab them in
+	// between. socksAddrs entries and controlAddr stay empty until then.
+	// socksAddrs[0] is the default SocksPort, followed by cfg.SocksPorts().
+	var socksAddr, controlAddr, httpTunnelAddr, dnsAddr, socksArg, controlArg string
+	var socksArgs, extraSocks []string
+	torConfig := cfg.TorConfigFile()
+	cookiePath := filepath.Join(dataDir, "control_auth_cookie")
+	portFile := filepath.Join(dataDir, controlPortFileName)
+	if torConfig != "" {
+		socksAddr, err = resolveAddr(cfg.SocksAddr())
+		if err != nil {
+			return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid SocksAddr", err)
+		}
+		controlAddr, err = resolveAddr(cfg.ControlAddr())
+		if err != nil {
+			return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid ControlAddr", err)
+		}
+	} else {
+		if socksArg, socksAddr, err = listenArg(cfg.SocksAddr()); err != nil {
+			return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid SocksAddr", err)
+		}
+		if controlArg, controlAddr, err = listenArg(cfg.ControlAddr()); err != nil {
+			return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid ControlAddr", err)
+		}
+		socksArgs = append(socksArgs, "--SocksPort", socksPortArg(socksArg, cfg.socksFlags))
+		for _, p := range cfg.socksPorts {
+			arg, resolved, portErr := listenArg(p.Addr)
+			if portErr != nil {
+				return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid address for SocksPort "+p.Name, portErr)
+			}
+			socksArgs = append(socksArgs, "--SocksPort", socksPortArg(arg, p.Flags))
+			extraSocks = append(extraSocks, resolved)
+		}
+		if cfg.HTTPTunnelAddr() != "" {
+			arg, resolved, tunnelErr := listenArg(cfg.HTTPTunnelAddr())
+			if tunnelErr != nil {
+				return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid HTTPTunnelAddr", tunnelErr)
+			}
+			socksArgs = append(socksArgs, "--HTTPTunnelPort", arg)
+			httpTunnelAddr = resolved
+		}
+		if cfg.DNSAddr() != "" {
+			arg, resolved, dnsErr := listenArg(cfg.DNSAddr())
+			if dnsErr != nil {
+				return nil, newError(ErrInvalidConfig, opStartTorDaemon, "invalid DNSAddr", dnsErr)
+			}
+			socksArgs = append(socksArgs, "--DNSPort", arg, "--AutomapHostsOnResolve", "1")
+			dnsAddr = resolved
+		}
+		// A file left by a previous run would point at stale ports.
+		if rmErr := os.Remove(portFile); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			return nil, newError(ErrIO, opStartTorDaemon, "failed to remove stale "+portFile, rmErr)
+		}
 	}
 
 	cmdArgs := make([]string, 0)
-	if torConfig := cfg.TorConfigFile(); torConfig != "" {
+	if torConfig != "" {
 		// When using torrc file, only pass -f and extra args
 		cmdArgs = append(cmdArgs, "-f", torConfig)
+		cmdArgs = append(cmdArgs, nodeSelectionArgs(cfg)...)
+		cmdArgs = append(cmdArgs, guardArgs(cfg)...)
+		cmdArgs = append(cmdArgs, bridgeArgs(cfg)...)
+		cmdArgs = append(cmdArgs, proxyArgs(cfg)...)
+		cmdArgs = append(cmdArgs, torrcArgs(cfg)...)
 		cmdArgs = append(cmdArgs, cfg.ExtraArgs()...)
 	} else {
 		// When not using torrc, pass all settings as command-line args
-		cookiePath := filepath.Join(dataDir, "control_auth_cookie")
-		args := []string{
-			"--SocksPort", socksAddr,
-			"--ControlPort", controlAddr,
+		args := slices.Concat(socksArgs, []string{
+			"--ControlPort", controlArg,
+			"--ControlPortWriteToFile", portFile,
 			"--CookieAuthentication", "1",
 			"--CookieAuthFile", cookiePath,
 			"--RunAsDaemon", "0",
 			"--DataDirectory", dataDir,
 			"--Log", "notice stdout",
-		}
+		})
+		args = append(args, nodeSelectionArgs(cfg)...)
+		args = append(args, guardArgs(cfg)...)
+		args = append(args, bridgeArgs(cfg)...)
+		args = append(args, proxyArgs(cfg)...)
+		args = append(args, torrcArgs(cfg)...)
 		args = append(args, cfg.ExtraArgs()...)
 		cmdArgs = append(cmdArgs, args...)
 	}
+	if proxyAuthFile != "" {
+		cmdArgs = append(cmdArgs, "--defaults-torrc", proxyAuthFile)
+	}
+	if cfg.exitWithParent {
+		// Tor polls the owning process and exits once it is gone.
+		cmdArgs = append(cmdArgs, "--__OwningControllerProcess", strconv.Itoa(os.Getpid()))
+	}
 
 	// #nosec G204 -- arguments are fully controlled by validated TorLaunchConfig.
 	// NOTE: We use exec.Command (not CommandContext) because the tor process should
 	// stay alive after StartTorDaemon returns. The context is only for waiting for ports.
 	cmd := exec.Command(binPath, cmdArgs...) //nolint:noctx
+	if cfg.exitWithParent {
+		setParentDeathSignal(cmd)
+	}
 	var stdoutBuf, stderrBuf bytes.Buffer
 
-	logReporter := cfg.LogReporter()
-	if logReporter != nil {
-		// Use teeWriter to both capture and report logs in real-time
-		cmd.Stdout = &teeWriter{buf: &stdoutBuf, reporter: logReporter}
-		cmd.Stderr = &teeWriter{buf: &stderrBuf, reporter: logReporter}
-	} else {
-		cmd.Stdout = &stdoutBuf
-		cmd.Stderr = &stderrBuf
-	}
+	// Tor's output is captured for error messages and parsed line by line
+	// into the configured Logger, reporter and handler.
+	logSink := newTorLogSink(cfg)
+	cmd.Stdout = &teeWriter{buf: &stdoutBuf, reporter: logSink.line}
+	cmd.Stderr = &teeWriter{buf: &stderrBuf, reporter: logSink.line}
 
 	logOutput := func() string {
 		return strings.TrimSpace(stdoutBuf.String() + "\n" + stderrBuf.String())
 	}
-	reportLogs := func() string {
-		return logOutput()
-	}
 	attachLogs := func(msg string) string {
-		logged := reportLogs()
+		logged := logOutput()
 		if logged != "" {
 			return msg + ": " + logged
 		}
 		return msg
 	}
 
-	if startErr := cmd.Start(); startErr != nil {
+	wait, startErr := startProcess(cmd, cfg.exitWithParent)
+	if startErr != nil {
 		logger.Log("error", "failed to start tor process", "error", startErr)
 		err = newError(ErrTorLaunchFailed, opStartTorDaemon, attachLogs("failed to start tor"), startErr)
 		return nil, err
@@ -225,36 +420,201 @@ func StartTorDaemon(cfg TorLaunchConfig) (_ *TorProcess, err error) {
 	logger.Log("debug", "tor process started", "pid", cmd.Process.Pid)
 
 	// Create a context for waiting for ports to become ready
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout())
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.StartupTimeout())
 	defer cancel()
 
 	logger.Log("debug", "waiting for tor ports to become ready", "timeout", cfg.StartupTimeout())
 
-	if waitErr := waitForPorts(ctx, socksAddr, controlAddr); waitErr != nil {
-		if stopErr := terminateCmd(cmd); stopErr != nil {
+	socksAddrs := append([]string{socksAddr}, extraSocks...)
+	waitErr := discoverPorts(waitCtx, wait.done, socksAddrs, &controlAddr, portFile, cookiePath)
+	socksAddr = socksAddrs[0]
+	if waitErr == nil && cfg.HTTPTunnelAddr() != "" && httpTunnelAddr == "" {
+		httpTunnelAddr, waitErr = discoverListener(waitCtx, wait.done, "httptunnel", controlAddr, cookiePath)
+	}
+	if waitErr == nil && cfg.DNSAddr() != "" && dnsAddr == "" {
+		dnsAddr, waitErr = discoverListener(waitCtx, wait.done, "dns", controlAddr, cookiePath)
+	}
+	if waitErr == nil {
+		waitErr = waitForPorts(waitCtx, wait.done, socksAddr, controlAddr)
+	}
+	if waitErr != nil {
+		if stopErr := terminateCmd(wait); stopErr != nil {
 			waitErr = errors.Join(waitErr, stopErr)
 		}
 		logger.Log("error", "tor ports did not become ready", "error", waitErr)
-		err = newError(ErrTorLaunchFailed, opStartTorDaemon, attachLogs("tor process exited before ports became reachable"), waitErr)
+		msg := "tor process exited before ports became reachable"
+		if reason := logSink.startupFailure(); reason != "" {
+			msg = reason
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, newError(ErrTorLaunchFailed, opStartTorDaemon, "tor startup canceled", errors.Join(ctxErr, waitErr))
+		}
+		err = newError(ErrTorLaunchFailed, opStartTorDaemon, attachLogs(msg), waitErr)
 		return nil, err
 	}
 
+	socksPorts := map[string]string{DefaultSocksPort: socksAddr}
+	for i, p := range cfg.socksPorts {
+		socksPorts[p.Name] = socksAddrs[i+1]
+	}
 	proc := &TorProcess{
 		pid:            cmd.Process.Pid,
 		socksAddr:      socksAddr,
+		socksPorts:     socksPorts,
 		controlAddr:    controlAddr,
+		httpTunnelAddr: httpTunnelAddr,
+		dnsAddr:        dnsAddr,
 		process:        cmd.Process,
 		dataDir:        dataDir,
 		cleanupDataDir: cleanupDataDir,
 		cmd:            cmd,
+		wait:           wait,
+		proxyAuthFile:  proxyAuthFile,
 	}
 	cleanupOnFail = false
+	if cfg.stopWithContext {
+		go stopWithContext(ctx, wait, controlAddr, dataDir, cleanupDataDir, proxyAuthFile, logger)
+	}
 	logger.Log("info", "Tor daemon started successfully", "pid", proc.pid, "socks_addr", proc.socksAddr, "control_addr", proc.controlAddr)
 	return proc, nil
 }
 
-// waitForPorts polls for SocksPort/ControlPort reachability or timeout.
-func waitForPorts(ctx context.Context, socksAddr, controlAddr string) error {
+// stopWithContext stops the tor process watched by w once ctx is done and
+// removes its proxy credentials and, when Tornago created it, its
+// DataDirectory.
+func stopWithContext(ctx context.Context, w *processWait, controlAddr, dataDir string, cleanupDataDir bool, proxyAuthFile string, logger Logger) {
+	select {
+	case <-w.done:
+		return
+	case <-ctx.Done():
+	}
+	logger.Log("info", "stopping Tor daemon because its context is done", "error", ctx.Err())
+	if err := stopCmd(w, controlAddr); err != nil {
+		logger.Log("error", "failed to stop tor", "error", err)
+	}
+	if err := removeProxyCredentials(proxyAuthFile); err != nil {
+		logger.Log("error", "failed to remove proxy credentials", "path", proxyAuthFile, "error", err)
+	}
+	if cleanupDataDir && dataDir != "" {
+		if err := os.RemoveAll(dataDir); err != nil {
+			logger.Log("error", "failed to remove data directory", "path", dataDir, "error", err)
+		}
+	}
+}
+
+// controlPortFileName is the ControlPortWriteToFile file in the DataDirectory.
+const controlPortFileName = "control_port"
+
+// listenArg returns the tor port argument for addr. A zero port becomes
+// "host:auto" and resolved is empty; otherwise resolved is addr itself.
+func listenArg(addr string) (arg, resolved string, err error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return "", "", err
+	}
+	if tcpAddr.Port != 0 {
+		return tcpAddr.String(), tcpAddr.String(), nil
+	}
+	host := "127.0.0.1"
+	if tcpAddr.IP != nil && !tcpAddr.IP.IsUnspecified() {
+		host = tcpAddr.IP.String()
+	}
+	return net.JoinHostPort(host, "auto"), "", nil
+}
+
+// discoverPorts fills empty socksAddrs entries and controlAddr with the ports
+// tor picked: the ControlPort from ControlPortWriteToFile and the SocksPorts
+// from GETINFO net/listeners/socks.
+func discoverPorts(ctx context.Context, exited <-chan struct{}, socksAddrs []string, controlAddr *string, portFile, cookiePath string) error {
+	if !slices.Contains(socksAddrs, "") && *controlAddr != "" {
+		return nil
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if *controlAddr == "" {
+			if addr, err := readControlPortFile(portFile); err == nil {
+				*controlAddr = addr
+			}
+		}
+		if *controlAddr != "" {
+			if !slices.Contains(socksAddrs, "") {
+				return nil
+			}
+			if listeners, err := querySocksListeners(ctx, *controlAddr, cookiePath); err == nil {
+				pending := slices.Clone(socksAddrs)
+				if assignSocksListeners(pending, listeners) {
+					copy(socksAddrs, pending)
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return newError(ErrTimeout, "discoverPorts", "timed out waiting for tor to report its ports", ctx.Err())
+		case <-exited:
+			return newError(ErrTorLaunchFailed, "discoverPorts", "tor exited during startup", nil)
+		case <-ticker.C:
+		}
+	}
+}
+
+// discoverListener polls the ControlPort until tor reports a listener of
+// kind, such as "httptunnel" or "dns", and returns its first non-Unix address.
+func discoverListener(ctx context.Context, exited <-chan struct{}, kind, controlAddr, cookiePath string) (string, error) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if client, err := NewControlClient(controlAddr, ControlAuthFromCookie(cookiePath), 2*time.Second); err == nil {
+			listeners, err := client.Listeners(ctx, kind)
+			_ = client.Close() //nolint:errcheck // read-only query
+			if err == nil {
+				for _, l := range listeners {
+					if !strings.HasPrefix(l, "unix:") {
+						return l, nil
+					}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", newError(ErrTimeout, "discoverPorts", "timed out waiting for tor to report its "+kind+" listener", ctx.Err())
+		case <-exited:
+			return "", newError(ErrTorLaunchFailed, "discoverPorts", "tor exited during startup", nil)
+		case <-ticker.C:
+		}
+	}
+}
+
+// readControlPortFile returns the first TCP address in a ControlPortWriteToFile
+// file, whose lines look like "PORT=127.0.0.1:9051".
+func readControlPortFile(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if addr, ok := strings.CutPrefix(strings.TrimSpace(line), "PORT="); ok && !strings.HasPrefix(addr, "unix:") {
+			return addr, nil
+		}
+	}
+	return "", newError(ErrTorLaunchFailed, "readControlPortFile", "no TCP ControlPort in "+path, nil)
+}
+
+// querySocksListeners asks the ControlPort at controlAddr for tor's SocksPort
+// listeners using cookie authentication.
+func querySocksListeners(ctx context.Context, controlAddr, cookiePath string) ([]string, error) {
+	client, err := NewControlClient(controlAddr, ControlAuthFromCookie(cookiePath), 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.Listeners(ctx, "socks")
+}
+
+// waitForPorts polls for SocksPort/ControlPort reachability, returning early
+// with an error when exited is closed or ctx times out.
+func waitForPorts(ctx context.Context, exited <-chan struct{}, socksAddr, controlAddr string) error {
 	ticker := time.NewTicker(200 * time.Millisecond)
 	defer ticker.Stop()
 
@@ -262,6 +622,8 @@ func waitForPorts(ctx context.Context, socksAddr, controlAddr string) error {
 		select {
 		case <-ctx.Done():
 			return newError(ErrTimeout, "waitForPorts", "timed out waiting for tor to become ready", ctx.Err())
+		case <-exited:
+			return newError(ErrTorLaunchFailed, "waitForPorts", "tor exited during startup", nil)
 		case <-ticker.C:
 			if portsReachable(socksAddr, controlAddr) {
 				return nil
@@ -299,16 +661,137 @@ func (w *teeWriter) Write(p []byte) (int, error) {
 	return n, err
 }
 
-// terminateCmd kills the process associated with cmd and waits for it to exit.
-func terminateCmd(cmd *exec.Cmd) error {
-	if cmd == nil || cmd.Process == nil {
+// Flush reports a final line that was not terminated by a newline.
+func (w *teeWriter) Flush() {
+	if w.reporter != nil && len(w.partial) > 0 {
+		w.reporter(string(w.partial))
+	}
+	w.partial = nil
+}
+
+// processWait reaps a started command exactly once so that both Stop and
+// watchers such as TorSupervisor can observe its exit.
+type processWait struct {
+	// cmd is the started command.
+	cmd *exec.Cmd
+	// done is closed after cmd.Wait returns.
+	done chan struct{}
+	// err is the result of cmd.Wait, valid once done is closed.
+	err error
+}
+
+// watchProcess starts reaping the already started cmd in the background.
+func watchProcess(cmd *exec.Cmd) *processWait {
+	w := &processWait{cmd: cmd, done: make(chan struct{})}
+	go w.reap()
+	return w
+}
+
+// startProcess starts cmd and reaps it in the background. With pinThread,
+// cmd is started and reaped on a single locked OS thread that lives exactly
+// as long as the process, so that a parent-death signal (Pdeathsig), which
+// fires when the starting thread exits, is not sent while tor still runs.
+func startProcess(cmd *exec.Cmd, pinThread bool) (*processWait, error) {
+	if !pinThread {
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return watchProcess(cmd), nil
+	}
+	w := &processWait{cmd: cmd, done: make(chan struct{})}
+	started := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if err := cmd.Start(); err != nil {
+			started <- err
+			return
+		}
+		started <- nil
+		w.reap()
+	}()
+	if err := <-started; err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// reap waits for the process to exit and closes done.
+func (w *processWait) reap() {
+	w.err = w.cmd.Wait()
+	// Wait has finished copying output, so a trailing line without a
+	// newline (often tor's last error before exiting) can be reported.
+	for _, out := range []io.Writer{w.cmd.Stdout, w.cmd.Stderr} {
+		if tw, ok := out.(*teeWriter); ok {
+			tw.Flush()
+		}
+	}
+	close(w.done)
+}
+
+// stopCmd shuts tor down gracefully via its ControlPort, falling back to
+// terminateCmd when that is not possible.
+func stopCmd(w *processWait, controlAddr string) error {
+	if w == nil || w.cmd.Process == nil {
+		return nil
+	}
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	if controlAddr == "" || requestShutdown(controlAddr) != nil {
+		return terminateCmd(w)
+	}
+
+	timer := time.NewTimer(gracefulStopTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.done:
+	case <-timer.C:
+		if killErr := w.cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
+			return killErr
+		}
+		<-w.done
+	}
+	var exitErr *exec.ExitError
+	if w.err != nil && !errors.As(w.err, &exitErr) && !errors.Is(w.err, os.ErrProcessDone) {
+		return w.err
+	}
+	return nil
+}
+
+// requestShutdown sends SIGNAL SHUTDOWN to the ControlPort at controlAddr
+// using cookie authentication.
+func requestShutdown(controlAddr string) error {
+	cookiePath, err := tryGetCookiePath(controlAddr)
+	if err != nil {
+		return err
+	}
+	client, err := NewControlClient(controlAddr, ControlAuthFromCookie(cookiePath), 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return client.Signal(ctx, SignalShutdown)
+}
+
+// terminateCmd kills the process watched by w and waits for it to exit.
+func terminateCmd(w *processWait) error {
+	if w == nil || w.cmd.Process == nil {
 		return nil
 	}
-	if killErr := cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
+	if killErr := w.cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
 		return killErr
 	}
-	if waitErr := cmd.Wait(); waitErr != nil && !errors.Is(waitErr, os.ErrProcessDone) {
-		return waitErr
+	<-w.done
+	var exitErr *exec.ExitError
+	if w.err != nil && !errors.As(w.err, &exitErr) && !errors.Is(w.err, os.ErrProcessDone) {
+		return w.err
 	}
 	return nil
 }
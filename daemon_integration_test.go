@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestStartTorDaemonUsesExplicitConfig ensures tor reads the generated torrc.
@@ -91,3 +93,65 @@ Log notice stdout
 		t.Fatalf("tor logs referenced system torrc; got %q", logged)
 	}
 }
+
+// TestStartTorDaemonLaunchesTransportPlugin ensures tor runs the configured
+// pluggable transport for a bridge, using a stand-in transport executable.
+func TestStartTorDaemonLaunchesTransportPlugin(t *testing.T) {
+	requireIntegration(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("stand-in transport is a shell script")
+	}
+
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "transport-started")
+	transport := filepath.Join(tempDir, "fake-lyrebird")
+	// Speak just enough of the pluggable transport protocol for tor to accept
+	// the transport, then stay alive until tor closes stdin.
+	script := "#!/bin/sh\n" +
+		"echo \"$TOR_PT_CLIENT_TRANSPORTS\" > " + marker + "\n" +
+		"echo 'VERSION 1'\n" +
+		"echo 'CMETHOD obfs4 socks5 127.0.0.1:1'\n" +
+		"echo 'CMETHODS DONE'\n" +
+		"cat > /dev/null\n"
+	if err := os.WriteFile(transport, []byte(script), 0o700); err != nil { //nolint:gosec // test script must be executable
+		t.Fatalf("tornago: failed to write stand-in transport: %v", err)
+	}
+	bridge, err := ParseBridge("obfs4 192.0.2.1:443 4352E58420E68F5E40BF7C74FADDCCD9D1349413 cert=AbCd iat-mode=0")
+	if err != nil {
+		t.Fatalf("tornago: failed to parse bridge: %v", err)
+	}
+
+	launchCfg, err := NewTorLaunchConfig(
+		WithTorBridges(bridge),
+		WithTorTransportPlugin(TransportObfs4, transport),
+	)
+	if err != nil {
+		t.Fatalf("tornago: failed to build launch config: %v", err)
+	}
+	process, err := StartTorDaemon(launchCfg)
+	if err != nil {
+		var te *TornagoError
+		if errors.As(err, &te) && te.Kind == ErrTorBinaryNotFound {
+			t.Skipf("tornago: skipping because tor binary not found: %v", err)
+		}
+		t.Fatalf("tornago: failed to start tor daemon: %v", err)
+	}
+	defer func() {
+		if stopErr := process.Stop(); stopErr != nil {
+			t.Logf("tornago: failed to stop tor process: %v", stopErr)
+		}
+	}()
+
+	// Tor launches transports while connecting to the bridge.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		data, readErr := os.ReadFile(marker)
+		if readErr == nil && strings.Contains(string(data), TransportObfs4) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tornago: tor did not launch the transport plugin (last error: %v)", readErr)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,17 @@
+package tornago
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setParentDeathSignal asks the kernel to kill cmd when the thread that
+// started it exits. Go may retire OS threads at any time, so cmd must be
+// started with startProcess(cmd, true), which keeps that thread alive for the
+// lifetime of the process.
+func setParentDeathSignal(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+}
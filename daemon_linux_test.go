@@ -0,0 +1,57 @@
+package tornago
+
+import (
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetParentDeathSignal(t *testing.T) {
+	cmd := exec.Command("tor")
+	setParentDeathSignal(cmd)
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Pdeathsig != syscall.SIGKILL {
+		t.Errorf("expected Pdeathsig SIGKILL, got %+v", cmd.SysProcAttr)
+	}
+}
+
+func TestStartProcessPinned(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	setParentDeathSignal(cmd)
+	w, err := startProcess(cmd, true)
+	if err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+
+	// Goroutines that exit while locked terminate their OS threads; the
+	// thread that started sleep must not be among them.
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.LockOSThread()
+		}()
+	}
+	wg.Wait()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-w.done:
+		t.Fatalf("process exited early: %v", w.err)
+	default:
+	}
+
+	if err := terminateCmd(w); err != nil {
+		t.Fatalf("terminateCmd failed: %v", err)
+	}
+	<-w.done
+}
+
+func TestStartProcessPinnedStartError(t *testing.T) {
+	cmd := exec.Command("/nonexistent/tornago-test-binary")
+	if _, err := startProcess(cmd, true); err == nil {
+		t.Error("expected error for a missing binary")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tornago
+
+import "os/exec"
+
+// setParentDeathSignal is a no-op outside Linux, where tor relies on
+// __OwningControllerProcess alone.
+func setParentDeathSignal(*exec.Cmd) {}
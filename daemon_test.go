@@ -3,10 +3,13 @@ package tornago
 import (
 	"bytes"
 	"context"
+	"errors"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -165,6 +168,22 @@ func TestTeeWriter(t *testing.T) {
 		}
 	})
 
+	t.Run("should report a trailing partial line on flush", func(t *testing.T) {
+		var reported []string
+		writer := &teeWriter{
+			buf:      &bytes.Buffer{},
+			reporter: func(msg string) { reported = append(reported, msg) },
+		}
+
+		_, _ = writer.Write([]byte("line1\n[err] last words")) //nolint:errcheck
+		writer.Flush()
+		writer.Flush()
+
+		if len(reported) != 2 || reported[1] != "[err] last words" {
+			t.Errorf("unexpected reported lines: %q", reported)
+		}
+	})
+
 	t.Run("should work without reporter", func(t *testing.T) {
 		var buf bytes.Buffer
 		writer := &teeWriter{
@@ -312,3 +331,291 @@ func TestTorStartupTimeout(t *testing.T) {
 		}
 	})
 }
+
+func TestWatchProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on the sh command")
+	}
+
+	t.Run("should flush unterminated output once the process exits", func(t *testing.T) {
+		var reported []string
+		cmd := exec.Command("sh", "-c", "printf 'ok\\n[err] dying'")
+		cmd.Stdout = &teeWriter{
+			buf:      &bytes.Buffer{},
+			reporter: func(msg string) { reported = append(reported, msg) },
+		}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("sh not available: %v", err)
+		}
+		<-watchProcess(cmd).done
+
+		if len(reported) != 2 || reported[1] != "[err] dying" {
+			t.Errorf("unexpected reported lines: %q", reported)
+		}
+	})
+}
+
+func TestStopCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on the sleep command")
+	}
+
+	t.Run("should shut down through the ControlPort", func(t *testing.T) {
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Skipf("sleep not available: %v", err)
+		}
+		cookiePath := filepath.Join(t.TempDir(), "control_auth_cookie")
+		if err := os.WriteFile(cookiePath, bytes.Repeat([]byte{0xAB}, 32), 0o600); err != nil {
+			t.Fatalf("failed to write cookie: %v", err)
+		}
+
+		shutdown := make(chan struct{}, 1)
+		addr := startMockControlServer(t, func(line string) string {
+			switch {
+			case line == "PROTOCOLINFO 1":
+				return "250-PROTOCOLINFO 1\r\n" +
+					"250-AUTH METHODS=COOKIE COOKIEFILE=\"" + cookiePath + "\"\r\n" +
+					"250 OK\r\n"
+			case line == "SIGNAL SHUTDOWN":
+				shutdown <- struct{}{}
+				_ = cmd.Process.Kill()
+			}
+			return ""
+		})
+
+		start := time.Now()
+		if err := stopCmd(watchProcess(cmd), addr); err != nil {
+			t.Fatalf("stopCmd failed: %v", err)
+		}
+		select {
+		case <-shutdown:
+		default:
+			t.Error("expected SIGNAL SHUTDOWN to be sent")
+		}
+		if elapsed := time.Since(start); elapsed >= gracefulStopTimeout {
+			t.Errorf("stopCmd waited %v, expected prompt exit", elapsed)
+		}
+	})
+
+	t.Run("should kill the process without a ControlPort", func(t *testing.T) {
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Skipf("sleep not available: %v", err)
+		}
+		_ = stopCmd(watchProcess(cmd), "127.0.0.1:1")
+		if cmd.ProcessState == nil {
+			t.Error("expected process to be reaped")
+		}
+	})
+}
+
+// writeFakeTor writes a shell script that records its arguments to argsPath
+// and then sleeps, standing in for the tor binary.
+func writeFakeTor(t *testing.T, argsPath string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on a shell script")
+	}
+	path := filepath.Join(t.TempDir(), "fake-tor")
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\nexec sleep 30\n"
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil { //nolint:gosec // test script must be executable
+		t.Fatalf("failed to write fake tor: %v", err)
+	}
+	return path
+}
+
+func TestStartTorDaemonContext(t *testing.T) {
+	t.Run("should abort startup when the context is canceled", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+		bin := writeFakeTor(t, filepath.Join(t.TempDir(), "args"))
+		cfg, err := NewTorLaunchConfig(WithTorBinary(bin), WithTorStartupTimeout(time.Minute))
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		proc, err := StartTorDaemonContext(ctx, cfg)
+		if err == nil {
+			_ = proc.Stop()
+			t.Fatal("expected startup to be aborted")
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("startup took %v after cancellation", elapsed)
+		}
+		entries, _ := os.ReadDir(tmp) //nolint:errcheck
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), "tornago-tor-data-") {
+				t.Errorf("temporary data directory %s was not removed", e.Name())
+			}
+		}
+	})
+
+	t.Run("should not launch with a canceled context", func(t *testing.T) {
+		argsPath := filepath.Join(t.TempDir(), "args")
+		bin := writeFakeTor(t, argsPath)
+		cfg, err := NewTorLaunchConfig(WithTorBinary(bin))
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := StartTorDaemonContext(ctx, cfg); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if _, err := os.Stat(argsPath); !os.IsNotExist(err) {
+			t.Error("tor should not have been launched")
+		}
+	})
+
+	t.Run("should stop tor when the context is done", func(t *testing.T) {
+		argsPath := filepath.Join(t.TempDir(), "args")
+		bin := writeFakeTor(t, argsPath)
+		// The fake tor never listens, so stand in for its ports.
+		socksAddr := startMockControlServer(t, func(string) string { return "" })
+		controlAddr := startMockControlServer(t, func(string) string { return "" })
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(bin),
+			WithTorSocksAddr(socksAddr),
+			WithTorControlAddr(controlAddr),
+			WithTorStopWithContext(),
+			WithTorExitWithParent(),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		proc, err := StartTorDaemonContext(ctx, cfg)
+		if err != nil {
+			t.Fatalf("StartTorDaemonContext failed: %v", err)
+		}
+		defer proc.Stop()
+		dataDir := proc.DataDir()
+
+		cancel()
+		select {
+		case <-proc.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatal("tor was not stopped after the context was canceled")
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := os.Stat(dataDir); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("temporary data directory was not removed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		args, err := os.ReadFile(argsPath)
+		if err != nil {
+			t.Fatalf("failed to read fake tor args: %v", err)
+		}
+		if want := "--__OwningControllerProcess " + strconv.Itoa(os.Getpid()); !strings.Contains(string(args), want) {
+			t.Errorf("expected %q in tor args, got %q", want, args)
+		}
+	})
+}
+
+func TestListenArg(t *testing.T) {
+	tests := []struct {
+		addr, arg, resolved string
+	}{
+		{"127.0.0.1:0", "127.0.0.1:auto", ""},
+		{":0", "127.0.0.1:auto", ""},
+		{"[::1]:0", "[::1]:auto", ""},
+		{"127.0.0.1:9050", "127.0.0.1:9050", "127.0.0.1:9050"},
+	}
+	for _, tt := range tests {
+		arg, resolved, err := listenArg(tt.addr)
+		if err != nil {
+			t.Fatalf("listenArg(%q) failed: %v", tt.addr, err)
+		}
+		if arg != tt.arg || resolved != tt.resolved {
+			t.Errorf("listenArg(%q) = %q, %q; want %q, %q", tt.addr, arg, resolved, tt.arg, tt.resolved)
+		}
+	}
+	if _, _, err := listenArg("not an address"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
+
+func TestReadControlPortFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), controlPortFileName)
+	t.Run("should return the first TCP port", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("UNIX_PORT=unix:/tmp/control\nPORT=127.0.0.1:37251\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		addr, err := readControlPortFile(path)
+		if err != nil || addr != "127.0.0.1:37251" {
+			t.Errorf("readControlPortFile() = %q, %v", addr, err)
+		}
+	})
+
+	t.Run("should fail without a TCP port", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("PORT=unix:/tmp/control\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readControlPortFile(path); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+// writePortFileTor writes a fake tor that reports controlAddr through
+// ControlPortWriteToFile and creates an auth cookie, then sleeps.
+func writePortFileTor(t *testing.T, controlAddr string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on a shell script")
+	}
+	path := filepath.Join(t.TempDir(), "fake-tor")
+	script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --ControlPortWriteToFile) printf 'PORT=` + controlAddr + `\n' > "$2.tmp" && mv "$2.tmp" "$2" ;;
+    --CookieAuthFile) printf '0123456789abcdef0123456789abcdef' > "$2" ;;
+  esac
+  shift
+done
+exec sleep 30
+`
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil { //nolint:gosec // test script must be executable
+		t.Fatalf("failed to write fake tor: %v", err)
+	}
+	return path
+}
+
+func TestStartTorDaemonAutoPorts(t *testing.T) {
+	// Stand-in for tor's SocksPort, which the ControlPort reports.
+	socksAddr := startMockControlServer(t, func(string) string { return "" })
+	controlAddr := startMockControlServer(t, func(cmd string) string {
+		if cmd == "GETINFO net/listeners/socks" {
+			return "250-net/listeners/socks=\"" + socksAddr + "\"\r\n250 OK\r\n"
+		}
+		return ""
+	})
+	cfg, err := NewTorLaunchConfig(WithTorBinary(writePortFileTor(t, controlAddr)), WithTorStartupTimeout(10*time.Second))
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+	proc, err := StartTorDaemon(cfg)
+	if err != nil {
+		t.Fatalf("StartTorDaemon failed: %v", err)
+	}
+	defer proc.Stop()
+
+	if proc.SocksAddr() != socksAddr || proc.ControlAddr() != controlAddr {
+		t.Errorf("expected discovered addresses %s/%s, got %s/%s", socksAddr, controlAddr, proc.SocksAddr(), proc.ControlAddr())
+	}
+}
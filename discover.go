@@ -0,0 +1,397 @@
+package tornago
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// opDiscoverTor labels errors originating from DiscoverTor.
+	opDiscoverTor = "DiscoverTor"
+	// discoverProbeTimeout bounds each connection attempt while probing.
+	discoverProbeTimeout = 2 * time.Second
+)
+
+// torDiscovery lists where DiscoverTor looks for a running Tor.
+type torDiscovery struct {
+	// torrcPaths are torrc files read for SocksPort, ControlPort,
+	// ControlSocket, ControlPortWriteToFile and CookieAuthFile.
+	torrcPaths []string
+	// controlAddrs are ControlPort addresses probed after those from torrc.
+	controlAddrs []string
+	// socksAddrs are SocksPort addresses probed after those from torrc.
+	socksAddrs []string
+	// timeout bounds each probe.
+	timeout time.Duration
+}
+
+// defaultTorDiscovery covers system Tor on Linux, BSD and Homebrew and the
+// ports of Tor Browser.
+func defaultTorDiscovery() torDiscovery {
+	return torDiscovery{
+		torrcPaths:   []string{"/etc/tor/torrc", "/usr/local/etc/tor/torrc", "/opt/homebrew/etc/tor/torrc"},
+		controlAddrs: []string{"127.0.0.1:9051", "127.0.0.1:9151", "unix:/run/tor/control"},
+		socksAddrs:   []string{"127.0.0.1:9050", "127.0.0.1:9150", "unix:/run/tor/socks"},
+		timeout:      discoverProbeTimeout,
+	}
+}
+
+// DiscoverTor looks for a running Tor, such as a system Tor or Tor Browser,
+// and returns a ClientConfig for it. It reads the SocksPort, ControlPort and
+// ControlPortWriteToFile settings of the system torrc, then probes the
+// well-known ports 9050/9051 and 9150/9151 and the Unix sockets
+// /run/tor/socks and /run/tor/control.
+//
+// When a ControlPort answers, its authentication is resolved with
+// PROTOCOLINFO: no authentication, or the cookie file when it is readable.
+// The SocksPort is then taken from the ControlPort's listeners. Otherwise the
+// first address that answers a SOCKS5 greeting is used. A ControlPort that
+// needs a password is only used when opts contain WithClientControlPassword.
+//
+// opts are applied after the discovered settings and override them. The
+// error has kind ErrTorNotFound when no SocksPort answers.
+//
+// Example:
+//
+//	cfg, err := tornago.DiscoverTor(ctx, tornago.WithClientRequestTimeout(time.Minute))
+//	if err != nil {
+//	    return err
+//	}
+//	client, err := tornago.NewClient(cfg)
+func DiscoverTor(ctx context.Context, opts ...ClientOption) (ClientConfig, error) {
+	return defaultTorDiscovery().discover(ctx, opts...)
+}
+
+// discover runs DiscoverTor against the locations of d.
+func (d torDiscovery) discover(ctx context.Context, opts ...ClientOption) (ClientConfig, error) {
+	base, err := NewClientConfig(opts...)
+	if err != nil {
+		return ClientConfig{}, err
+	}
+	socksAddrs, controlAddrs, cookiePath := d.readTorrcs()
+	socksAddrs = appendUnique(socksAddrs, d.socksAddrs...)
+	controlAddrs = appendUnique(controlAddrs, d.controlAddrs...)
+
+	var found []ClientOption
+	controlAddr, auth, ok := d.findControl(ctx, controlAddrs, cookiePath, base.ControlAuth().Password() != "")
+	if ok {
+		found = append(found, WithClientControlAddr(controlAddr))
+		if auth.CookiePath() != "" {
+			found = append(found, WithClientControlCookie(auth.CookiePath()))
+		}
+		if auth.Password() == "" {
+			auth.password = base.ControlAuth().Password()
+		}
+		// Listeners reported by Tor come first because they are authoritative.
+		if listeners, err := d.controlSocksListeners(ctx, controlAddr, auth); err == nil {
+			socksAddrs = appendUnique(listeners, socksAddrs...)
+		}
+	}
+
+	socksAddr := ""
+	for _, addr := range socksAddrs {
+		if err := ctx.Err(); err != nil {
+			return ClientConfig{}, newError(ErrTimeout, opDiscoverTor, "discovery canceled", err)
+		}
+		if d.probeSocks(ctx, addr) {
+			socksAddr = addr
+			break
+		}
+	}
+	if socksAddr == "" {
+		return ClientConfig{}, newError(ErrTorNotFound, opDiscoverTor,
+			"no running Tor found; tried SocksPorts "+strings.Join(socksAddrs, ", "), nil)
+	}
+	found = append(found, WithClientSocksAddr(socksAddr))
+	return NewClientConfig(append(found, opts...)...)
+}
+
+// readTorrcs collects listener addresses and the cookie file from the torrc
+// files that exist. Unreadable files are skipped.
+func (d torDiscovery) readTorrcs() (socksAddrs, controlAddrs []string, cookiePath string) {
+	for _, path := range d.torrcPaths {
+		rc, err := LoadTorrc(path)
+		if err != nil {
+			continue
+		}
+		for _, v := range rc.GetAll("SocksPort") {
+			if addr, ok := torrcListenAddr(v); ok {
+				socksAddrs = appendUnique(socksAddrs, addr)
+			}
+		}
+		if file, ok := rc.Get("ControlPortWriteToFile"); ok {
+			if addr, err := readControlPortFile(file); err == nil {
+				controlAddrs = appendUnique(controlAddrs, addr)
+			}
+		}
+		for _, v := range rc.GetAll("ControlPort") {
+			if addr, ok := torrcListenAddr(v); ok {
+				controlAddrs = appendUnique(controlAddrs, addr)
+			}
+		}
+		for _, v := range rc.GetAll("ControlSocket") {
+			if addr, ok := torrcListenAddr("unix:" + strings.TrimPrefix(v, "unix:")); ok {
+				controlAddrs = appendUnique(controlAddrs, addr)
+			}
+		}
+		if v, ok := rc.Get("CookieAuthFile"); ok && cookiePath == "" {
+			cookiePath = v
+		}
+	}
+	return socksAddrs, controlAddrs, cookiePath
+}
+
+// torrcListenAddr converts a SocksPort/ControlPort torrc value such as
+// "9050", "127.0.0.1:9050 IsolateDestAddr" or "unix:/run/tor/socks" into a
+// dialable address. "auto" and disabled ports report false.
+func torrcListenAddr(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if path, ok := strings.CutPrefix(value, "unix:"); ok {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return "unix:" + unquoted, true
+		}
+		path, _, _ = strings.Cut(path, " ")
+		return "unix:" + path, path != ""
+	}
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", false
+	}
+	addr := fields[0]
+	if port, err := strconv.Atoi(addr); err == nil {
+		return net.JoinHostPort("127.0.0.1", addr), port > 0 && port <= 65535
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "auto" || port == "0" {
+		return "", false
+	}
+	return net.JoinHostPort(host, port), true
+}
+
+// findControl returns the first ControlPort in addrs that answers
+// PROTOCOLINFO with an authentication method this client can satisfy.
+func (d torDiscovery) findControl(ctx context.Context, addrs []string, cookiePath string, havePassword bool) (string, ControlAuth, bool) {
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			break
+		}
+		info, err := queryProtocolInfo(ctx, addr, d.timeout)
+		if err != nil {
+			continue
+		}
+		switch {
+		case slices.Contains(info.methods, "NULL"):
+			return addr, ControlAuth{}, true
+		case slices.Contains(info.methods, "COOKIE"):
+			for _, path := range []string{info.cookieFile, cookiePath} {
+				if path != "" && readableFile(path) {
+					return addr, ControlAuthFromCookie(path), true
+				}
+			}
+		}
+		if havePassword && slices.Contains(info.methods, "HASHEDPASSWORD") {
+			return addr, ControlAuth{}, true
+		}
+	}
+	return "", ControlAuth{}, false
+}
+
+// controlSocksListeners returns the SocksPort listeners of the ControlPort.
+func (d torDiscovery) controlSocksListeners(ctx context.Context, controlAddr string, auth ControlAuth) ([]string, error) {
+	client, err := NewControlClient(controlAddr, auth, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	return client.Listeners(ctx, "socks")
+}
+
+// probeSocks reports whether addr answers a SOCKS5 greeting offering no
+// authentication.
+func (d torDiscovery) probeSocks(ctx context.Context, addr string) bool {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	network, address := listenerNetwork(addr)
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline) //nolint:errcheck // a failed probe is reported below
+	}
+	if err := writeAll(conn, []byte{0x05, 0x01, 0x00}); err != nil {
+		return false
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+	return reply[0] == 0x05 && reply[1] == 0x00
+}
+
+// protocolInfo is the parsed PROTOCOLINFO reply.
+type protocolInfo struct {
+	// methods are the accepted authentication methods, e.g. "COOKIE".
+	methods []string
+	// cookieFile is the cookie path Tor reported, if any.
+	cookieFile string
+}
+
+// queryProtocolInfo sends PROTOCOLINFO to the ControlPort at addr.
+func queryProtocolInfo(ctx context.Context, addr string, timeout time.Duration) (protocolInfo, error) {
+	client, err := NewControlClient(addr, ControlAuth{}, timeout)
+	if err != nil {
+		return protocolInfo{}, err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	lines, err := client.execCommand(ctx, "PROTOCOLINFO 1")
+	if err != nil {
+		return protocolInfo{}, err
+	}
+	var info protocolInfo
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, "AUTH ")
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, `COOKIEFILE=`); i >= 0 {
+			if path, err := strconv.QuotedPrefix(rest[i+len(`COOKIEFILE=`):]); err == nil {
+				if unquoted, err := strconv.Unquote(path); err == nil {
+					info.cookieFile = filepath.Clean(unquoted)
+				}
+			}
+		}
+		for _, field := range strings.Fields(rest) {
+			if methods, ok := strings.CutPrefix(field, "METHODS="); ok {
+				info.methods = strings.Split(methods, ",")
+			}
+		}
+	}
+	if info.methods == nil {
+		return protocolInfo{}, newError(ErrControlRequestFail, opDiscoverTor, "PROTOCOLINFO reply from "+addr+" has no AUTH line", nil)
+	}
+	return info, nil
+}
+
+// readableFile reports whether path can be opened for reading.
+func readableFile(path string) bool {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	return true
+}
+
+// appendUnique appends the values of add that are not in list yet.
+func appendUnique(list []string, add ...string) []string {
+	for _, v := range add {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// NewClientFromEnv creates a Client configured from the environment
+// variables used by Tor Browser and torsocks-style tooling:
+//
+//   - TOR_SOCKS_PORT (and TOR_SOCKS_HOST): the SocksPort, as a port or
+//     "host:port"
+//   - ALL_PROXY or all_proxy: a socks5:// or socks5h:// URL, used when
+//     TOR_SOCKS_PORT is unset
+//   - TOR_CONTROL_PORT (and TOR_CONTROL_HOST): the ControlPort
+//   - TOR_CONTROL_COOKIE_AUTH_FILE: the control cookie file
+//
+// Hosts default to 127.0.0.1 and unset variables keep the ClientConfig
+// defaults. opts are applied after the environment and override it.
+func NewClientFromEnv(opts ...ClientOption) (*Client, error) {
+	envOpts, err := clientOptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := NewClientConfig(append(envOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(cfg)
+}
+
+// clientOptionsFromEnv reads the variables documented on NewClientFromEnv.
+func clientOptionsFromEnv() ([]ClientOption, error) {
+	var opts []ClientOption
+	if port := os.Getenv("TOR_SOCKS_PORT"); port != "" {
+		addr, err := envHostPort("TOR_SOCKS_PORT", os.Getenv("TOR_SOCKS_HOST"), port)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClientSocksAddr(addr))
+	} else if proxy := cmp.Or(os.Getenv("ALL_PROXY"), os.Getenv("all_proxy")); proxy != "" {
+		addr, err := socksAddrFromProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClientSocksAddr(addr))
+	}
+	if port := os.Getenv("TOR_CONTROL_PORT"); port != "" {
+		addr, err := envHostPort("TOR_CONTROL_PORT", os.Getenv("TOR_CONTROL_HOST"), port)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClientControlAddr(addr))
+	}
+	if path := os.Getenv("TOR_CONTROL_COOKIE_AUTH_FILE"); path != "" {
+		opts = append(opts, WithClientControlCookie(path))
+	}
+	return opts, nil
+}
+
+// envHostPort builds an address from a port variable, which may also hold a
+// full "host:port", and an optional host variable.
+func envHostPort(name, host, port string) (string, error) {
+	if strings.HasPrefix(port, "unix:") {
+		return port, nil
+	}
+	if h, p, err := net.SplitHostPort(port); err == nil {
+		host, port = h, p
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", newError(ErrInvalidConfig, "NewClientFromEnv", name+" must be a port number or host:port, got "+strconv.Quote(port), nil)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// socksAddrFromProxyURL extracts the SocksPort from an ALL_PROXY URL.
+func socksAddrFromProxyURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Hostname() == "" {
+		// Avoid echoing the URL, which may contain credentials.
+		return "", newError(ErrInvalidConfig, "NewClientFromEnv", "ALL_PROXY must be a socks5:// or socks5h:// URL", nil)
+	}
+	if u.User != nil {
+		return "", newError(ErrInvalidConfig, "NewClientFromEnv", "ALL_PROXY credentials are not supported", nil)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "1080"
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
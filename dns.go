@@ -0,0 +1,85 @@
+package tornago
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// opTorDNSResolver labels errors originating from TorDNSResolver.
+	opTorDNSResolver = "TorDNSResolver"
+	// defaultDNSTimeout bounds each DNS exchange with the DNSPort.
+	defaultDNSTimeout = 10 * time.Second
+)
+
+// TorDNSResolver resolves names through Tor's DNSPort, for code that takes a
+// *net.Resolver but cannot be changed to dial through SOCKS. Queries leave
+// through Tor exits, so the local network never sees the names looked up.
+//
+// Tor's DNSPort only answers over UDP and supports A, AAAA and PTR queries.
+// Go's resolver refuses .onion names, so reach onion services through Client
+// instead.
+//
+// Example:
+//
+//	launchCfg, _ := tornago.NewTorLaunchConfig(tornago.WithTorDNSAddr(":0"))
+//	torProc, _ := tornago.StartTorDaemon(launchCfg)
+//	res, _ := tornago.NewTorDNSResolver(torProc.DNSAddr(), 0)
+//	addrs, err := res.Resolver().LookupHost(ctx, "example.com")
+type TorDNSResolver struct {
+	// addr is the DNSPort address.
+	addr string
+	// timeout bounds each exchange with the DNSPort.
+	timeout time.Duration
+}
+
+// NewTorDNSResolver returns a resolver for the DNSPort at addr. A timeout of
+// zero uses 10 seconds.
+func NewTorDNSResolver(addr string, timeout time.Duration) (*TorDNSResolver, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "0" {
+		return nil, newError(ErrInvalidConfig, opTorDNSResolver, "DNSPort address must be host:port, got "+addr, err)
+	}
+	if timeout < 0 {
+		return nil, newError(ErrInvalidConfig, opTorDNSResolver, "timeout must not be negative", nil)
+	}
+	if timeout == 0 {
+		timeout = defaultDNSTimeout
+	}
+	return &TorDNSResolver{addr: addr, timeout: timeout}, nil
+}
+
+// Addr returns the DNSPort address.
+func (r *TorDNSResolver) Addr() string { return r.addr }
+
+// Resolver returns a *net.Resolver that sends every query to the DNSPort,
+// regardless of the system resolver configuration.
+func (r *TorDNSResolver) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     r.dial,
+	}
+}
+
+// dial connects to the DNSPort. UDP is used even when the resolver asks for
+// TCP, because the DNSPort does not accept TCP.
+func (r *TorDNSResolver) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: r.timeout}
+	return dialer.DialContext(ctx, "udp", r.addr)
+}
+
+// validateDNSPort checks the DNSPort launch option.
+func validateDNSPort(cfg TorLaunchConfig) error {
+	if cfg.dnsAddr == "" {
+		return nil
+	}
+	if cfg.torConfigFile != "" {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			"WithTorDNSAddr cannot be combined with WithTorConfigFile. Declare the DNSPort in the torrc file instead", nil)
+	}
+	if _, _, err := listenArg(cfg.dnsAddr); err != nil {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig", "invalid DNSAddr", err)
+	}
+	return nil
+}
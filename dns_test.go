@@ -0,0 +1,220 @@
+package tornago
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDNSPort is a stand-in for Tor's DNSPort that answers A queries from a
+// fixed table and AAAA queries with no records.
+type fakeDNSPort struct {
+	conn    net.PacketConn
+	records map[string]net.IP
+	mu      sync.Mutex
+	names   []string
+}
+
+func startFakeDNSPort(t *testing.T, records map[string]net.IP) *fakeDNSPort {
+	t.Helper()
+	lc := net.ListenConfig{}
+	conn, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	s := &fakeDNSPort{conn: conn, records: records}
+	go s.serve()
+	return s
+}
+
+func (s *fakeDNSPort) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.answer(buf[:n]); reply != nil {
+			_, _ = s.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// answer builds a reply holding the question of query and, for known A
+// names, one answer record.
+func (s *fakeDNSPort) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	end := 12
+	var labels []string
+	for end < len(query) && query[end] != 0 {
+		l := int(query[end])
+		if end+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[end+1:end+1+l]))
+		end += 1 + l
+	}
+	end += 5 // zero label, QTYPE and QCLASS
+	if end > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(query[end-4 : end-2])
+	s.mu.Lock()
+	s.names = append(s.names, name)
+	s.mu.Unlock()
+
+	reply := slices.Clone(query[:end])
+	reply[2] = 0x81                           // QR, RD
+	reply[3] = 0x80                           // RA, NOERROR
+	binary.BigEndian.PutUint16(reply[6:], 0)  // ANCOUNT
+	binary.BigEndian.PutUint16(reply[8:], 0)  // NSCOUNT
+	binary.BigEndian.PutUint16(reply[10:], 0) // ARCOUNT
+	ip, ok := s.records[name]
+	if !ok {
+		reply[3] |= 0x03 // NXDOMAIN
+		return reply
+	}
+	if qtype != 1 {
+		return reply
+	}
+	binary.BigEndian.PutUint16(reply[6:], 1)
+	reply = append(reply, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+	return append(reply, ip.To4()...)
+}
+
+func (s *fakeDNSPort) queried() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.names)
+}
+
+func TestTorDNSResolver(t *testing.T) {
+	t.Run("should resolve names through the DNSPort", func(t *testing.T) {
+		server := startFakeDNSPort(t, map[string]net.IP{
+			"example.com": net.ParseIP("93.184.216.34"),
+		})
+		res, err := NewTorDNSResolver(server.conn.LocalAddr().String(), time.Second)
+		if err != nil {
+			t.Fatalf("NewTorDNSResolver failed: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		addrs, err := res.Resolver().LookupHost(ctx, "example.com.")
+		if err != nil {
+			t.Fatalf("LookupHost failed: %v", err)
+		}
+		if !slices.Contains(addrs, "93.184.216.34") {
+			t.Errorf("addrs = %v, want 93.184.216.34", addrs)
+		}
+		if !slices.Contains(server.queried(), "example.com") {
+			t.Errorf("DNSPort saw %v, want example.com", server.queried())
+		}
+	})
+
+	t.Run("should report unknown names", func(t *testing.T) {
+		server := startFakeDNSPort(t, nil)
+		res, err := NewTorDNSResolver(server.conn.LocalAddr().String(), time.Second)
+		if err != nil {
+			t.Fatalf("NewTorDNSResolver failed: %v", err)
+		}
+		_, err = res.Resolver().LookupHost(context.Background(), "missing.example.")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("error = %v, want not found", err)
+		}
+	})
+
+	t.Run("should validate its arguments", func(t *testing.T) {
+		for _, addr := range []string{"", "127.0.0.1", "127.0.0.1:0", ":5353"} {
+			if _, err := NewTorDNSResolver(addr, 0); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+				t.Errorf("NewTorDNSResolver(%q) error = %v, want ErrInvalidConfig", addr, err)
+			}
+		}
+		if _, err := NewTorDNSResolver("127.0.0.1:5353", -time.Second); err == nil {
+			t.Error("expected error for negative timeout")
+		}
+		res, err := NewTorDNSResolver("127.0.0.1:5353", 0)
+		if err != nil || res.Addr() != "127.0.0.1:5353" || res.timeout != defaultDNSTimeout {
+			t.Errorf("NewTorDNSResolver = %+v, %v", res, err)
+		}
+	})
+}
+
+func TestValidateDNSPort(t *testing.T) {
+	t.Run("should reject invalid DNSPort settings", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorDNSAddr("not an address")); err == nil {
+			t.Error("expected error for invalid address")
+		}
+		if _, err := NewTorLaunchConfig(WithTorDNSAddr(":0"), WithTorConfigFile("/etc/tor/torrc")); err == nil {
+			t.Error("expected error when combined with WithTorConfigFile")
+		}
+	})
+}
+
+func TestStartTorDaemonDNSPort(t *testing.T) {
+	t.Run("should enable the DNSPort and discover its address", func(t *testing.T) {
+		socksAddr := startMockControlServer(t, func(string) string { return "" })
+		controlAddr := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO net/listeners/socks":
+				return "250-net/listeners/socks=\"" + socksAddr + "\"\r\n250 OK\r\n"
+			case "GETINFO net/listeners/dns":
+				return "250-net/listeners/dns=\"127.0.0.1:45353\"\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(writePortFileTor(t, controlAddr)),
+			WithTorDNSAddr(":0"),
+			WithTorStartupTimeout(10*time.Second),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		proc, err := StartTorDaemon(cfg)
+		if err != nil {
+			t.Fatalf("StartTorDaemon failed: %v", err)
+		}
+		defer proc.Stop()
+		if proc.DNSAddr() != "127.0.0.1:45353" {
+			t.Errorf("DNSAddr = %q, want 127.0.0.1:45353", proc.DNSAddr())
+		}
+	})
+
+	t.Run("should pass DNSPort and AutomapHostsOnResolve to tor", func(t *testing.T) {
+		argsPath := filepath.Join(t.TempDir(), "args")
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(writeFakeTor(t, argsPath)),
+			WithTorSocksAddr("127.0.0.1:1"),
+			WithTorControlAddr("127.0.0.1:2"),
+			WithTorDNSAddr("127.0.0.1:5353"),
+			WithTorStartupTimeout(200*time.Millisecond),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if proc, err := StartTorDaemon(cfg); err == nil {
+			_ = proc.Stop()
+		}
+		args, err := os.ReadFile(argsPath)
+		if err != nil {
+			t.Fatalf("failed to read tor args: %v", err)
+		}
+		if !strings.Contains(string(args), "--DNSPort 127.0.0.1:5353 --AutomapHostsOnResolve 1") {
+			t.Errorf("tor args = %q, want DNSPort with AutomapHostsOnResolve", args)
+		}
+	})
+}
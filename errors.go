@@ -15,7 +15,7 @@ const (
 	ErrTorBinaryNotFound ErrorKind = "tor_binary_not_found"
 	// ErrTorLaunchFailed indicates tor failed to launch or exited unexpectedly.
 	ErrTorLaunchFailed ErrorKind = "tor_launch_failed"
-	// ErrSocksDialFailed indicates SOCKS5 dialing failed.
+	// ErrSocksDialFailed indicates dialing through the SocksPort or HTTPTunnelPort failed.
 	ErrSocksDialFailed ErrorKind = "socks_dial_failed"
 	// ErrControlAuthFailed indicates ControlPort authentication failed.
 	ErrControlAuthFailed ErrorKind = "control_auth_failed"
@@ -35,6 +35,11 @@ const (
 	ErrListenerCloseFailed ErrorKind = "listener_close_failed"
 	// ErrAcceptFailed indicates Accept() failed on a listener.
 	ErrAcceptFailed ErrorKind = "accept_failed"
+	// ErrTransportNotFound indicates a pluggable transport executable could
+	// not be located.
+	ErrTransportNotFound ErrorKind = "transport_not_found"
+	// ErrTorNotFound indicates no running Tor could be found.
+	ErrTorNotFound ErrorKind = "tor_not_found"
 	// ErrUnknown is used when no specific classification is available.
 	ErrUnknown ErrorKind = "unknown"
 )
@@ -65,6 +65,8 @@ func TestErrorKinds(t *testing.T) {
 			ErrHiddenServiceFailed,
 			ErrTimeout,
 			ErrIO,
+			ErrTransportNotFound,
+			ErrTorNotFound,
 			ErrUnknown,
 		}
 
@@ -0,0 +1,196 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ControlEvent is an asynchronous event delivered by Tor after SETEVENTS.
+//
+// For "650 CIRC 5 BUILT $AAAA~relay PURPOSE=GENERAL" Type is "CIRC" and Data
+// is "5 BUILT $AAAA~relay PURPOSE=GENERAL". Multi-line events (e.g.
+// CONF_CHANGED or NS) carry their additional lines in Lines.
+type ControlEvent struct {
+	// Type is the event keyword such as "CIRC", "STREAM" or "NOTICE".
+	Type string
+	// Data is the remainder of the first event line after Type.
+	Data string
+	// Lines holds the additional lines of multi-line events.
+	Lines []string
+}
+
+// EventHandler receives events from an EventSubscription. Handlers run on the
+// subscription's reader goroutine, one at a time, and should return quickly.
+type EventHandler func(ControlEvent)
+
+// EventSubscription is a dedicated ControlPort connection that streams
+// asynchronous events to an EventHandler until it is closed.
+type EventSubscription struct {
+	// client owns the dedicated connection used for events.
+	client *ControlClient
+	// done is closed when the reader goroutine exits.
+	done chan struct{}
+	// closing reports whether Close was requested.
+	closing bool
+	// err records why the reader stopped unexpectedly.
+	err error
+	// mu protects closing and err.
+	mu sync.Mutex
+}
+
+// SubscribeEvents opens a second connection to the same ControlPort,
+// authenticates with the same credentials and enables the given event types
+// (e.g. "CIRC", "STREAM", "NOTICE"). Events are delivered to handler until
+// ctx is canceled or Close is called.
+//
+// A dedicated connection keeps events from interleaving with command replies
+// on c, so c remains usable for regular commands.
+//
+// Example:
+//
+//	sub, err := ctrl.SubscribeEvents(ctx, func(ev tornago.ControlEvent) {
+//	    log.Printf("%s %s", ev.Type, ev.Data)
+//	}, "CIRC", "NOTICE")
+//	if err != nil {
+//	    return err
+//	}
+//	defer sub.Close()
+func (c *ControlClient) SubscribeEvents(ctx context.Context, handler EventHandler, events ...string) (*EventSubscription, error) {
+	if handler == nil {
+		return nil, newError(ErrInvalidConfig, opControlClient, "event handler is nil", nil)
+	}
+	if len(events) == 0 {
+		return nil, newError(ErrInvalidConfig, opControlClient, "no events to subscribe to", nil)
+	}
+	names := make([]string, 0, len(events))
+	for _, ev := range events {
+		name := strings.ToUpper(strings.TrimSpace(ev))
+		if !validEventName(name) {
+			return nil, newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid event name %q", ev), nil)
+		}
+		names = append(names, name)
+	}
+
+	client, err := NewControlClient(c.addr, c.auth, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Authenticate(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	if _, err := client.execCommand(ctx, "SETEVENTS "+strings.Join(names, " ")); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	sub := &EventSubscription{
+		client: client,
+		done:   make(chan struct{}),
+	}
+	go sub.readLoop(handler)
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = sub.Close()
+			case <-sub.done:
+			}
+		}()
+	}
+	return sub, nil
+}
+
+// Close stops event delivery and closes the dedicated connection. It waits
+// for the reader goroutine, so it must not be called from an EventHandler.
+func (s *EventSubscription) Close() error {
+	s.mu.Lock()
+	already := s.closing
+	s.closing = true
+	s.mu.Unlock()
+	if already {
+		<-s.done
+		return nil
+	}
+	err := s.client.Close()
+	<-s.done
+	return err
+}
+
+// Done returns a channel that is closed once the subscription stops.
+func (s *EventSubscription) Done() <-chan struct{} { return s.done }
+
+// Err returns the error that stopped the subscription, or nil if it is
+// still running or was closed deliberately.
+func (s *EventSubscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// readLoop reads events until the connection fails or is closed.
+func (s *EventSubscription) readLoop(handler EventHandler) {
+	defer close(s.done)
+	for {
+		ev, err := s.client.readEvent()
+		if err != nil {
+			s.mu.Lock()
+			if !s.closing && !errors.Is(err, net.ErrClosed) {
+				s.err = err
+			}
+			s.mu.Unlock()
+			return
+		}
+		handler(ev)
+	}
+}
+
+// readEvent reads the next 650 event, skipping any other lines.
+func (c *ControlClient) readEvent() (ControlEvent, error) {
+	var ev ControlEvent
+	started := false
+	for {
+		line, err := c.rw.ReadString('\n')
+		if err != nil {
+			return ControlEvent{}, newError(ErrControlRequestFail, opControlClient, "failed to read control event", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 || !strings.HasPrefix(line, "650") {
+			continue
+		}
+		sep, body := line[3], line[4:]
+		if !started {
+			ev.Type, ev.Data, _ = strings.Cut(body, " ")
+			started = true
+		} else if sep != ' ' || body != "OK" {
+			ev.Lines = append(ev.Lines, body)
+		}
+		switch sep {
+		case ' ':
+			return ev, nil
+		case '+':
+			data, err := c.readDataBlock()
+			if err != nil {
+				return ControlEvent{}, err
+			}
+			ev.Lines = append(ev.Lines, data...)
+		}
+	}
+}
+
+// validEventName reports whether name looks like a Tor event keyword.
+func validEventName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
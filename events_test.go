@@ -0,0 +1,98 @@
+package tornago
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		if cmd == "SETEVENTS CIRC NOTICE CONF_CHANGED NS" {
+			return "250 OK\r\n" +
+				"650 CIRC 7 BUILT $" + testRelayFingerprintA + "~alpha PURPOSE=GENERAL\r\n" +
+				"650-CONF_CHANGED\r\n650-ExitNodes={de}\r\n650-StrictNodes=1\r\n650 OK\r\n" +
+				"650+NS\r\nr alpha AAAA BBBB 2025-01-01 00:00:00 192.0.2.1 9001 0\r\ns Running\r\n.\r\n650 OK\r\n" +
+				"650 NOTICE Rate limiting NEWNYM request: delaying by 3 second(s)\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	t.Run("should deliver single, multi-line and data events", func(t *testing.T) {
+		var mu sync.Mutex
+		var got []ControlEvent
+		received := make(chan struct{})
+		sub, err := client.SubscribeEvents(context.Background(), func(ev ControlEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, ev)
+			if len(got) == 4 {
+				close(received)
+			}
+		}, "circ", "NOTICE", "CONF_CHANGED", "NS")
+		if err != nil {
+			t.Fatalf("SubscribeEvents failed: %v", err)
+		}
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+		if err := sub.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		if err := sub.Err(); err != nil {
+			t.Errorf("expected no error after Close, got %v", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if got[0].Type != "CIRC" || !strings.HasPrefix(got[0].Data, "7 BUILT") {
+			t.Errorf("unexpected CIRC event: %+v", got[0])
+		}
+		if got[1].Type != "CONF_CHANGED" || !slices.Equal(got[1].Lines, []string{"ExitNodes={de}", "StrictNodes=1"}) {
+			t.Errorf("unexpected CONF_CHANGED event: %+v", got[1])
+		}
+		if got[2].Type != "NS" || len(got[2].Lines) != 2 || got[2].Lines[1] != "s Running" {
+			t.Errorf("unexpected NS event: %+v", got[2])
+		}
+		if got[3].Type != "NOTICE" || !strings.HasPrefix(got[3].Data, "Rate limiting") {
+			t.Errorf("unexpected NOTICE event: %+v", got[3])
+		}
+	})
+
+	t.Run("should stop when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sub, err := client.SubscribeEvents(ctx, func(ControlEvent) {}, "CIRC", "NOTICE", "CONF_CHANGED", "NS")
+		if err != nil {
+			t.Fatalf("SubscribeEvents failed: %v", err)
+		}
+		cancel()
+		select {
+		case <-sub.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("subscription did not stop after cancel")
+		}
+	})
+
+	t.Run("should validate arguments", func(t *testing.T) {
+		ctx := context.Background()
+		if _, err := client.SubscribeEvents(ctx, nil, "CIRC"); err == nil {
+			t.Error("expected error for nil handler")
+		}
+		if _, err := client.SubscribeEvents(ctx, func(ControlEvent) {}); err == nil {
+			t.Error("expected error for no events")
+		}
+		if _, err := client.SubscribeEvents(ctx, func(ControlEvent) {}, "CIRC STREAM"); err == nil {
+			t.Error("expected error for invalid event name")
+		}
+	})
+}
@@ -67,28 +67,20 @@ func manualRotationExample() {
 	// Create circuit manager
 	manager := tornago.NewCircuitManager(client.Control())
 
-	// Make first request to see initial exit IP
-	exitIP1 := getExitIP(client)
-	fmt.Printf("Initial exit IP: %s\n", exitIP1)
-
-	// Rotate circuit manually
-	fmt.Println("Rotating circuit manually...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Rotate circuit and wait until Tor has built a fresh one
+	fmt.Println("Rotating circuit and waiting for a new one...")
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	if err := manager.RotateNow(ctx); err != nil {
+	result, err := manager.RotateAndWait(ctx, tornago.WithRotateExitIPCheck(client))
+	if err != nil {
 		log.Fatalf("Circuit rotation failed: %v", err)
 	}
 
-	// Wait for new circuit to be established
-	fmt.Println("Waiting for new circuit to establish (10 seconds)...")
-	time.Sleep(10 * time.Second)
-
-	// Make request with new circuit
-	exitIP2 := getExitIP(client)
-	fmt.Printf("After rotation exit IP: %s\n", exitIP2)
+	fmt.Printf("Initial exit IP: %s\n", result.PreviousExitIP)
+	fmt.Printf("After rotation exit IP: %s (circuit %s, %v)\n", result.ExitIP, result.CircuitID, result.Duration)
 
-	if exitIP1 != exitIP2 {
+	if result.ExitIPChanged {
 		fmt.Println("✓ Circuit successfully rotated (IP changed)")
 	} else {
 		fmt.Println("⚠ Circuit rotated but IP remained the same (may happen randomly)")
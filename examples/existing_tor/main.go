@@ -40,27 +40,29 @@ import (
 )
 
 func main() {
-	// Connect to system Tor daemon (default ports on Debian/Ubuntu)
-	// WHY hardcoded port: System Tor uses well-known default ports.
-	// Unlike examples that use :0 (random ports), production systems
-	// use consistent, predictable addresses.
+	// Find the running Tor instead of hardcoding its ports.
+	// WHY discovery: System Tor, Tor Browser and custom installs use
+	// different ports (9050/9051, 9150/9151), Unix sockets (/run/tor/socks)
+	// and cookie paths. DiscoverTor reads /etc/tor/torrc, probes the
+	// well-known endpoints and resolves ControlPort authentication via
+	// PROTOCOLINFO, so the same binary works on all of them.
 	//
-	// NOTE: If your Tor configuration differs, adjust the address:
-	//   - Custom port: "127.0.0.1:19050"
-	//   - Remote Tor: "10.0.1.5:9050" (if Tor runs on another machine)
-	fmt.Println("Connecting to existing Tor daemon...")
+	// NOTE: To pin the addresses instead, set them explicitly:
+	//   tornago.NewClientConfig(tornago.WithClientSocksAddr("127.0.0.1:9050"))
+	// or export TOR_SOCKS_PORT / TOR_CONTROL_PORT and use tornago.NewClientFromEnv.
+	fmt.Println("Discovering existing Tor daemon...")
 
-	clientCfg, err := tornago.NewClientConfig(
-		// WHY no ControlPort: For simple HTTP requests, we only need SocksPort.
-		// ControlPort is only needed for circuit management, Hidden Services, etc.
-		tornago.WithClientSocksAddr("127.0.0.1:9050"),
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	clientCfg, err := tornago.DiscoverTor(ctx,
 		// WHY 60s timeout: Same rationale as other examples.
 		// Production: Consider lower timeout (30s) if system Tor is local and fast.
 		tornago.WithClientRequestTimeout(60*time.Second),
 	)
 	if err != nil {
-		log.Fatalf("Failed to create client config: %v", err)
+		log.Fatalf("Failed to find a running Tor: %v", err)
 	}
+	fmt.Printf("Found Tor: SocksPort %s, ControlPort %q\n", clientCfg.SocksAddr(), clientCfg.ControlAddr())
 
 	client, err := tornago.NewClient(clientCfg)
 	if err != nil {
@@ -0,0 +1,413 @@
+package tornago
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry guard statuses reported by GETINFO entry-guards.
+const (
+	// GuardStatusUp means Tor can currently reach the guard.
+	GuardStatusUp = "up"
+	// GuardStatusNeverConnected means Tor has not connected to the guard yet.
+	GuardStatusNeverConnected = "never-connected"
+	// GuardStatusDown means the guard was unreachable on the last attempt.
+	GuardStatusDown = "down"
+	// GuardStatusUnusable means the guard is currently unsuitable for circuits.
+	GuardStatusUnusable = "unusable"
+	// GuardStatusUnlisted means the guard is missing from the consensus.
+	GuardStatusUnlisted = "unlisted"
+)
+
+// EntryGuard is one entry of Tor's guard list.
+type EntryGuard struct {
+	// Fingerprint is the uppercase hex identity of the guard ("" if Tor only reported a nickname).
+	Fingerprint string
+	// Nickname is the relay nickname, if reported.
+	Nickname string
+	// Status is one of the GuardStatus constants.
+	Status string
+	// Since is when the guard entered its status (zero if not reported).
+	Since time.Time
+}
+
+// VanguardsLiteMode controls Tor's vanguards-lite protection for onion
+// service circuits (the VanguardsLiteEnabled torrc option).
+type VanguardsLiteMode string
+
+const (
+	// VanguardsLiteAuto lets Tor decide; it enables vanguards-lite for clients
+	// and onion services unless full vanguards are configured.
+	VanguardsLiteAuto VanguardsLiteMode = "auto"
+	// VanguardsLiteEnabled always uses vanguards-lite.
+	VanguardsLiteEnabled VanguardsLiteMode = "1"
+	// VanguardsLiteDisabled never uses vanguards-lite.
+	VanguardsLiteDisabled VanguardsLiteMode = "0"
+)
+
+// PathBiasConfig sets Tor's path bias thresholds (the PathBias* torrc
+// options). Path bias detection counts how many circuits through each guard
+// succeed; a guard that makes too many fail may be tagging or dropping
+// traffic. Zero fields leave Tor's defaults, which come from the consensus.
+type PathBiasConfig struct {
+	// CircThreshold is how many circuits must be attempted through a guard
+	// before its success rate is checked (PathBiasCircThreshold, at least 5).
+	CircThreshold int
+	// NoticeRate is the success rate below which Tor logs a notice (PathBiasNoticeRate).
+	NoticeRate float64
+	// WarnRate is the success rate below which Tor logs a warning (PathBiasWarnRate).
+	WarnRate float64
+	// ExtremeRate is the success rate below which Tor logs an error and, with
+	// DropGuards, disables the guard (PathBiasExtremeRate).
+	ExtremeRate float64
+	// DropGuards disables guards whose success rate falls below ExtremeRate (PathBiasDropGuards).
+	DropGuards bool
+	// ScaleThreshold is the attempt count at which the counts are halved, so
+	// recent circuits weigh more (PathBiasScaleThreshold, at least 10).
+	ScaleThreshold int
+	// UseThreshold is how many circuits must have been used before the
+	// stream success rate of a guard is checked (PathBiasUseThreshold, at least 3).
+	UseThreshold int
+	// NoticeUseRate is the use success rate below which Tor logs a notice (PathBiasNoticeUseRate).
+	NoticeUseRate float64
+	// ExtremeUseRate is the use success rate below which Tor logs an error (PathBiasExtremeUseRate).
+	ExtremeUseRate float64
+	// ScaleUseThreshold is the use count at which the use counts are halved
+	// (PathBiasScaleUseThreshold, at least 10).
+	ScaleUseThreshold int
+}
+
+// GuardPathBias holds the path bias counters Tor keeps for one guard.
+type GuardPathBias struct {
+	// Fingerprint is the uppercase hex identity of the guard.
+	Fingerprint string
+	// Nickname is the relay nickname, if recorded.
+	Nickname string
+	// Selection is the guard selection the guard belongs to, e.g. "default" or "bridges".
+	Selection string
+	// CircAttempts counts circuits Tor tried to extend through the guard.
+	CircAttempts float64
+	// CircSuccesses counts circuits through the guard that were built.
+	CircSuccesses float64
+	// SuccessfulCircuitsClosed counts built circuits that closed normally.
+	SuccessfulCircuitsClosed float64
+	// CollapsedCircuits counts built circuits that were closed unexpectedly.
+	CollapsedCircuits float64
+	// UnusableCircuits counts built circuits that could not be used.
+	UnusableCircuits float64
+	// Timeouts counts circuits through the guard that timed out.
+	Timeouts float64
+	// UseAttempts counts circuits through the guard that carried streams.
+	UseAttempts float64
+	// UseSuccesses counts used circuits whose streams succeeded.
+	UseSuccesses float64
+}
+
+// CircSuccessRate returns CircSuccesses / CircAttempts, or 1 before any attempt.
+func (g GuardPathBias) CircSuccessRate() float64 {
+	if g.CircAttempts == 0 {
+		return 1
+	}
+	return g.CircSuccesses / g.CircAttempts
+}
+
+// UseSuccessRate returns UseSuccesses / UseAttempts, or 1 before any use.
+func (g GuardPathBias) UseSuccessRate() float64 {
+	if g.UseAttempts == 0 {
+		return 1
+	}
+	return g.UseSuccesses / g.UseAttempts
+}
+
+// valid reports whether m is a known VanguardsLiteMode.
+func (m VanguardsLiteMode) valid() bool {
+	switch m {
+	case VanguardsLiteAuto, VanguardsLiteEnabled, VanguardsLiteDisabled:
+		return true
+	}
+	return false
+}
+
+// EntryGuards returns Tor's current entry guards (GETINFO entry-guards) in
+// the order Tor prefers them.
+//
+// Example:
+//
+//	guards, _ := ctrl.EntryGuards(ctx)
+//	for _, g := range guards {
+//	    log.Printf("%s (%s) %s", g.Fingerprint, g.Nickname, g.Status)
+//	}
+func (c *ControlClient) EntryGuards(ctx context.Context) ([]EntryGuard, error) {
+	lines, err := c.getInfoLines(ctx, "entry-guards")
+	if err != nil {
+		return nil, err
+	}
+	guards := make([]EntryGuard, 0, len(lines))
+	for _, line := range lines {
+		if guard, ok := parseEntryGuardLine(line); ok {
+			guards = append(guards, guard)
+		}
+	}
+	return guards, nil
+}
+
+// DropGuards makes Tor forget all of its entry guards and pick new ones.
+// This weakens protection against guard discovery attacks, so it should only
+// be used when the current guards are known to be compromised or unusable.
+func (c *ControlClient) DropGuards(ctx context.Context) error {
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "DROPGUARDS")
+	return err
+}
+
+// DropTimeouts resets Tor's learned circuit build timeout so it is measured
+// again from scratch. Requires Tor 0.4.5 or later.
+func (c *ControlClient) DropTimeouts(ctx context.Context) error {
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "DROPTIMEOUTS")
+	return err
+}
+
+// ReadPathBiasStats reads the path bias counters of Tor's guards from the
+// state file in dataDir. Tor does not expose these counters over the
+// ControlPort; it persists them in its state file, which it writes
+// periodically and on exit, so the values can lag behind the running tor.
+//
+// Example:
+//
+//	stats, _ := tornago.ReadPathBiasStats(torProc.DataDir())
+//	for _, g := range stats {
+//	    if g.CircAttempts >= 20 && g.CircSuccessRate() < 0.7 {
+//	        log.Printf("guard %s fails %.0f%% of circuits", g.Fingerprint, 100*(1-g.CircSuccessRate()))
+//	    }
+//	}
+func ReadPathBiasStats(dataDir string) ([]GuardPathBias, error) {
+	if dataDir == "" {
+		return nil, newError(ErrInvalidConfig, "ReadPathBiasStats", "data directory is empty", nil)
+	}
+	path := filepath.Join(dataDir, "state")
+	// #nosec G304 -- the state file of a DataDirectory chosen by the caller.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, newError(ErrIO, "ReadPathBiasStats", "failed to open "+path, err)
+	}
+	defer f.Close()
+
+	var stats []GuardPathBias
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if guard, ok := parseGuardStateLine(scanner.Text()); ok {
+			stats = append(stats, guard)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, newError(ErrIO, "ReadPathBiasStats", "failed to read "+path, err)
+	}
+	return stats, nil
+}
+
+// parseGuardStateLine parses a state file line such as
+// "Guard in=default rsa_id=FINGERPRINT nickname=alpha pb_circ_attempts=10.000000 ...".
+func parseGuardStateLine(line string) (GuardPathBias, bool) {
+	rest, ok := strings.CutPrefix(line, "Guard ")
+	if !ok {
+		return GuardPathBias{}, false
+	}
+	var guard GuardPathBias
+	counters := map[string]*float64{
+		"pb_circ_attempts":              &guard.CircAttempts,
+		"pb_circ_successes":             &guard.CircSuccesses,
+		"pb_successful_circuits_closed": &guard.SuccessfulCircuitsClosed,
+		"pb_collapsed_circuits":         &guard.CollapsedCircuits,
+		"pb_unusable_circuits":          &guard.UnusableCircuits,
+		"pb_timeouts":                   &guard.Timeouts,
+		"pb_use_attempts":               &guard.UseAttempts,
+		"pb_use_successes":              &guard.UseSuccesses,
+	}
+	for _, field := range strings.Fields(rest) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "in":
+			guard.Selection = value
+		case "rsa_id":
+			fp, err := normalizeFingerprint(value)
+			if err != nil {
+				return GuardPathBias{}, false
+			}
+			guard.Fingerprint = fp
+		case "nickname":
+			guard.Nickname = value
+		default:
+			if dst, ok := counters[key]; ok {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					*dst = v
+				}
+			}
+		}
+	}
+	return guard, guard.Fingerprint != ""
+}
+
+// parseEntryGuardLine parses a line such as
+// "$FINGERPRINT~nickname down 2024-01-02 03:04:05".
+func parseEntryGuardLine(line string) (EntryGuard, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return EntryGuard{}, false
+	}
+	var guard EntryGuard
+	id, nickname, hasNickname := strings.Cut(strings.TrimPrefix(fields[0], "$"), "~")
+	if hasNickname {
+		guard.Nickname = nickname
+	}
+	if fp, err := normalizeFingerprint(id); err == nil {
+		guard.Fingerprint = fp
+	} else if !hasNickname {
+		guard.Nickname = id
+	}
+	guard.Status = fields[1]
+	if len(fields) >= 4 {
+		if since, err := time.Parse(time.DateTime, fields[2]+" "+fields[3]); err == nil {
+			guard.Since = since
+		}
+	}
+	return guard, true
+}
+
+// guardArgs renders the guard and vanguard options of cfg as tor CLI args.
+func guardArgs(cfg TorLaunchConfig) []string {
+	var args []string
+	if cfg.vanguardsLite != "" {
+		args = append(args, "--VanguardsLiteEnabled", string(cfg.vanguardsLite))
+	}
+	add := func(key string, nodes []NodeSelector) {
+		if list, err := formatNodeList(nodes); err == nil && list != "" {
+			args = append(args, "--"+key, list)
+		}
+	}
+	add("HSLayer2Nodes", cfg.hsLayer2Nodes)
+	add("HSLayer3Nodes", cfg.hsLayer3Nodes)
+	return append(args, pathBiasArgs(cfg.pathBias)...)
+}
+
+// pathBiasArgs renders the non-zero fields of pb as tor CLI args.
+func pathBiasArgs(pb PathBiasConfig) []string {
+	var args []string
+	addInt := func(key string, v int) {
+		if v != 0 {
+			args = append(args, "--"+key, strconv.Itoa(v))
+		}
+	}
+	addRate := func(key string, v float64) {
+		if v != 0 {
+			args = append(args, "--"+key, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	addInt("PathBiasCircThreshold", pb.CircThreshold)
+	addRate("PathBiasNoticeRate", pb.NoticeRate)
+	addRate("PathBiasWarnRate", pb.WarnRate)
+	addRate("PathBiasExtremeRate", pb.ExtremeRate)
+	if pb.DropGuards {
+		args = append(args, "--PathBiasDropGuards", "1")
+	}
+	addInt("PathBiasScaleThreshold", pb.ScaleThreshold)
+	addInt("PathBiasUseThreshold", pb.UseThreshold)
+	addRate("PathBiasNoticeUseRate", pb.NoticeUseRate)
+	addRate("PathBiasExtremeUseRate", pb.ExtremeUseRate)
+	addInt("PathBiasScaleUseThreshold", pb.ScaleUseThreshold)
+	return args
+}
+
+// validateGuardOptions ensures the guard and vanguard options of cfg are
+// valid. With StrictNodes, a layer node that is also excluded would leave Tor
+// unable to build onion service circuits, so it is rejected up front.
+func validateGuardOptions(cfg TorLaunchConfig) error {
+	if cfg.vanguardsLite != "" && !cfg.vanguardsLite.valid() {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			fmt.Sprintf("invalid vanguards-lite mode %q, expected \"auto\", \"1\" or \"0\"", cfg.vanguardsLite), nil)
+	}
+	for _, layer := range []struct {
+		name  string
+		nodes []NodeSelector
+	}{
+		{"HSLayer2Nodes", cfg.hsLayer2Nodes},
+		{"HSLayer3Nodes", cfg.hsLayer3Nodes},
+	} {
+		if _, err := formatNodeList(layer.nodes); err != nil {
+			return err
+		}
+		if !cfg.strictNodes {
+			continue
+		}
+		for _, n := range layer.nodes {
+			if slices.Contains(cfg.excludeNodes, n) {
+				return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+					fmt.Sprintf("%s entry %s is also excluded with StrictNodes", layer.name, n), nil)
+			}
+		}
+	}
+	return validatePathBias(cfg.pathBias)
+}
+
+// validatePathBias ensures the thresholds of pb meet Tor's minimums, below
+// which Tor silently uses the consensus values, and its rates lie in [0, 1]
+// with extreme <= warn <= notice.
+func validatePathBias(pb PathBiasConfig) error {
+	for _, th := range []struct {
+		name     string
+		value    int
+		minValue int
+	}{
+		{"PathBiasCircThreshold", pb.CircThreshold, 5},
+		{"PathBiasScaleThreshold", pb.ScaleThreshold, 10},
+		{"PathBiasUseThreshold", pb.UseThreshold, 3},
+		{"PathBiasScaleUseThreshold", pb.ScaleUseThreshold, 10},
+	} {
+		if th.value != 0 && th.value < th.minValue {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				fmt.Sprintf("%s must be at least %d, got %d", th.name, th.minValue, th.value), nil)
+		}
+	}
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"PathBiasNoticeRate", pb.NoticeRate},
+		{"PathBiasWarnRate", pb.WarnRate},
+		{"PathBiasExtremeRate", pb.ExtremeRate},
+		{"PathBiasNoticeUseRate", pb.NoticeUseRate},
+		{"PathBiasExtremeUseRate", pb.ExtremeUseRate},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				fmt.Sprintf("%s must be between 0 and 1, got %v", rate.name, rate.value), nil)
+		}
+	}
+	ordered := func(lower, higher float64) bool {
+		return lower == 0 || higher == 0 || lower <= higher
+	}
+	switch {
+	case !ordered(pb.ExtremeRate, pb.WarnRate) || !ordered(pb.WarnRate, pb.NoticeRate) || !ordered(pb.ExtremeRate, pb.NoticeRate):
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			fmt.Sprintf("path bias rates must satisfy extreme <= warn <= notice, got %v, %v, %v", pb.ExtremeRate, pb.WarnRate, pb.NoticeRate), nil)
+	case !ordered(pb.ExtremeUseRate, pb.NoticeUseRate):
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			fmt.Sprintf("path bias use rates must satisfy extreme <= notice, got %v, %v", pb.ExtremeUseRate, pb.NoticeUseRate), nil)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEntryGuards(t *testing.T) {
+	t.Run("should parse guards with and without timestamps", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			if cmd == "GETINFO entry-guards" {
+				return "250+entry-guards=\r\n" +
+					"$" + testRelayFingerprintA + "~alpha up\r\n" +
+					"$" + testRelayFingerprintB + "~beta down 2024-01-02 03:04:05\r\n" +
+					"gamma never-connected\r\n" +
+					".\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		guards, err := client.EntryGuards(context.Background())
+		if err != nil {
+			t.Fatalf("EntryGuards failed: %v", err)
+		}
+		want := []EntryGuard{
+			{Fingerprint: testRelayFingerprintA, Nickname: "alpha", Status: GuardStatusUp},
+			{Fingerprint: testRelayFingerprintB, Nickname: "beta", Status: GuardStatusDown, Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			{Nickname: "gamma", Status: GuardStatusNeverConnected},
+		}
+		if !slices.Equal(guards, want) {
+			t.Errorf("EntryGuards() = %+v, want %+v", guards, want)
+		}
+	})
+
+	t.Run("should return no guards for an empty list", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			if cmd == "GETINFO entry-guards" {
+				return "250-entry-guards=\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		guards, err := client.EntryGuards(context.Background())
+		if err != nil {
+			t.Fatalf("EntryGuards failed: %v", err)
+		}
+		if len(guards) != 0 {
+			t.Errorf("expected no guards, got %+v", guards)
+		}
+	})
+}
+
+func TestDropGuardsAndTimeouts(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(cmd, "AUTHENTICATE") {
+			commands = append(commands, cmd)
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := client.DropGuards(ctx); err != nil {
+		t.Fatalf("DropGuards failed: %v", err)
+	}
+	if err := client.DropTimeouts(ctx); err != nil {
+		t.Fatalf("DropTimeouts failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"DROPGUARDS", "DROPTIMEOUTS"}; !slices.Equal(commands, want) {
+		t.Errorf("unexpected commands: got %q, want %q", commands, want)
+	}
+}
+
+func TestTorLaunchConfigGuardOptions(t *testing.T) {
+	t.Run("should render vanguard args", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig(
+			WithTorVanguardsLite(VanguardsLiteEnabled),
+			WithTorHSLayer2Nodes(FingerprintSelector(testRelayFingerprintA), FingerprintSelector(testRelayFingerprintB)),
+			WithTorHSLayer3Nodes(CountrySelector("de")),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if cfg.VanguardsLite() != VanguardsLiteEnabled || len(cfg.HSLayer2Nodes()) != 2 || len(cfg.HSLayer3Nodes()) != 1 {
+			t.Errorf("unexpected accessors: %q %v %v", cfg.VanguardsLite(), cfg.HSLayer2Nodes(), cfg.HSLayer3Nodes())
+		}
+		want := []string{
+			"--VanguardsLiteEnabled", "1",
+			"--HSLayer2Nodes", "$" + testRelayFingerprintA + ",$" + testRelayFingerprintB,
+			"--HSLayer3Nodes", "{de}",
+		}
+		if got := guardArgs(cfg); !slices.Equal(got, want) {
+			t.Errorf("guardArgs() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should render nothing by default", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig()
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if args := guardArgs(cfg); len(args) != 0 {
+			t.Errorf("expected no args, got %q", args)
+		}
+	})
+
+	t.Run("should reject an unknown vanguards-lite mode", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorVanguardsLite("yes")); err == nil {
+			t.Fatal("expected error for invalid mode")
+		}
+	})
+
+	t.Run("should reject invalid layer selectors", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorHSLayer3Nodes(FingerprintSelector("nope"))); err == nil {
+			t.Fatal("expected error for invalid selector")
+		}
+	})
+
+	t.Run("should render path bias args", func(t *testing.T) {
+		pb := PathBiasConfig{
+			CircThreshold:  20,
+			NoticeRate:     0.7,
+			WarnRate:       0.5,
+			ExtremeRate:    0.3,
+			DropGuards:     true,
+			UseThreshold:   5,
+			ExtremeUseRate: 0.6,
+		}
+		cfg, err := NewTorLaunchConfig(WithTorPathBias(pb))
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if cfg.PathBias() != pb {
+			t.Errorf("PathBias() = %+v, want %+v", cfg.PathBias(), pb)
+		}
+		want := []string{
+			"--PathBiasCircThreshold", "20",
+			"--PathBiasNoticeRate", "0.7",
+			"--PathBiasWarnRate", "0.5",
+			"--PathBiasExtremeRate", "0.3",
+			"--PathBiasDropGuards", "1",
+			"--PathBiasUseThreshold", "5",
+			"--PathBiasExtremeUseRate", "0.6",
+		}
+		if got := guardArgs(cfg); !slices.Equal(got, want) {
+			t.Errorf("guardArgs() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should reject invalid path bias settings", func(t *testing.T) {
+		for name, pb := range map[string]PathBiasConfig{
+			"circ threshold below 5":   {CircThreshold: 4},
+			"negative scale threshold": {ScaleThreshold: -1},
+			"use threshold below 3":    {UseThreshold: 2},
+			"rate above 1":             {NoticeRate: 1.5},
+			"negative rate":            {ExtremeUseRate: -0.1},
+			"extreme above warn":       {WarnRate: 0.3, ExtremeRate: 0.5},
+			"warn above notice":        {NoticeRate: 0.4, WarnRate: 0.5},
+			"extreme use above notice": {NoticeUseRate: 0.5, ExtremeUseRate: 0.8},
+		} {
+			if _, err := NewTorLaunchConfig(WithTorPathBias(pb)); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+				t.Errorf("%s: error = %v, want ErrInvalidConfig", name, err)
+			}
+		}
+	})
+
+	t.Run("should reject layer nodes excluded with StrictNodes", func(t *testing.T) {
+		_, err := NewTorLaunchConfig(
+			WithTorExcludeNodes(true, CountrySelector("de")),
+			WithTorHSLayer2Nodes(CountrySelector("DE")),
+		)
+		if err == nil {
+			t.Fatal("expected error for conflicting selectors")
+		}
+		_, err = NewTorLaunchConfig(
+			WithTorExcludeNodes(false, CountrySelector("de")),
+			WithTorHSLayer2Nodes(CountrySelector("de")),
+		)
+		if err != nil {
+			t.Fatalf("expected non-strict exclusion to be accepted: %v", err)
+		}
+	})
+}
+
+func TestReadPathBiasStats(t *testing.T) {
+	t.Run("should parse the guard counters of the state file", func(t *testing.T) {
+		dir := t.TempDir()
+		state := "# Tor state file\n" +
+			"Guard in=default rsa_id=" + testRelayFingerprintA + " nickname=alpha sampled_on=2024-01-02T03:04:05 " +
+			"pb_use_attempts=4.000000 pb_use_successes=3.000000 pb_circ_attempts=20.000000 pb_circ_successes=15.000000 " +
+			"pb_successful_circuits_closed=14.000000 pb_collapsed_circuits=1.000000 pb_unusable_circuits=0.000000 pb_timeouts=2.000000\n" +
+			"Guard in=bridges rsa_id=" + strings.ToLower(testRelayFingerprintB) + " sampled_on=2024-01-02T03:04:05\n" +
+			"Guard in=default rsa_id=nope\n" +
+			"TorVersion Tor 0.4.8.10\n"
+		if err := os.WriteFile(filepath.Join(dir, "state"), []byte(state), 0o600); err != nil {
+			t.Fatalf("failed to write state: %v", err)
+		}
+		stats, err := ReadPathBiasStats(dir)
+		if err != nil {
+			t.Fatalf("ReadPathBiasStats failed: %v", err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("expected 2 guards, got %+v", stats)
+		}
+		want := GuardPathBias{
+			Fingerprint:              testRelayFingerprintA,
+			Nickname:                 "alpha",
+			Selection:                "default",
+			CircAttempts:             20,
+			CircSuccesses:            15,
+			SuccessfulCircuitsClosed: 14,
+			CollapsedCircuits:        1,
+			Timeouts:                 2,
+			UseAttempts:              4,
+			UseSuccesses:             3,
+		}
+		if stats[0] != want {
+			t.Errorf("stats[0] = %+v, want %+v", stats[0], want)
+		}
+		if stats[0].CircSuccessRate() != 0.75 || stats[0].UseSuccessRate() != 0.75 {
+			t.Errorf("unexpected rates %v %v", stats[0].CircSuccessRate(), stats[0].UseSuccessRate())
+		}
+		if stats[1].Fingerprint != testRelayFingerprintB || stats[1].Selection != "bridges" || stats[1].CircSuccessRate() != 1 {
+			t.Errorf("unexpected stats[1]: %+v", stats[1])
+		}
+	})
+
+	t.Run("should report a missing state file", func(t *testing.T) {
+		if _, err := ReadPathBiasStats(t.TempDir()); !errors.Is(err, &TornagoError{Kind: ErrIO}) {
+			t.Errorf("error = %v, want ErrIO", err)
+		}
+		if _, err := ReadPathBiasStats(""); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+			t.Errorf("error = %v, want ErrInvalidConfig", err)
+		}
+	})
+}
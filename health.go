@@ -181,6 +181,18 @@ func CheckTorDaemon(ctx context.Context, proc *TorProcess) HealthCheck {
 			latency:   time.Since(start),
 		}
 	}
+	if proc.Exited() {
+		msg := "Tor process exited"
+		if exitErr := proc.ExitErr(); exitErr != nil {
+			msg += ": " + exitErr.Error()
+		}
+		return HealthCheck{
+			status:    HealthStatusUnhealthy,
+			message:   msg,
+			timestamp: start,
+			latency:   time.Since(start),
+		}
+	}
 
 	// Try to get control auth
 	auth, _, err := ControlAuthFromTor(proc.ControlAddr(), 5*time.Second)
@@ -0,0 +1,159 @@
+package tornago
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpTunnelDialer opens connections with HTTP CONNECT through Tor's
+// HTTPTunnelPort.
+type httpTunnelDialer struct {
+	// addr is the HTTPTunnelPort endpoint.
+	addr string
+	// timeout bounds dial operations to the proxy.
+	timeout time.Duration
+	// header is added to every CONNECT request.
+	header http.Header
+}
+
+// DialContext establishes a CONNECT tunnel to address.
+func (d *httpTunnelDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, newError(ErrSocksDialFailed, opClient, "unsupported network "+network, nil)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, newError(ErrSocksDialFailed, opClient, "invalid destination address", err)
+	}
+
+	dialer := &net.Dialer{}
+	if d.timeout > 0 {
+		dialer.Timeout = d.timeout
+	}
+	proxyNet, proxyAddr := listenerNetwork(d.addr)
+	conn, err := dialer.DialContext(ctx, proxyNet, proxyAddr)
+	if err != nil {
+		return nil, newError(ErrSocksDialFailed, opClient, "failed to connect to HTTP tunnel proxy", err)
+	}
+
+	tunneled, err := d.handshake(ctx, conn, address)
+	if err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		return nil, err
+	}
+	return tunneled, nil
+}
+
+// handshake sends CONNECT for dest over conn and checks Tor's reply.
+func (d *httpTunnelDialer) handshake(ctx context.Context, conn net.Conn, dest string) (net.Conn, error) {
+	deadline := time.Time{}
+	if d.timeout > 0 {
+		deadline = time.Now().Add(d.timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, newError(ErrSocksDialFailed, opClient, "failed to set HTTP tunnel deadline", err)
+	}
+	// Unblock the handshake when ctx is canceled.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0)) //nolint:errcheck // the handshake reports the failure
+	})
+	defer stop()
+
+	header := d.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if _, ok := header["User-Agent"]; !ok {
+		// An empty User-Agent keeps net/http from sending its default.
+		header["User-Agent"] = []string{""}
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: dest},
+		Host:   dest,
+		Header: header,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, httpTunnelIOError(ctx, "failed to send CONNECT", err)
+	}
+	br := bufio.NewReader(conn)
+	// The body is not read: a successful CONNECT reply has none and the
+	// connection is closed on failure.
+	resp, err := http.ReadResponse(br, req) //nolint:bodyclose // see above
+	if err != nil {
+		return nil, httpTunnelIOError(ctx, "failed to read CONNECT response", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpTunnelStatusError(resp)
+	}
+	if !stop() {
+		return nil, newError(ErrSocksDialFailed, opClient, "HTTP tunnel canceled", ctx.Err())
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, newError(ErrSocksDialFailed, opClient, "failed to clear HTTP tunnel deadline", err)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// httpTunnelIOError reports a failed CONNECT exchange, preferring the
+// context error when ctx ended. The connection deadline can fire just before
+// ctx reports its own, so a passed ctx deadline counts as ended.
+func httpTunnelIOError(ctx context.Context, msg string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = errors.Join(ctxErr, err)
+	} else if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		err = errors.Join(context.DeadlineExceeded, err)
+	}
+	return newError(ErrSocksDialFailed, opClient, msg, err)
+}
+
+// httpTunnelStatusError maps a failed CONNECT reply to an error. Tor puts the
+// stream end reason in the status text, e.g. "404 Not Found (resolve failed)"
+// or "403 Forbidden (exit policy)"; a 504 means the exit timed out.
+func httpTunnelStatusError(resp *http.Response) error {
+	kind := ErrSocksDialFailed
+	if resp.StatusCode == http.StatusGatewayTimeout {
+		kind = ErrTimeout
+	}
+	return newError(kind, opClient, "HTTP tunnel CONNECT failed: "+resp.Status, nil)
+}
+
+// bufferedConn returns data read ahead while parsing the CONNECT reply
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	// r buffers the connection.
+	r *bufio.Reader
+}
+
+// Read reads from the buffer first.
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// validateHTTPTunnel checks the HTTPTunnelPort launch option.
+func validateHTTPTunnel(cfg TorLaunchConfig) error {
+	if cfg.httpTunnelAddr == "" {
+		return nil
+	}
+	if cfg.torConfigFile != "" {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			"WithTorHTTPTunnelAddr cannot be combined with WithTorConfigFile. Declare the HTTPTunnelPort in the torrc file instead", nil)
+	}
+	if _, _, err := listenArg(cfg.httpTunnelAddr); err != nil {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig", "invalid HTTPTunnelAddr", err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package tornago
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHTTPTunnel is a stand-in for Tor's HTTPTunnelPort. Successful CONNECTs
+// are forwarded to target, whatever the requested destination.
+type fakeHTTPTunnel struct {
+	listener net.Listener
+	// reply is the status line sent for every CONNECT.
+	reply string
+	// target receives tunneled connections.
+	target string
+	// greeting is written right after a successful reply.
+	greeting string
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func startFakeHTTPTunnel(t *testing.T, reply, target string) *fakeHTTPTunnel {
+	t.Helper()
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	p := &fakeHTTPTunnel{listener: listener, reply: reply, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *fakeHTTPTunnel) serve(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+	if p.reply == "" {
+		// Never answer, like a stalled exit.
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "HTTP/1.0 %s\r\n\r\n%s", p.reply, p.greeting); err != nil {
+		return
+	}
+	if !strings.HasPrefix(p.reply, "200") || p.target == "" {
+		return
+	}
+	dialer := net.Dialer{}
+	upstream, err := dialer.DialContext(context.Background(), "tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func (p *fakeHTTPTunnel) Addr() string { return p.listener.Addr().String() }
+
+func (p *fakeHTTPTunnel) lastRequest() *http.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requests) == 0 {
+		return nil
+	}
+	return p.requests[len(p.requests)-1]
+}
+
+func TestHTTPTunnelDialer(t *testing.T) {
+	t.Run("should send CONNECT and keep data read ahead", func(t *testing.T) {
+		proxy := startFakeHTTPTunnel(t, "200 OK", "")
+		proxy.greeting = "hello"
+		d := &httpTunnelDialer{addr: proxy.Addr(), timeout: 5 * time.Second}
+
+		conn, err := d.DialContext(context.Background(), "tcp", "example.onion:80")
+		if err != nil {
+			t.Fatalf("DialContext failed: %v", err)
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("read %q, %v; want data sent after the reply", buf, err)
+		}
+		req := proxy.lastRequest()
+		if req.Method != http.MethodConnect || req.Host != "example.onion:80" {
+			t.Errorf("proxy got %s %s, want CONNECT example.onion:80", req.Method, req.Host)
+		}
+		if ua := req.Header.Get("User-Agent"); ua != "" {
+			t.Errorf("CONNECT sent User-Agent %q", ua)
+		}
+	})
+
+	t.Run("should report Tor's reason for failed CONNECTs", func(t *testing.T) {
+		cases := []struct {
+			reply string
+			kind  ErrorKind
+		}{
+			{"404 Not Found (resolve failed)", ErrSocksDialFailed},
+			{"403 Forbidden (exit policy)", ErrSocksDialFailed},
+			{"504 Gateway Timeout", ErrTimeout},
+		}
+		for _, tc := range cases {
+			proxy := startFakeHTTPTunnel(t, tc.reply, "")
+			d := &httpTunnelDialer{addr: proxy.Addr(), timeout: 5 * time.Second}
+			_, err := d.DialContext(context.Background(), "tcp", "example.com:443")
+			if !errors.Is(err, &TornagoError{Kind: tc.kind}) || !strings.Contains(err.Error(), tc.reply) {
+				t.Errorf("reply %q: error = %v, want %s with the reason", tc.reply, err, tc.kind)
+			}
+		}
+	})
+
+	t.Run("should stop waiting when the context ends", func(t *testing.T) {
+		proxy := startFakeHTTPTunnel(t, "", "")
+		d := &httpTunnelDialer{addr: proxy.Addr(), timeout: time.Minute}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := d.DialContext(ctx, "tcp", "example.com:443")
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error = %v, want deadline exceeded", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("dial took %v after the context ended", time.Since(start))
+		}
+	})
+
+	t.Run("should reject non-TCP networks", func(t *testing.T) {
+		d := &httpTunnelDialer{addr: "127.0.0.1:1"}
+		if _, err := d.DialContext(context.Background(), "udp", "example.com:53"); !errors.Is(err, &TornagoError{Kind: ErrSocksDialFailed}) {
+			t.Errorf("error = %v, want ErrSocksDialFailed", err)
+		}
+	})
+}
+
+func TestClientHTTPTunnel(t *testing.T) {
+	t.Run("should send requests through the HTTPTunnelPort", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "via tunnel "+r.Host)
+		}))
+		defer server.Close()
+		proxy := startFakeHTTPTunnel(t, "200 OK", strings.TrimPrefix(server.URL, "http://"))
+
+		cfg, err := NewClientConfig(WithClientHTTPTunnelAddr(proxy.Addr()), WithRetryAttempts(1))
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com/", http.NoBody)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "via tunnel example.com" {
+			t.Errorf("body = %q", body)
+		}
+		if got := proxy.lastRequest().Host; got != "example.com:80" {
+			t.Errorf("CONNECT target = %q, want example.com:80", got)
+		}
+	})
+}
+
+func TestHTTPTunnelProxyHeader(t *testing.T) {
+	t.Run("should send proxy headers with every CONNECT", func(t *testing.T) {
+		proxy := startFakeHTTPTunnel(t, "200 OK", "")
+		cfg, err := NewClientConfig(
+			WithClientHTTPTunnelAddr(proxy.Addr()),
+			WithClientProxyHeader("X-Tor-Stream-Isolation", "job-1"),
+			WithClientProxyHeader("Proxy-Authorization", "Basic dTpw"),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+
+		conn, err := client.Dial("tcp", "example.onion:80")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		_ = conn.Close()
+		req := proxy.lastRequest()
+		if req.Header.Get("X-Tor-Stream-Isolation") != "job-1" || req.Header.Get("Proxy-Authorization") != "Basic dTpw" {
+			t.Errorf("CONNECT headers = %v", req.Header)
+		}
+		if ua := req.Header.Get("User-Agent"); ua != "" {
+			t.Errorf("CONNECT sent User-Agent %q", ua)
+		}
+	})
+}
+
+func TestValidateHTTPTunnel(t *testing.T) {
+	t.Run("should reject invalid HTTPTunnelPort settings", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorHTTPTunnelAddr("not an address")); err == nil {
+			t.Error("expected error for invalid address")
+		}
+		if _, err := NewTorLaunchConfig(WithTorHTTPTunnelAddr(":0"), WithTorConfigFile("/etc/tor/torrc")); err == nil {
+			t.Error("expected error when combined with WithTorConfigFile")
+		}
+		cfg, err := NewTorLaunchConfig(WithTorHTTPTunnelAddr("127.0.0.1:0"))
+		if err != nil || cfg.HTTPTunnelAddr() != "127.0.0.1:0" {
+			t.Errorf("HTTPTunnelAddr = %q, %v", cfg.HTTPTunnelAddr(), err)
+		}
+	})
+}
+
+func TestStartTorDaemonHTTPTunnel(t *testing.T) {
+	t.Run("should discover the HTTPTunnelPort tor picked", func(t *testing.T) {
+		socksAddr := startMockControlServer(t, func(string) string { return "" })
+		tunnelAddr := startMockControlServer(t, func(string) string { return "" })
+		controlAddr := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO net/listeners/socks":
+				return "250-net/listeners/socks=\"" + socksAddr + "\"\r\n250 OK\r\n"
+			case "GETINFO net/listeners/httptunnel":
+				return "250-net/listeners/httptunnel=\"" + tunnelAddr + "\"\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		cfg, err := NewTorLaunchConfig(
+			WithTorBinary(writePortFileTor(t, controlAddr)),
+			WithTorHTTPTunnelAddr(":0"),
+			WithTorStartupTimeout(10*time.Second),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		proc, err := StartTorDaemon(cfg)
+		if err != nil {
+			t.Fatalf("StartTorDaemon failed: %v", err)
+		}
+		defer proc.Stop()
+		if proc.HTTPTunnelAddr() != tunnelAddr {
+			t.Errorf("HTTPTunnelAddr = %q, want %q", proc.HTTPTunnelAddr(), tunnelAddr)
+		}
+	})
+}
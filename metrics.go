@@ -25,6 +25,10 @@ type Metrics interface {
 
 // MetricsCollector tracks request statistics for the Client.
 // It is thread-safe and can be shared across goroutines.
+//
+// Client.Do counts each call as one request, including the redirects it
+// follows. Requests sent through Client.HTTP or a Transport are counted per
+// round trip, so each followed redirect counts as another request.
 type MetricsCollector struct {
 	requestCount uint64
 	successCount uint64
@@ -40,12 +44,27 @@ type MetricsCollector struct {
 
 	// Connection reuse metrics
 	dialCount uint64 // Total number of dial operations
+
+	// Circuit management metrics
+	rotationCount    uint64
+	rotationFailures uint64
+	lastRotation     int64 // unix nanoseconds
+	circuitsBuilt    uint64
+	circuitsFailed   uint64
+	timedBuilds      uint64
+	totalBuildTime   int64 // nanoseconds
+
+	// Request outcome observers
+	observers      map[uint64]func(RequestOutcome)
+	nextObserverID uint64
+	observersMu    sync.RWMutex
 }
 
 // NewMetricsCollector creates a new MetricsCollector.
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
 		errorsByKind: make(map[ErrorKind]uint64),
+		observers:    make(map[uint64]func(RequestOutcome)),
 	}
 }
 
@@ -137,6 +156,47 @@ func (m *MetricsCollector) ConnectionReuseRate() float64 {
 	return float64(reused) / float64(requests)
 }
 
+// RotationCount returns the number of successful circuit rotations reported
+// by a CircuitManager using this collector.
+func (m *MetricsCollector) RotationCount() uint64 {
+	return atomic.LoadUint64(&m.rotationCount)
+}
+
+// RotationFailureCount returns the number of circuit rotations that failed.
+func (m *MetricsCollector) RotationFailureCount() uint64 {
+	return atomic.LoadUint64(&m.rotationFailures)
+}
+
+// LastRotation returns when the last rotation was attempted, or the zero
+// time if none was reported.
+func (m *MetricsCollector) LastRotation() time.Time {
+	ns := atomic.LoadInt64(&m.lastRotation)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// CircuitBuildCount returns the number of circuits observed reaching BUILT.
+func (m *MetricsCollector) CircuitBuildCount() uint64 {
+	return atomic.LoadUint64(&m.circuitsBuilt)
+}
+
+// CircuitFailureCount returns the number of circuits observed FAILED.
+func (m *MetricsCollector) CircuitFailureCount() uint64 {
+	return atomic.LoadUint64(&m.circuitsFailed)
+}
+
+// AverageCircuitBuildTime returns the average build time of circuits
+// observed reaching BUILT. Returns 0 if none were observed.
+func (m *MetricsCollector) AverageCircuitBuildTime() time.Duration {
+	count := atomic.LoadUint64(&m.timedBuilds)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&m.totalBuildTime)) / time.Duration(count) //nolint:gosec // count is guaranteed > 0 and overflow is acceptable for metrics
+}
+
 // Reset clears all metrics to zero.
 func (m *MetricsCollector) Reset() {
 	m.mu.Lock()
@@ -148,12 +208,81 @@ func (m *MetricsCollector) Reset() {
 	atomic.StoreInt64(&m.minLatency, 0)
 	atomic.StoreInt64(&m.maxLatency, 0)
 	atomic.StoreUint64(&m.dialCount, 0)
+	atomic.StoreUint64(&m.rotationCount, 0)
+	atomic.StoreUint64(&m.rotationFailures, 0)
+	atomic.StoreInt64(&m.lastRotation, 0)
+	atomic.StoreUint64(&m.circuitsBuilt, 0)
+	atomic.StoreUint64(&m.circuitsFailed, 0)
+	atomic.StoreUint64(&m.timedBuilds, 0)
+	atomic.StoreInt64(&m.totalBuildTime, 0)
 
 	m.errorsMu.Lock()
 	m.errorsByKind = make(map[ErrorKind]uint64)
 	m.errorsMu.Unlock()
 }
 
+// OnRequest registers fn to be called with the outcome of every request
+// recorded by a Client using this collector. fn runs on the request's
+// goroutine and must be safe for concurrent use. The returned function
+// removes the observer.
+func (m *MetricsCollector) OnRequest(fn func(RequestOutcome)) (remove func()) {
+	if fn == nil {
+		return func() {}
+	}
+	m.observersMu.Lock()
+	defer m.observersMu.Unlock()
+	if m.observers == nil {
+		m.observers = make(map[uint64]func(RequestOutcome))
+	}
+	id := m.nextObserverID
+	m.nextObserverID++
+	m.observers[id] = fn
+	return func() {
+		m.observersMu.Lock()
+		defer m.observersMu.Unlock()
+		delete(m.observers, id)
+	}
+}
+
+// recordOutcome passes a request outcome to the registered observers.
+func (m *MetricsCollector) recordOutcome(outcome RequestOutcome) {
+	m.observersMu.RLock()
+	observers := make([]func(RequestOutcome), 0, len(m.observers))
+	for _, fn := range m.observers {
+		observers = append(observers, fn)
+	}
+	m.observersMu.RUnlock()
+	for _, fn := range observers {
+		fn(outcome)
+	}
+}
+
+// recordRotation records the result of a circuit rotation attempted at at.
+func (m *MetricsCollector) recordRotation(at time.Time, err error) {
+	atomic.StoreInt64(&m.lastRotation, at.UnixNano())
+	if err != nil {
+		atomic.AddUint64(&m.rotationFailures, 1)
+		return
+	}
+	atomic.AddUint64(&m.rotationCount, 1)
+}
+
+// recordCircuitBuilt records a circuit that reached BUILT after buildTime.
+// A negative buildTime means the build time is unknown.
+func (m *MetricsCollector) recordCircuitBuilt(buildTime time.Duration) {
+	atomic.AddUint64(&m.circuitsBuilt, 1)
+	if buildTime < 0 {
+		return
+	}
+	atomic.AddUint64(&m.timedBuilds, 1)
+	atomic.AddInt64(&m.totalBuildTime, int64(buildTime))
+}
+
+// recordCircuitFailed records a circuit that FAILED.
+func (m *MetricsCollector) recordCircuitFailed() {
+	atomic.AddUint64(&m.circuitsFailed, 1)
+}
+
 // recordDial increments the dial count when a new connection is established.
 func (m *MetricsCollector) recordDial() {
 	atomic.AddUint64(&m.dialCount, 1)
@@ -196,3 +196,29 @@ func TestMetricsCollector_DialCount(t *testing.T) {
 		t.Errorf("DialCount() = %d, want 3", m.DialCount())
 	}
 }
+
+func TestMetricsCollector_CircuitMetrics(t *testing.T) {
+	m := NewMetricsCollector()
+	if !m.LastRotation().IsZero() || m.AverageCircuitBuildTime() != 0 {
+		t.Fatal("expected empty circuit metrics")
+	}
+	now := time.Now()
+	m.recordRotation(now, nil)
+	m.recordRotation(now, errors.New("failed"))
+	m.recordCircuitBuilt(100 * time.Millisecond)
+	m.recordCircuitBuilt(300 * time.Millisecond)
+	m.recordCircuitBuilt(-1)
+	m.recordCircuitFailed()
+
+	if m.RotationCount() != 1 || m.RotationFailureCount() != 1 || !m.LastRotation().Equal(time.Unix(0, now.UnixNano())) {
+		t.Errorf("unexpected rotation metrics: %d %d %v", m.RotationCount(), m.RotationFailureCount(), m.LastRotation())
+	}
+	if m.CircuitBuildCount() != 3 || m.CircuitFailureCount() != 1 || m.AverageCircuitBuildTime() != 200*time.Millisecond {
+		t.Errorf("unexpected circuit metrics: %d %d %v", m.CircuitBuildCount(), m.CircuitFailureCount(), m.AverageCircuitBuildTime())
+	}
+
+	m.Reset()
+	if m.RotationCount() != 0 || m.CircuitBuildCount() != 0 || !m.LastRotation().IsZero() {
+		t.Error("expected Reset to clear circuit metrics")
+	}
+}
@@ -0,0 +1,222 @@
+package tornago
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// nodeSelectorKind identifies how a NodeSelector matches relays.
+type nodeSelectorKind int
+
+const (
+	// nodeSelectorCountry matches relays by GeoIP country code ("{de}").
+	nodeSelectorCountry nodeSelectorKind = iota + 1
+	// nodeSelectorFingerprint matches a single relay by identity ("$ABCD...").
+	nodeSelectorFingerprint
+	// nodeSelectorAddress matches relays by IP address or CIDR range.
+	nodeSelectorAddress
+)
+
+// NodeSelector identifies relays in Tor node lists such as ExitNodes,
+// EntryNodes and ExcludeNodes. Use CountrySelector, FingerprintSelector,
+// AddressSelector or ParseNodeSelector to build one; selectors are validated
+// when they are applied.
+//
+// Example:
+//
+//	err := ctrl.SetExitNodes(ctx,
+//	    tornago.CountrySelector("de"),
+//	    tornago.CountrySelector("nl"),
+//	)
+type NodeSelector struct {
+	// kind is how value should be interpreted.
+	kind nodeSelectorKind
+	// value is the normalized selector without torrc decoration.
+	value string
+}
+
+// CountrySelector selects relays located in the given ISO 3166 country code
+// (e.g. "de"). Use "??" to select relays whose country is unknown.
+func CountrySelector(code string) NodeSelector {
+	return NodeSelector{kind: nodeSelectorCountry, value: strings.ToLower(strings.Trim(strings.TrimSpace(code), "{}"))}
+}
+
+// FingerprintSelector selects a single relay by its identity fingerprint.
+// A leading "$" and a "~nickname" suffix are accepted.
+func FingerprintSelector(fingerprint string) NodeSelector {
+	value := strings.TrimSpace(fingerprint)
+	if fp, err := normalizeFingerprint(value); err == nil {
+		value = fp
+	}
+	return NodeSelector{kind: nodeSelectorFingerprint, value: value}
+}
+
+// AddressSelector selects relays by IP address or CIDR range
+// (e.g. "192.0.2.0/24").
+func AddressSelector(cidr string) NodeSelector {
+	return NodeSelector{kind: nodeSelectorAddress, value: strings.TrimSpace(cidr)}
+}
+
+// ParseNodeSelector parses a single entry in torrc node-list syntax:
+// "{cc}" for countries, "$FINGERPRINT" (or a bare 40-digit fingerprint) for
+// relays, and an IP address or CIDR range for addresses.
+func ParseNodeSelector(s string) (NodeSelector, error) {
+	s = strings.TrimSpace(s)
+	var sel NodeSelector
+	switch {
+	case strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}"):
+		sel = CountrySelector(s)
+	case strings.HasPrefix(s, "$"):
+		sel = FingerprintSelector(s)
+	case strings.ContainsAny(s, ".:/"):
+		sel = AddressSelector(s)
+	default:
+		sel = FingerprintSelector(s)
+	}
+	if err := sel.validate(); err != nil {
+		return NodeSelector{}, err
+	}
+	return sel, nil
+}
+
+// ParseNodeSelectors parses a comma-separated torrc node list such as
+// "{de},{nl},$ABCD...".
+func ParseNodeSelectors(list string) ([]NodeSelector, error) {
+	var selectors []NodeSelector
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		sel, err := ParseNodeSelector(item)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// String renders the selector in torrc syntax (e.g. "{de}", "$ABCD...").
+func (s NodeSelector) String() string {
+	switch s.kind {
+	case nodeSelectorCountry:
+		return "{" + s.value + "}"
+	case nodeSelectorFingerprint:
+		return "$" + s.value
+	default:
+		return s.value
+	}
+}
+
+// validate ensures the selector is well formed for its kind.
+func (s NodeSelector) validate() error {
+	switch s.kind {
+	case nodeSelectorCountry:
+		if s.value == "??" {
+			return nil
+		}
+		if len(s.value) != 2 || s.value[0] < 'a' || s.value[0] > 'z' || s.value[1] < 'a' || s.value[1] > 'z' {
+			return newError(ErrInvalidConfig, "NodeSelector", fmt.Sprintf("invalid country code %q, expected two letters like \"de\"", s.value), nil)
+		}
+	case nodeSelectorFingerprint:
+		if _, err := normalizeFingerprint(s.value); err != nil {
+			return err
+		}
+	case nodeSelectorAddress:
+		if net.ParseIP(s.value) != nil {
+			return nil
+		}
+		if _, _, err := net.ParseCIDR(s.value); err != nil {
+			return newError(ErrInvalidConfig, "NodeSelector", fmt.Sprintf("invalid address or CIDR range %q", s.value), err)
+		}
+	default:
+		return newError(ErrInvalidConfig, "NodeSelector", "empty node selector", nil)
+	}
+	return nil
+}
+
+// formatNodeList validates selectors and joins them into a torrc node list.
+func formatNodeList(nodes []NodeSelector) (string, error) {
+	parts := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if err := n.validate(); err != nil {
+			return "", err
+		}
+		parts = append(parts, n.String())
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// SetExitNodes restricts the relays Tor may use as the last hop of circuits.
+// Calling it without selectors clears the restriction. Existing circuits are
+// not affected; call NewIdentity afterwards to pick up the new exits.
+//
+// Example:
+//
+//	_ = ctrl.SetExitNodes(ctx, tornago.CountrySelector("de"))
+//	_ = ctrl.NewIdentity(ctx)
+func (c *ControlClient) SetExitNodes(ctx context.Context, nodes ...NodeSelector) error {
+	return c.setNodeList(ctx, "ExitNodes", nodes)
+}
+
+// SetEntryNodes restricts the relays Tor may use as the first hop of circuits.
+// Calling it without selectors clears the restriction.
+func (c *ControlClient) SetEntryNodes(ctx context.Context, nodes ...NodeSelector) error {
+	return c.setNodeList(ctx, "EntryNodes", nodes)
+}
+
+// SetExcludeNodes forbids Tor from using the given relays in any circuit and
+// sets StrictNodes in the same SETCONF so Tor never observes a mixed state.
+// With strict set, Tor refuses to use excluded relays even when that breaks
+// functionality (e.g. reaching onion services hosted behind them).
+func (c *ControlClient) SetExcludeNodes(ctx context.Context, strict bool, nodes ...NodeSelector) error {
+	return c.setNodeList(ctx, "ExcludeNodes", nodes, ConfEntry{Key: "StrictNodes", Value: boolSetting(strict)})
+}
+
+// setNodeList applies a node-list option plus any companion options as one
+// atomic SETCONF command. An empty node list resets the option.
+func (c *ControlClient) setNodeList(ctx context.Context, key string, nodes []NodeSelector, extra ...ConfEntry) error {
+	list, err := formatNodeList(nodes)
+	if err != nil {
+		return err
+	}
+	entries := append([]ConfEntry{{Key: key, Value: list}}, extra...)
+	return c.SetConfMany(ctx, entries)
+}
+
+// boolSetting renders a boolean torrc value.
+func boolSetting(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// nodeSelectionArgs renders the node selection options of cfg as tor CLI args.
+func nodeSelectionArgs(cfg TorLaunchConfig) []string {
+	var args []string
+	add := func(key string, nodes []NodeSelector) {
+		if list, err := formatNodeList(nodes); err == nil && list != "" {
+			args = append(args, "--"+key, list)
+		}
+	}
+	add("EntryNodes", cfg.entryNodes)
+	add("ExitNodes", cfg.exitNodes)
+	add("ExcludeNodes", cfg.excludeNodes)
+	if cfg.strictNodes {
+		args = append(args, "--StrictNodes", "1")
+	}
+	return args
+}
+
+// validateNodeSelection ensures the node selection options of cfg are valid.
+func validateNodeSelection(cfg TorLaunchConfig) error {
+	for _, nodes := range [][]NodeSelector{cfg.entryNodes, cfg.exitNodes, cfg.excludeNodes} {
+		if _, err := formatNodeList(nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
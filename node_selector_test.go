@@ -0,0 +1,162 @@
+package tornago
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseNodeSelector(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"{DE}", "{de}", false},
+		{"{??}", "{??}", false},
+		{"$" + testRelayFingerprintA, "$" + testRelayFingerprintA, false},
+		{strings.ToLower(testRelayFingerprintA), "$" + testRelayFingerprintA, false},
+		{"192.0.2.0/24", "192.0.2.0/24", false},
+		{"2001:db8::1", "2001:db8::1", false},
+		{"{deu}", "", true},
+		{"{1a}", "", true},
+		{"$ABC", "", true},
+		{"192.0.2.0/99", "", true},
+		{"nickname", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			sel, err := ParseNodeSelector(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNodeSelector(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err == nil && sel.String() != tt.want {
+				t.Errorf("ParseNodeSelector(%q) = %q, want %q", tt.input, sel.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNodeSelectors(t *testing.T) {
+	t.Run("should parse a torrc node list", func(t *testing.T) {
+		sels, err := ParseNodeSelectors("{de}, {nl},,$" + testRelayFingerprintB)
+		if err != nil {
+			t.Fatalf("ParseNodeSelectors failed: %v", err)
+		}
+		got, err := formatNodeList(sels)
+		if err != nil {
+			t.Fatalf("formatNodeList failed: %v", err)
+		}
+		if want := "{de},{nl},$" + testRelayFingerprintB; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should reject invalid entries", func(t *testing.T) {
+		if _, err := ParseNodeSelectors("{de},{germany}"); err == nil {
+			t.Error("expected error for invalid country")
+		}
+	})
+}
+
+func TestFormatNodeList(t *testing.T) {
+	t.Run("should reject zero value selector", func(t *testing.T) {
+		if _, err := formatNodeList([]NodeSelector{{}}); err == nil {
+			t.Error("expected error for zero value selector")
+		}
+	})
+
+	t.Run("should reject invalid address", func(t *testing.T) {
+		if _, err := formatNodeList([]NodeSelector{AddressSelector("not-an-ip")}); err == nil {
+			t.Error("expected error for invalid address")
+		}
+	})
+}
+
+func TestSetNodeLists(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(cmd, "AUTHENTICATE") {
+			commands = append(commands, cmd)
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := client.SetExitNodes(ctx, CountrySelector("de"), CountrySelector("NL")); err != nil {
+		t.Fatalf("SetExitNodes failed: %v", err)
+	}
+	if err := client.SetEntryNodes(ctx); err != nil {
+		t.Fatalf("SetEntryNodes failed: %v", err)
+	}
+	if err := client.SetExcludeNodes(ctx, true, AddressSelector("192.0.2.0/24"), FingerprintSelector(testRelayFingerprintA)); err != nil {
+		t.Fatalf("SetExcludeNodes failed: %v", err)
+	}
+	if err := client.SetExitNodes(ctx, CountrySelector("xyz")); err == nil {
+		t.Error("expected validation error for invalid country")
+	}
+
+	want := []string{
+		`SETCONF ExitNodes="{de},{nl}"`,
+		`SETCONF EntryNodes`,
+		`SETCONF ExcludeNodes="192.0.2.0/24,$` + testRelayFingerprintA + `" StrictNodes="1"`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(commands, want) {
+		t.Errorf("unexpected commands:\n got %q\nwant %q", commands, want)
+	}
+}
+
+func TestTorLaunchConfigNodeSelection(t *testing.T) {
+	t.Run("should render node selection args", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig(
+			WithTorEntryNodes(FingerprintSelector(testRelayFingerprintB)),
+			WithTorExitNodes(CountrySelector("de"), CountrySelector("nl")),
+			WithTorExcludeNodes(true, CountrySelector("??")),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if len(cfg.ExitNodes()) != 2 || len(cfg.EntryNodes()) != 1 || len(cfg.ExcludeNodes()) != 1 || !cfg.StrictNodes() {
+			t.Errorf("unexpected accessors: %v %v %v %v", cfg.EntryNodes(), cfg.ExitNodes(), cfg.ExcludeNodes(), cfg.StrictNodes())
+		}
+		want := []string{
+			"--EntryNodes", "$" + testRelayFingerprintB,
+			"--ExitNodes", "{de},{nl}",
+			"--ExcludeNodes", "{??}",
+			"--StrictNodes", "1",
+		}
+		if got := nodeSelectionArgs(cfg); !slices.Equal(got, want) {
+			t.Errorf("nodeSelectionArgs() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should reject invalid selectors", func(t *testing.T) {
+		_, err := NewTorLaunchConfig(WithTorExitNodes(CountrySelector("germany")))
+		if err == nil {
+			t.Fatal("expected error for invalid selector")
+		}
+	})
+
+	t.Run("should render nothing without node selection", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig()
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		if args := nodeSelectionArgs(cfg); len(args) != 0 {
+			t.Errorf("expected no args, got %q", args)
+		}
+	})
+}
@@ -0,0 +1,581 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// opTorPool labels errors originating from TorPool operations.
+	opTorPool = "TorPool"
+	// defaultPoolHealthInterval is how often instances are health-checked.
+	defaultPoolHealthInterval = 30 * time.Second
+	// defaultPoolMaxFailures is how many failed checks in a row replace an instance.
+	defaultPoolMaxFailures = 3
+)
+
+// PoolStrategy selects the tor instance for each new connection of a TorPool.
+type PoolStrategy string
+
+const (
+	// PoolRoundRobin cycles through the healthy instances.
+	PoolRoundRobin PoolStrategy = "round_robin"
+	// PoolLeastConnections picks the instance with the fewest open connections.
+	PoolLeastConnections PoolStrategy = "least_connections"
+	// PoolStickyDestination sends every connection to the same host through
+	// the same instance while it stays healthy, so a site sees one exit.
+	PoolStickyDestination PoolStrategy = "sticky_destination"
+)
+
+// PoolOption customizes a TorPool.
+type PoolOption func(*poolOptions)
+
+// poolOptions holds TorPool settings.
+type poolOptions struct {
+	// strategy selects instances for new connections.
+	strategy PoolStrategy
+	// healthInterval is the delay between health checks.
+	healthInterval time.Duration
+	// maxFailures is how many failed checks in a row replace an instance.
+	maxFailures int
+}
+
+// WithPoolStrategy sets how connections are spread across instances.
+// The default is PoolRoundRobin.
+func WithPoolStrategy(strategy PoolStrategy) PoolOption {
+	return func(o *poolOptions) {
+		o.strategy = strategy
+	}
+}
+
+// WithPoolHealthInterval sets how often each instance is checked with
+// CheckTorDaemon. The default is 30 seconds.
+func WithPoolHealthInterval(interval time.Duration) PoolOption {
+	return func(o *poolOptions) {
+		o.healthInterval = interval
+	}
+}
+
+// WithPoolMaxFailures replaces an instance after n failed health checks in a
+// row. Instances that exit or report unhealthy are replaced immediately.
+// The default is 3.
+func WithPoolMaxFailures(n int) PoolOption {
+	return func(o *poolOptions) {
+		o.maxFailures = n
+	}
+}
+
+// PoolInstance describes one tor instance of a TorPool.
+type PoolInstance struct {
+	// Index identifies the instance slot; it is kept across replacements.
+	Index int
+	// Process is the running tor, or nil while the instance is being replaced.
+	Process *TorProcess
+	// Healthy reports whether the last health check passed.
+	Healthy bool
+	// ActiveConns is the number of open connections through the instance.
+	ActiveConns int
+	// Replacements counts how often the instance was replaced.
+	Replacements int
+}
+
+// poolSlot is one instance position of a TorPool.
+type poolSlot struct {
+	// index identifies the slot.
+	index int
+	// cfg launches the slot's tor with its own ports and DataDirectory.
+	cfg TorLaunchConfig
+	// active counts open connections.
+	active atomic.Int64
+	// The fields below are protected by TorPool.mu.
+	// proc is the running tor, nil while being replaced.
+	proc *TorProcess
+	// healthy reports whether the slot accepts new connections.
+	healthy bool
+	// failures counts failed health checks in a row.
+	failures int
+	// replacements counts replacements of the slot's tor.
+	replacements int
+}
+
+// poolExit reports that proc of slot exited.
+type poolExit struct {
+	// slot is the slot proc belonged to.
+	slot *poolSlot
+	// proc is the exited process.
+	proc *TorProcess
+}
+
+// TorPool runs several Tor daemons, each with its own ports and
+// DataDirectory, and spreads connections across them. Instances are checked
+// with CheckTorDaemon and replaced when they exit or stay unhealthy.
+//
+// Example:
+//
+//	launchCfg, _ := tornago.NewTorLaunchConfig()
+//	pool, _ := tornago.NewTorPool(4, launchCfg,
+//	    tornago.WithPoolStrategy(tornago.PoolLeastConnections))
+//	if err := pool.Start(ctx); err != nil {
+//	    return err
+//	}
+//	defer pool.Stop()
+//	client, _ := pool.NewClient(tornago.WithClientRequestTimeout(time.Minute))
+//	resp, err := client.HTTP().Get("https://example.com")
+type TorPool struct {
+	// opts holds pool settings.
+	opts poolOptions
+	// slots are the instance positions.
+	slots []*poolSlot
+	// logger receives pool events.
+	logger Logger
+	// launch starts tor; StartTorDaemonContext outside of tests.
+	launch func(context.Context, TorLaunchConfig) (*TorProcess, error)
+	// check reports the health of a tor; CheckTorDaemon outside of tests.
+	check func(context.Context, *TorProcess) HealthCheck
+	// exited receives instances whose process exited.
+	exited chan poolExit
+	// stopCh is closed by Stop.
+	stopCh chan struct{}
+	// done is closed when the maintenance loop exits.
+	done chan struct{}
+	// next is the round-robin cursor.
+	next atomic.Uint64
+	// mu protects the slot fields and the fields below.
+	mu sync.Mutex
+	// started reports whether Start was called.
+	started bool
+	// stopped reports whether Stop was called or the Start context ended.
+	stopped bool
+	// stopErr collects errors from stopping the instances.
+	stopErr error
+}
+
+// NewTorPool returns a pool of size Tor daemons launched from cfg. Each
+// instance listens on its own ports, chosen by Tor, and uses its own
+// DataDirectory: a temporary one, or a "tor-N" subdirectory when cfg sets a
+// DataDir. Call Start to launch them.
+func NewTorPool(size int, cfg TorLaunchConfig, opts ...PoolOption) (*TorPool, error) {
+	cfg, err := normalizeTorLaunchConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	o := poolOptions{
+		strategy:       PoolRoundRobin,
+		healthInterval: defaultPoolHealthInterval,
+		maxFailures:    defaultPoolMaxFailures,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	switch {
+	case size < 1:
+		return nil, newError(ErrInvalidConfig, opTorPool, fmt.Sprintf("pool size must be at least 1, got %d", size), nil)
+	case o.strategy != PoolRoundRobin && o.strategy != PoolLeastConnections && o.strategy != PoolStickyDestination:
+		return nil, newError(ErrInvalidConfig, opTorPool, fmt.Sprintf("unknown pool strategy %q", o.strategy), nil)
+	case o.healthInterval <= 0:
+		return nil, newError(ErrInvalidConfig, opTorPool, fmt.Sprintf("health interval must be positive, got %v", o.healthInterval), nil)
+	case o.maxFailures < 1:
+		return nil, newError(ErrInvalidConfig, opTorPool, fmt.Sprintf("max failures must be at least 1, got %d", o.maxFailures), nil)
+	case cfg.torConfigFile != "":
+		return nil, newError(ErrInvalidConfig, opTorPool,
+			"WithTorConfigFile cannot be used with a pool because every instance needs its own ports and DataDirectory", nil)
+	}
+
+	slots := make([]*poolSlot, size)
+	for i := range slots {
+		slots[i] = &poolSlot{index: i, cfg: poolInstanceConfig(cfg, i)}
+	}
+	return &TorPool{
+		opts:   o,
+		slots:  slots,
+		logger: cfg.Logger(),
+		launch: StartTorDaemonContext,
+		check:  CheckTorDaemon,
+		exited: make(chan poolExit, size),
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// poolInstanceConfig derives the launch config of instance i: every port is
+// left to Tor and the DataDirectory gets a per-instance subdirectory.
+func poolInstanceConfig(cfg TorLaunchConfig, i int) TorLaunchConfig {
+	anyPort := func(addr string) string {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return addr
+		}
+		return net.JoinHostPort(host, "0")
+	}
+	cfg.socksAddr = anyPort(cfg.socksAddr)
+	cfg.controlAddr = anyPort(cfg.controlAddr)
+	if cfg.httpTunnelAddr != "" {
+		cfg.httpTunnelAddr = anyPort(cfg.httpTunnelAddr)
+	}
+	if cfg.dnsAddr != "" {
+		cfg.dnsAddr = anyPort(cfg.dnsAddr)
+	}
+	cfg.socksPorts = cfg.SocksPorts()
+	for j := range cfg.socksPorts {
+		cfg.socksPorts[j].Addr = anyPort(cfg.socksPorts[j].Addr)
+	}
+	if cfg.dataDir != "" {
+		cfg.dataDir = filepath.Join(cfg.dataDir, "tor-"+strconv.Itoa(i))
+	}
+	// The pool stops its instances itself.
+	cfg.stopWithContext = false
+	return cfg
+}
+
+// Start launches every instance and begins health-checking them until Stop is
+// called or ctx is canceled. If any instance fails to start, the others are
+// stopped and the error is returned.
+func (p *TorPool) Start(ctx context.Context) error {
+	p.mu.Lock()
+	switch {
+	case p.started:
+		p.mu.Unlock()
+		return newError(ErrInvalidConfig, opTorPool, "pool already started", nil)
+	case p.stopped:
+		p.mu.Unlock()
+		return newError(ErrInvalidConfig, opTorPool, "pool already stopped", nil)
+	}
+	p.started = true
+	p.mu.Unlock()
+
+	// Launches, including later replacements, end as soon as Stop is called.
+	ctx, cancel := p.stopContext(ctx)
+	procs := make([]*TorProcess, len(p.slots))
+	errs := make([]error, len(p.slots))
+	var wg sync.WaitGroup
+	for i, slot := range p.slots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			procs[i], errs[i] = p.launch(ctx, slot.cfg)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		cancel()
+		for _, proc := range procs {
+			if proc != nil {
+				_ = proc.Stop() //nolint:errcheck // the launch error is more relevant
+			}
+		}
+		p.mu.Lock()
+		p.stopped = true
+		p.mu.Unlock()
+		close(p.done)
+		return newError(ErrTorLaunchFailed, opTorPool, "failed to start tor pool", err)
+	}
+
+	p.mu.Lock()
+	for i, slot := range p.slots {
+		slot.proc, slot.healthy = procs[i], true
+		go p.watchExit(slot, procs[i], procs[i].Done())
+	}
+	p.mu.Unlock()
+	p.logger.Log("info", "tor pool started", "size", len(p.slots), "strategy", string(p.opts.strategy))
+
+	go p.run(ctx, cancel)
+	return nil
+}
+
+// stopContext returns a context derived from ctx that Stop also cancels.
+func (p *TorPool) stopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-p.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// run health-checks and replaces instances until the pool stops. cancel
+// releases ctx, which Stop also cancels.
+func (p *TorPool) run(ctx context.Context, cancel context.CancelFunc) {
+	defer close(p.done)
+	defer cancel()
+	ticker := time.NewTicker(p.opts.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.shutdown()
+			return
+		case <-p.stopCh:
+			p.shutdown()
+			return
+		case ev := <-p.exited:
+			p.mu.Lock()
+			current := ev.slot.proc == ev.proc
+			p.mu.Unlock()
+			if current {
+				p.logger.Log("warn", "tor pool instance exited", "index", ev.slot.index, "error", ev.proc.ExitErr())
+				p.replace(ctx, ev.slot)
+			}
+		case <-ticker.C:
+			p.checkAll(ctx)
+		}
+	}
+}
+
+// watchExit reports when proc exits. done is taken from proc by the caller
+// because Stop modifies proc concurrently.
+func (p *TorPool) watchExit(slot *poolSlot, proc *TorProcess, done <-chan struct{}) {
+	select {
+	case <-done:
+		select {
+		case p.exited <- poolExit{slot: slot, proc: proc}:
+		case <-p.stopCh:
+		}
+	case <-p.stopCh:
+	}
+}
+
+// checkAll health-checks every instance and replaces failing ones.
+func (p *TorPool) checkAll(ctx context.Context) {
+	for _, slot := range p.slots {
+		p.mu.Lock()
+		proc := slot.proc
+		p.mu.Unlock()
+		if proc == nil {
+			p.replace(ctx, slot)
+			continue
+		}
+		health := p.check(ctx, proc)
+		p.mu.Lock()
+		if health.IsHealthy() {
+			slot.healthy, slot.failures = true, 0
+		} else {
+			slot.healthy = false
+			slot.failures++
+		}
+		failing := health.IsUnhealthy() || slot.failures >= p.opts.maxFailures
+		p.mu.Unlock()
+		if !health.IsHealthy() {
+			p.logger.Log("warn", "tor pool instance failed health check", "index", slot.index, "status", string(health.Status()), "message", health.Message())
+		}
+		if failing {
+			p.replace(ctx, slot)
+		}
+	}
+}
+
+// replace stops the tor of slot and launches a new one. When the launch
+// fails the slot stays empty and the next health check retries.
+func (p *TorPool) replace(ctx context.Context, slot *poolSlot) {
+	p.mu.Lock()
+	old := slot.proc
+	slot.proc, slot.healthy = nil, false
+	p.mu.Unlock()
+	if old != nil {
+		if err := old.Stop(); err != nil {
+			p.logger.Log("warn", "failed to stop tor pool instance", "index", slot.index, "error", err)
+		}
+	}
+
+	proc, err := p.launch(ctx, slot.cfg)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Log("error", "failed to replace tor pool instance", "index", slot.index, "error", err)
+		}
+		return
+	}
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		_ = proc.Stop() //nolint:errcheck // the pool is shutting down
+		return
+	}
+	slot.proc, slot.healthy, slot.failures = proc, true, 0
+	slot.replacements++
+	p.mu.Unlock()
+	p.logger.Log("info", "replaced tor pool instance", "index", slot.index, "socks_addr", proc.SocksAddr())
+	go p.watchExit(slot, proc, proc.Done())
+}
+
+// shutdown stops every instance.
+func (p *TorPool) shutdown() {
+	p.mu.Lock()
+	p.stopped = true
+	procs := make([]*TorProcess, 0, len(p.slots))
+	for _, slot := range p.slots {
+		if slot.proc != nil {
+			procs = append(procs, slot.proc)
+		}
+		slot.proc, slot.healthy = nil, false
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, proc := range procs {
+		errs = append(errs, proc.Stop())
+	}
+	p.mu.Lock()
+	p.stopErr = errors.Join(errs...)
+	p.mu.Unlock()
+	p.logger.Log("info", "tor pool stopped")
+}
+
+// Stop stops every instance, aborting any replacement that is still
+// starting. It is safe to call more than once, and before Start.
+func (p *TorPool) Stop() error {
+	p.mu.Lock()
+	started := p.started
+	select {
+	case <-p.stopCh:
+	default:
+		close(p.stopCh)
+		if !started {
+			// Start will refuse to run, so nothing else closes done.
+			close(p.done)
+		}
+	}
+	p.stopped = true
+	p.mu.Unlock()
+	if started {
+		<-p.done
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopErr
+}
+
+// Done returns a channel that is closed once the pool has stopped.
+func (p *TorPool) Done() <-chan struct{} { return p.done }
+
+// Size returns the number of instances.
+func (p *TorPool) Size() int { return len(p.slots) }
+
+// Instances returns a snapshot of every instance.
+func (p *TorPool) Instances() []PoolInstance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]PoolInstance, len(p.slots))
+	for i, slot := range p.slots {
+		out[i] = PoolInstance{
+			Index:        slot.index,
+			Process:      slot.proc,
+			Healthy:      slot.healthy && slot.proc != nil,
+			ActiveConns:  int(slot.active.Load()),
+			Replacements: slot.replacements,
+		}
+	}
+	return out
+}
+
+// DialContext opens a connection to addr through one of the healthy
+// instances, chosen by the pool strategy.
+func (p *TorPool) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	slot, socksAddr, err := p.pick(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &socks5Dialer{addr: socksAddr}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		slot.active.Add(-1)
+		return nil, err
+	}
+	return &poolConn{Conn: conn, slot: slot}, nil
+}
+
+// Dialer returns DialContext for libraries that accept a dial function.
+func (p *TorPool) Dialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return p.DialContext
+}
+
+// NewClient returns a Client whose connections are spread across the pool.
+// The SocksAddr of the client config is ignored; set a ControlAddr only to
+// control one specific instance.
+func (p *TorPool) NewClient(opts ...ClientOption) (*Client, error) {
+	cfg, err := NewClientConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(cfg, p)
+}
+
+// pick selects a healthy instance for a connection to addr and counts the
+// connection against it.
+func (p *TorPool) pick(addr string) (*poolSlot, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := make([]*poolSlot, 0, len(p.slots))
+	for _, slot := range p.slots {
+		if slot.healthy && slot.proc != nil {
+			candidates = append(candidates, slot)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, "", newError(ErrSocksDialFailed, opTorPool, "no healthy tor instance available", nil)
+	}
+
+	var chosen *poolSlot
+	switch p.opts.strategy {
+	case PoolLeastConnections:
+		// Start at the round-robin cursor so ties are spread evenly.
+		start := int(p.next.Add(1) % uint64(len(candidates))) //nolint:gosec // len is small and positive
+		for i := range candidates {
+			slot := candidates[(start+i)%len(candidates)]
+			if chosen == nil || slot.active.Load() < chosen.active.Load() {
+				chosen = slot
+			}
+		}
+	case PoolStickyDestination:
+		chosen = stickySlot(candidates, addr)
+	default:
+		chosen = candidates[p.next.Add(1)%uint64(len(candidates))] //nolint:gosec // len is small and positive
+	}
+	chosen.active.Add(1)
+	return chosen, chosen.proc.SocksAddr(), nil
+}
+
+// stickySlot picks the slot for the host of addr by rendezvous hashing, so a
+// host only moves when its instance becomes unavailable.
+func stickySlot(candidates []*poolSlot, addr string) *poolSlot {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	var chosen *poolSlot
+	var best uint64
+	for _, slot := range candidates {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(host + "#" + strconv.Itoa(slot.index))) //nolint:errcheck // hash writes cannot fail
+		if score := h.Sum64(); chosen == nil || score > best {
+			chosen, best = slot, score
+		}
+	}
+	return chosen
+}
+
+// poolConn releases its instance's connection count on Close.
+type poolConn struct {
+	net.Conn
+	// slot is the instance the connection goes through.
+	slot *poolSlot
+	// once guards against releasing twice.
+	once sync.Once
+}
+
+// Close closes the connection and releases it from its instance.
+func (c *poolConn) Close() error {
+	c.once.Do(func() { c.slot.active.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,417 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolLauncher starts sleep processes in place of tor, each fronted by a
+// mock SOCKS5 server.
+type poolLauncher struct {
+	t       *testing.T
+	mu      sync.Mutex
+	configs []TorLaunchConfig
+	procs   []*TorProcess
+	failAt  int
+}
+
+func (l *poolLauncher) launch(_ context.Context, cfg TorLaunchConfig) (*TorProcess, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configs = append(l.configs, cfg)
+	if l.failAt > 0 && len(l.configs) == l.failAt {
+		return nil, errors.New("launch failed")
+	}
+	socks := createMockSOCKS5Server(l.t)
+	l.t.Cleanup(socks.Close)
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		l.t.Skipf("sleep not available: %v", err)
+	}
+	proc := &TorProcess{
+		pid:       cmd.Process.Pid,
+		socksAddr: socks.Addr().String(),
+		cmd:       cmd,
+		process:   cmd.Process,
+		dataDir:   cfg.DataDir(),
+		wait:      watchProcess(cmd),
+	}
+	l.procs = append(l.procs, proc)
+	return proc, nil
+}
+
+func (l *poolLauncher) launched() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.procs)
+}
+
+// newTestPool returns an unstarted pool of size fake instances that always
+// pass health checks.
+func newTestPool(t *testing.T, size int, opts ...PoolOption) (*TorPool, *poolLauncher) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on the sleep command")
+	}
+	cfg, err := NewTorLaunchConfig()
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+	pool, err := NewTorPool(size, cfg, opts...)
+	if err != nil {
+		t.Fatalf("NewTorPool failed: %v", err)
+	}
+	l := &poolLauncher{t: t}
+	pool.launch = l.launch
+	pool.check = func(context.Context, *TorProcess) HealthCheck {
+		return HealthCheck{status: HealthStatusHealthy}
+	}
+	return pool, l
+}
+
+// startTestPool starts pool and stops it when the test ends.
+func startTestPool(t *testing.T, pool *TorPool) {
+	t.Helper()
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { _ = pool.Stop() })
+}
+
+// dialIndex dials addr through pool and reports the instance used.
+func dialIndex(t *testing.T, pool *TorPool, addr string) (net.Conn, int) {
+	t.Helper()
+	conn, err := pool.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	pc, ok := conn.(*poolConn)
+	if !ok {
+		t.Fatalf("DialContext returned %T, want *poolConn", conn)
+	}
+	return conn, pc.slot.index
+}
+
+// waitForPool polls cond until it holds.
+func waitForPool(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for pool condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewTorPool(t *testing.T) {
+	cfg, err := NewTorLaunchConfig(
+		WithTorSocksAddr("127.0.0.1:9050"),
+		WithTorControlAddr("127.0.0.1:9051"),
+		WithTorSocksPort("onion", "127.0.0.1:9150", SocksOnionTrafficOnly),
+		WithTorDataDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+
+	t.Run("should give each instance its own ports and data directory", func(t *testing.T) {
+		pool, err := NewTorPool(3, cfg)
+		if err != nil {
+			t.Fatalf("NewTorPool failed: %v", err)
+		}
+		if pool.Size() != 3 {
+			t.Fatalf("Size() = %d, want 3", pool.Size())
+		}
+		for i, slot := range pool.slots {
+			if got := slot.cfg.SocksAddr(); got != "127.0.0.1:0" {
+				t.Errorf("instance %d SocksAddr = %q, want 127.0.0.1:0", i, got)
+			}
+			if got := slot.cfg.ControlAddr(); got != "127.0.0.1:0" {
+				t.Errorf("instance %d ControlAddr = %q, want 127.0.0.1:0", i, got)
+			}
+			if got := slot.cfg.SocksPorts()[0].Addr; got != "127.0.0.1:0" {
+				t.Errorf("instance %d onion SocksPort = %q, want 127.0.0.1:0", i, got)
+			}
+			if want := filepath.Join(cfg.DataDir(), "tor-"+string(rune('0'+i))); slot.cfg.DataDir() != want {
+				t.Errorf("instance %d DataDir = %q, want %q", i, slot.cfg.DataDir(), want)
+			}
+		}
+		if got := cfg.SocksPorts()[0].Addr; got != "127.0.0.1:9150" {
+			t.Errorf("base config was modified: onion SocksPort = %q", got)
+		}
+	})
+
+	t.Run("should reject invalid settings", func(t *testing.T) {
+		torrc := filepath.Join(t.TempDir(), "torrc")
+		fileCfg, err := NewTorLaunchConfig(WithTorConfigFile(torrc))
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		cases := map[string]func() (*TorPool, error){
+			"size":         func() (*TorPool, error) { return NewTorPool(0, cfg) },
+			"strategy":     func() (*TorPool, error) { return NewTorPool(1, cfg, WithPoolStrategy("random")) },
+			"interval":     func() (*TorPool, error) { return NewTorPool(1, cfg, WithPoolHealthInterval(0)) },
+			"max failures": func() (*TorPool, error) { return NewTorPool(1, cfg, WithPoolMaxFailures(0)) },
+			"torrc file":   func() (*TorPool, error) { return NewTorPool(1, fileCfg) },
+		}
+		for name, newPool := range cases {
+			if _, err := newPool(); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+				t.Errorf("%s: error = %v, want ErrInvalidConfig", name, err)
+			}
+		}
+	})
+}
+
+func TestTorPool(t *testing.T) {
+	t.Run("should spread connections round-robin", func(t *testing.T) {
+		pool, _ := newTestPool(t, 3)
+		startTestPool(t, pool)
+
+		counts := map[int]int{}
+		for range 6 {
+			conn, idx := dialIndex(t, pool, "example.com:80")
+			counts[idx]++
+			_ = conn.Close()
+		}
+		for i := range 3 {
+			if counts[i] != 2 {
+				t.Errorf("instance %d got %d connections, want 2 (counts %v)", i, counts[i], counts)
+			}
+		}
+	})
+
+	t.Run("should prefer the instance with the fewest connections", func(t *testing.T) {
+		pool, _ := newTestPool(t, 2, WithPoolStrategy(PoolLeastConnections))
+		startTestPool(t, pool)
+
+		first, firstIdx := dialIndex(t, pool, "example.com:80")
+		second, secondIdx := dialIndex(t, pool, "example.com:80")
+		if firstIdx == secondIdx {
+			t.Fatalf("both connections went to instance %d", firstIdx)
+		}
+		_ = first.Close()
+		_ = first.Close()
+		third, thirdIdx := dialIndex(t, pool, "example.com:80")
+		if thirdIdx != firstIdx {
+			t.Errorf("third connection went to instance %d, want idle instance %d", thirdIdx, firstIdx)
+		}
+		if got := pool.Instances()[secondIdx].ActiveConns; got != 1 {
+			t.Errorf("ActiveConns of instance %d = %d, want 1", secondIdx, got)
+		}
+		_ = second.Close()
+		_ = third.Close()
+	})
+
+	t.Run("should keep a destination on one instance", func(t *testing.T) {
+		pool, _ := newTestPool(t, 4, WithPoolStrategy(PoolStickyDestination))
+		startTestPool(t, pool)
+
+		conn, want := dialIndex(t, pool, "example.com:443")
+		_ = conn.Close()
+		for range 5 {
+			conn, idx := dialIndex(t, pool, "example.com:80")
+			_ = conn.Close()
+			if idx != want {
+				t.Fatalf("example.com went to instance %d, want %d", idx, want)
+			}
+		}
+	})
+
+	t.Run("should replace an instance that exits", func(t *testing.T) {
+		pool, l := newTestPool(t, 2)
+		startTestPool(t, pool)
+
+		old := pool.Instances()[0].Process
+		if err := old.process.Kill(); err != nil {
+			t.Fatalf("Kill failed: %v", err)
+		}
+		waitForPool(t, func() bool {
+			inst := pool.Instances()[0]
+			return inst.Replacements == 1 && inst.Healthy
+		})
+		if l.launched() != 3 {
+			t.Errorf("launched %d processes, want 3", l.launched())
+		}
+		if got := pool.Instances()[0].Process; got == old {
+			t.Error("instance 0 still reports the exited process")
+		}
+	})
+
+	t.Run("should replace instances that fail health checks", func(t *testing.T) {
+		pool, l := newTestPool(t, 2, WithPoolHealthInterval(20*time.Millisecond), WithPoolMaxFailures(2))
+		var mu sync.Mutex
+		var failing *TorProcess
+		pool.check = func(_ context.Context, proc *TorProcess) HealthCheck {
+			mu.Lock()
+			defer mu.Unlock()
+			if proc == failing {
+				return HealthCheck{status: HealthStatusDegraded, message: "control port unreachable"}
+			}
+			return HealthCheck{status: HealthStatusHealthy}
+		}
+		startTestPool(t, pool)
+
+		mu.Lock()
+		failing = pool.Instances()[1].Process
+		mu.Unlock()
+		waitForPool(t, func() bool { return pool.Instances()[1].Replacements == 1 })
+		if got := pool.Instances()[0].Replacements; got != 0 {
+			t.Errorf("healthy instance was replaced %d times", got)
+		}
+		if l.launched() < 3 {
+			t.Errorf("launched %d processes, want at least 3", l.launched())
+		}
+	})
+
+	t.Run("should skip unhealthy instances when dialing", func(t *testing.T) {
+		pool, _ := newTestPool(t, 2)
+		startTestPool(t, pool)
+
+		pool.mu.Lock()
+		pool.slots[0].healthy = false
+		pool.mu.Unlock()
+		for range 3 {
+			conn, idx := dialIndex(t, pool, "example.com:80")
+			_ = conn.Close()
+			if idx != 1 {
+				t.Fatalf("connection went to unhealthy instance %d", idx)
+			}
+		}
+
+		pool.mu.Lock()
+		pool.slots[1].healthy = false
+		pool.mu.Unlock()
+		if _, err := pool.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, &TornagoError{Kind: ErrSocksDialFailed}) {
+			t.Errorf("error = %v, want ErrSocksDialFailed", err)
+		}
+	})
+
+	t.Run("should stop started instances when one fails to start", func(t *testing.T) {
+		pool, l := newTestPool(t, 3)
+		l.failAt = 2
+		if err := pool.Start(context.Background()); !errors.Is(err, &TornagoError{Kind: ErrTorLaunchFailed}) {
+			t.Fatalf("Start error = %v, want ErrTorLaunchFailed", err)
+		}
+		for _, proc := range l.procs {
+			if !proc.Exited() {
+				t.Errorf("process %d is still running", proc.pid)
+			}
+		}
+		select {
+		case <-pool.Done():
+		default:
+			t.Error("Done() not closed after failed start")
+		}
+	})
+
+	t.Run("should stop every instance", func(t *testing.T) {
+		pool, l := newTestPool(t, 2)
+		if err := pool.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		if err := pool.Stop(); err != nil {
+			t.Fatalf("Stop failed: %v", err)
+		}
+		if err := pool.Stop(); err != nil {
+			t.Fatalf("second Stop failed: %v", err)
+		}
+		for _, proc := range l.procs {
+			if !proc.Exited() {
+				t.Errorf("process %d is still running", proc.pid)
+			}
+		}
+		if err := pool.Start(context.Background()); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+			t.Errorf("Start after Stop error = %v, want ErrInvalidConfig", err)
+		}
+		if _, err := pool.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, &TornagoError{Kind: ErrSocksDialFailed}) {
+			t.Errorf("DialContext after Stop error = %v, want ErrSocksDialFailed", err)
+		}
+	})
+
+	t.Run("should close Done when stopped before Start", func(t *testing.T) {
+		pool, l := newTestPool(t, 2)
+		if err := pool.Stop(); err != nil {
+			t.Fatalf("Stop failed: %v", err)
+		}
+		select {
+		case <-pool.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Done() not closed after Stop without Start")
+		}
+		if err := pool.Start(context.Background()); !errors.Is(err, &TornagoError{Kind: ErrInvalidConfig}) {
+			t.Errorf("Start after Stop error = %v, want ErrInvalidConfig", err)
+		}
+		if l.launched() != 0 {
+			t.Errorf("launched %d processes after Stop, want 0", l.launched())
+		}
+	})
+
+	t.Run("should abort a pending replacement on Stop", func(t *testing.T) {
+		pool, _ := newTestPool(t, 1)
+		startTestPool(t, pool)
+
+		replacing := make(chan struct{})
+		pool.launch = func(ctx context.Context, _ TorLaunchConfig) (*TorProcess, error) {
+			close(replacing)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		if err := pool.Instances()[0].Process.process.Kill(); err != nil {
+			t.Fatalf("Kill failed: %v", err)
+		}
+		select {
+		case <-replacing:
+		case <-time.After(5 * time.Second):
+			t.Fatal("replacement was not launched")
+		}
+
+		stopped := make(chan error, 1)
+		go func() { stopped <- pool.Stop() }()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop blocked on a pending replacement")
+		}
+	})
+
+	t.Run("should stop when the context is canceled", func(t *testing.T) {
+		pool, _ := newTestPool(t, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := pool.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		cancel()
+		select {
+		case <-pool.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("pool did not stop after cancel")
+		}
+	})
+
+	t.Run("should route Client connections through the pool", func(t *testing.T) {
+		pool, _ := newTestPool(t, 2)
+		startTestPool(t, pool)
+
+		client, err := pool.NewClient()
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+		conn, err := client.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		if _, ok := conn.(*poolConn); !ok {
+			t.Errorf("client dialed %T, want a pool connection", conn)
+		}
+		_ = conn.Close()
+	})
+}
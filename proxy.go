@@ -0,0 +1,243 @@
+package tornago
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// upstreamProxy is a validated WithTorUpstreamProxy setting.
+type upstreamProxy struct {
+	// scheme is "http", "https", "socks5", "socks4" or "socks4a".
+	scheme string
+	// hostPort is the proxy address with the default port filled in.
+	hostPort string
+	// user holds the proxy credentials, if any.
+	user *url.Userinfo
+}
+
+// parseUpstreamProxy validates a WithTorUpstreamProxy URL.
+func parseUpstreamProxy(raw string) (upstreamProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error repeats the URL, which may contain a password.
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig", "invalid upstream proxy URL", nil)
+	}
+	p := upstreamProxy{scheme: strings.ToLower(u.Scheme), user: u.User}
+	var defaultPort string
+	switch p.scheme {
+	case "http", "https":
+		// Tor's HTTPSProxy uses CONNECT for both schemes.
+		defaultPort = "443"
+	case "socks5", "socks4", "socks4a":
+		defaultPort = "1080"
+	default:
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			fmt.Sprintf("unsupported upstream proxy scheme %q, expected http, https, socks5 or socks4", u.Scheme), nil)
+	}
+	if u.Hostname() == "" {
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig", "upstream proxy URL has no host: "+u.Redacted(), nil)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig", "upstream proxy URL must not have a path or query: "+u.Redacted(), nil)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig", "invalid upstream proxy port in "+u.Redacted(), nil)
+	}
+	p.hostPort = net.JoinHostPort(u.Hostname(), port)
+
+	if p.user == nil {
+		return p, nil
+	}
+	password, hasPassword := p.user.Password()
+	switch p.scheme {
+	case "socks4", "socks4a":
+		return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig", "SOCKS4 proxies do not support credentials", nil)
+	case "socks5":
+		// RFC 1929 limits both fields to 1-255 bytes, and Tor requires both.
+		if n := len(p.user.Username()); n < 1 || n > 255 || !hasPassword || len(password) < 1 || len(password) > 255 {
+			return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				"SOCKS5 proxy credentials need a username and a password of 1-255 bytes each", nil)
+		}
+	default:
+		if p.user.Username() == "" || strings.Contains(p.user.Username(), ":") {
+			return upstreamProxy{}, newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				"HTTP proxy credentials need a username without ':'", nil)
+		}
+	}
+	return p, nil
+}
+
+// args renders the proxy address as tor CLI args. Credentials are left to
+// credentials so that they never appear on tor's command line.
+func (p upstreamProxy) args() []string {
+	switch p.scheme {
+	case "http", "https":
+		return []string{"--HTTPSProxy", p.hostPort}
+	case "socks5":
+		return []string{"--Socks5Proxy", p.hostPort}
+	default:
+		return []string{"--Socks4Proxy", p.hostPort}
+	}
+}
+
+// credentials renders the proxy credentials as torrc lines, or returns nil
+// when the proxy has none.
+func (p upstreamProxy) credentials() *Torrc {
+	if p.user == nil {
+		return nil
+	}
+	password, _ := p.user.Password()
+	switch p.scheme {
+	case "http", "https":
+		return NewTorrc().Set("HTTPSProxyAuthenticator", p.user.Username()+":"+password)
+	case "socks5":
+		return NewTorrc().
+			Set("Socks5ProxyUsername", p.user.Username()).
+			Set("Socks5ProxyPassword", password)
+	default:
+		return nil
+	}
+}
+
+// proxyAuthFileName is the file in the DataDirectory holding upstream proxy
+// credentials, passed to tor with --defaults-torrc.
+const proxyAuthFileName = "tornago-proxy-auth"
+
+// writeProxyCredentials writes the upstream proxy credentials of cfg to an
+// owner-only file in dataDir and returns its path, or "" when there are none.
+func writeProxyCredentials(cfg TorLaunchConfig, dataDir string) (string, error) {
+	if cfg.upstreamProxy == "" {
+		return "", nil
+	}
+	p, err := parseUpstreamProxy(cfg.upstreamProxy)
+	if err != nil {
+		return "", err
+	}
+	creds := p.credentials()
+	if creds == nil {
+		return "", nil
+	}
+	path := filepath.Join(dataDir, proxyAuthFileName)
+	if err := creds.WriteFile(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// removeProxyCredentials deletes a file written by writeProxyCredentials.
+func removeProxyCredentials(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ReachablePorts returns ReachableAddresses entries allowing any address on
+// the given ports, for firewalls that only let out e.g. 80 and 443:
+//
+//	tornago.WithTorReachableAddresses(tornago.ReachablePorts(80, 443)...)
+func ReachablePorts(ports ...int) []string {
+	entries := make([]string, len(ports))
+	for i, p := range ports {
+		entries[i] = "*:" + strconv.Itoa(p)
+	}
+	return entries
+}
+
+// validateReachableAddress checks one ReachableAddresses entry:
+// "[accept|reject] ADDR[/MASK][:PORT[-PORT]]" with ADDR "*", "*4", "*6", an
+// IPv4 address or a bracketed IPv6 address.
+func validateReachableAddress(entry string) error {
+	invalid := func() error {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			fmt.Sprintf("invalid ReachableAddresses entry %q, expected e.g. \"*:443\" or \"reject 10.0.0.0/8\"", entry), nil)
+	}
+	fields := strings.Fields(entry)
+	if len(fields) == 2 && (fields[0] == "accept" || fields[0] == "reject" || fields[0] == "accept6" || fields[0] == "reject6") {
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return invalid()
+	}
+	addr, ports := fields[0], ""
+	if strings.HasPrefix(addr, "[") {
+		end := strings.Index(addr, "]")
+		if end < 0 {
+			return invalid()
+		}
+		addr, ports = addr[1:end], addr[end+1:]
+		if ports != "" && !strings.HasPrefix(ports, ":") && !strings.HasPrefix(ports, "/") {
+			return invalid()
+		}
+	} else if i := strings.LastIndex(addr, ":"); i >= 0 {
+		addr, ports = addr[:i], addr[i:]
+	}
+	if mask, rest, ok := strings.Cut(ports, ":"); ok && strings.HasPrefix(mask, "/") {
+		addr, ports = addr+mask, ":"+rest
+	}
+	if base, mask, ok := strings.Cut(addr, "/"); ok {
+		if n, err := strconv.Atoi(mask); err != nil || n < 0 || n > 128 {
+			return invalid()
+		}
+		addr = base
+	}
+	if addr != "*" && addr != "*4" && addr != "*6" && net.ParseIP(addr) == nil {
+		return invalid()
+	}
+	if ports = strings.TrimPrefix(ports, ":"); ports == "" || ports == "*" {
+		return nil
+	}
+	lo, hi, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		hi = lo
+	}
+	l, errLo := strconv.Atoi(lo)
+	h, errHi := strconv.Atoi(hi)
+	if errLo != nil || errHi != nil || l < 1 || h > 65535 || l > h {
+		return invalid()
+	}
+	return nil
+}
+
+// proxyArgs renders the upstream proxy address and ReachableAddresses of cfg
+// as tor CLI args. Proxy credentials are written by writeProxyCredentials.
+func proxyArgs(cfg TorLaunchConfig) []string {
+	var args []string
+	if cfg.upstreamProxy != "" {
+		if p, err := parseUpstreamProxy(cfg.upstreamProxy); err == nil {
+			args = append(args, p.args()...)
+		}
+	}
+	if len(cfg.reachableAddresses) > 0 {
+		args = append(args, "--ReachableAddresses", strings.Join(cfg.reachableAddresses, ", "))
+	}
+	return args
+}
+
+// validateProxyOptions checks the upstream proxy and ReachableAddresses.
+func validateProxyOptions(cfg TorLaunchConfig) error {
+	if cfg.upstreamProxy != "" {
+		if _, err := parseUpstreamProxy(cfg.upstreamProxy); err != nil {
+			return err
+		}
+	}
+	for _, entry := range cfg.reachableAddresses {
+		if err := validateReachableAddress(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package tornago
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Relay flags assigned by the directory authorities in the consensus.
+const (
+	// RelayFlagAuthority marks a directory authority.
+	RelayFlagAuthority = "Authority"
+	// RelayFlagBadExit marks an exit that is believed to be useless or harmful.
+	RelayFlagBadExit = "BadExit"
+	// RelayFlagExit marks a relay that allows exiting to common ports.
+	RelayFlagExit = "Exit"
+	// RelayFlagFast marks a relay suitable for high-bandwidth circuits.
+	RelayFlagFast = "Fast"
+	// RelayFlagGuard marks a relay suitable as an entry guard.
+	RelayFlagGuard = "Guard"
+	// RelayFlagHSDir marks a relay that stores onion service descriptors.
+	RelayFlagHSDir = "HSDir"
+	// RelayFlagRunning marks a relay that is currently usable.
+	RelayFlagRunning = "Running"
+	// RelayFlagStable marks a relay suitable for long-lived circuits.
+	RelayFlagStable = "Stable"
+	// RelayFlagValid marks a relay that has been validated by the authorities.
+	RelayFlagValid = "Valid"
+)
+
+// countryLookupBatch bounds how many ip-to-country keys are sent per GETINFO.
+const countryLookupBatch = 128
+
+// PortRange is an inclusive range of TCP ports.
+type PortRange struct {
+	// Min is the first port in the range.
+	Min int
+	// Max is the last port in the range.
+	Max int
+}
+
+// Contains reports whether port lies within the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// String renders the range in Tor's "min-max" notation.
+func (r PortRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ExitPolicySummary is the compact exit policy published in the consensus
+// ("p accept 80,443" or "p reject 1-65535").
+type ExitPolicySummary struct {
+	// Accept reports whether Ports lists accepted (true) or rejected (false) ports.
+	Accept bool
+	// Ports lists the port ranges the policy applies to.
+	Ports []PortRange
+}
+
+// AllowsPort reports whether the summary permits exiting to port.
+func (p ExitPolicySummary) AllowsPort(port int) bool {
+	listed := false
+	for _, r := range p.Ports {
+		if r.Contains(port) {
+			listed = true
+			break
+		}
+	}
+	if p.Accept {
+		return listed
+	}
+	return !listed && len(p.Ports) > 0
+}
+
+// String renders the summary in consensus notation (e.g. "accept 80,443").
+func (p ExitPolicySummary) String() string {
+	if len(p.Ports) == 0 {
+		return ""
+	}
+	action := "reject"
+	if p.Accept {
+		action = "accept"
+	}
+	ranges := make([]string, 0, len(p.Ports))
+	for _, r := range p.Ports {
+		ranges = append(ranges, r.String())
+	}
+	return action + " " + strings.Join(ranges, ",")
+}
+
+// Relay describes a Tor relay as listed in the current consensus.
+type Relay struct {
+	// Nickname is the relay's self-chosen name.
+	Nickname string
+	// Fingerprint is the 40-character uppercase hex identity digest.
+	Fingerprint string
+	// Published is when the relay's descriptor was published.
+	Published time.Time
+	// Address is the relay's IPv4 address.
+	Address string
+	// ORPort is the port accepting relay connections.
+	ORPort int
+	// DirPort is the directory port (0 if none).
+	DirPort int
+	// IPv6Addresses lists additional OR addresses from "a" lines.
+	IPv6Addresses []string
+	// Flags lists the consensus flags (e.g. "Exit", "Guard", "Fast").
+	Flags []string
+	// Version is the Tor version advertised by the relay.
+	Version string
+	// Bandwidth is the consensus weight in kilobytes per second.
+	Bandwidth int64
+	// Measured reports whether Bandwidth was measured by bandwidth authorities.
+	Measured bool
+	// ExitPolicy is the exit policy summary from the consensus.
+	ExitPolicy ExitPolicySummary
+	// Family lists relays declared to be run by the same operator.
+	Family []string
+	// Country is the lowercase ISO 3166 code from Tor's GeoIP database ("" if unknown).
+	Country string
+}
+
+// HasFlag reports whether the relay carries the given consensus flag.
+func (r Relay) HasFlag(flag string) bool {
+	for _, f := range r.Flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExit reports whether the relay is a usable exit (Exit without BadExit).
+func (r Relay) IsExit() bool {
+	return r.HasFlag(RelayFlagExit) && !r.HasFlag(RelayFlagBadExit)
+}
+
+// RelayDescriptor holds the fields of a relay's server descriptor.
+type RelayDescriptor struct {
+	// Nickname is the relay's self-chosen name.
+	Nickname string
+	// Fingerprint is the 40-character uppercase hex identity digest.
+	Fingerprint string
+	// Address is the relay's IPv4 address.
+	Address string
+	// ORPort is the port accepting relay connections.
+	ORPort int
+	// Platform describes the Tor version and operating system.
+	Platform string
+	// Contact is the operator contact information.
+	Contact string
+	// BandwidthAverage is the advertised average bandwidth in bytes per second.
+	BandwidthAverage int64
+	// BandwidthBurst is the advertised burst bandwidth in bytes per second.
+	BandwidthBurst int64
+	// BandwidthObserved is the observed bandwidth in bytes per second.
+	BandwidthObserved int64
+	// ExitPolicy lists the full accept/reject rules in order.
+	ExitPolicy []string
+	// Family lists relays declared to be run by the same operator.
+	Family []string
+}
+
+// Relays returns every relay in the current consensus (GETINFO ns/all),
+// enriched with country codes from Tor's GeoIP database. Country lookup
+// failures (e.g. GeoIP files not installed) leave Relay.Country empty.
+//
+// Example:
+//
+//	relays, _ := ctrl.Relays(ctx)
+//	exits := tornago.FilterRelays(relays,
+//	    tornago.RelayHasFlag(tornago.RelayFlagExit),
+//	    tornago.RelayInCountry("de", "nl"),
+//	)
+func (c *ControlClient) Relays(ctx context.Context) ([]Relay, error) {
+	lines, err := c.getInfoLines(ctx, "ns/all")
+	if err != nil {
+		return nil, err
+	}
+	relays := parseRouterStatus(lines)
+	c.resolveCountries(ctx, relays)
+	return relays, nil
+}
+
+// Relay returns a single relay by fingerprint using GETINFO ns/id/<fp>. The
+// fingerprint may be prefixed with "$" and may carry a "~nickname" suffix.
+// Family information is filled from the relay's microdescriptor when available.
+func (c *ControlClient) Relay(ctx context.Context, fingerprint string) (Relay, error) {
+	fp, err := normalizeFingerprint(fingerprint)
+	if err != nil {
+		return Relay{}, err
+	}
+	lines, err := c.getInfoLines(ctx, "ns/id/"+fp)
+	if err != nil {
+		return Relay{}, err
+	}
+	relays := parseRouterStatus(lines)
+	if len(relays) == 0 {
+		return Relay{}, newError(ErrControlRequestFail, opControlClient, "relay "+fp+" not found in consensus", nil)
+	}
+	relay := relays[0]
+
+	// The microdescriptor is optional: Tor may not have fetched it yet.
+	if md, mdErr := c.getInfoLines(ctx, "md/id/"+fp); mdErr == nil {
+		applyMicrodescriptor(&relay, md)
+	}
+	resolved := []Relay{relay}
+	c.resolveCountries(ctx, resolved)
+	return resolved[0], nil
+}
+
+// RelayDescriptor returns the server descriptor of a relay (GETINFO desc/id/<fp>).
+// Tor only keeps server descriptors when UseMicrodescriptors is 0 or
+// FetchUselessDescriptors is 1, so this frequently fails on default clients.
+func (c *ControlClient) RelayDescriptor(ctx context.Context, fingerprint string) (RelayDescriptor, error) {
+	fp, err := normalizeFingerprint(fingerprint)
+	if err != nil {
+		return RelayDescriptor{}, err
+	}
+	lines, err := c.getInfoLines(ctx, "desc/id/"+fp)
+	if err != nil {
+		return RelayDescriptor{}, err
+	}
+	desc := parseServerDescriptor(lines)
+	if desc.Nickname == "" {
+		return RelayDescriptor{}, newError(ErrControlRequestFail, opControlClient, "malformed descriptor for relay "+fp, nil)
+	}
+	if desc.Fingerprint == "" {
+		desc.Fingerprint = fp
+	}
+	return desc, nil
+}
+
+// resolveCountries fills Relay.Country using batched ip-to-country lookups.
+// GeoIP data is optional in Tor, so lookup errors only leave countries empty.
+func (c *ControlClient) resolveCountries(ctx context.Context, relays []Relay) {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, r := range relays {
+		ip := net.ParseIP(r.Address)
+		if ip == nil || ip.To4() == nil || seen[r.Address] {
+			continue
+		}
+		seen[r.Address] = true
+		addrs = append(addrs, r.Address)
+	}
+
+	countries := make(map[string]string, len(addrs))
+	for start := 0; start < len(addrs); start += countryLookupBatch {
+		end := min(start+countryLookupBatch, len(addrs))
+		keys := make([]string, 0, end-start)
+		for _, addr := range addrs[start:end] {
+			keys = append(keys, "ip-to-country/"+addr)
+		}
+		lines, err := c.execCommand(ctx, "GETINFO "+strings.Join(keys, " "))
+		if err != nil {
+			return
+		}
+		for _, line := range lines {
+			key, value, ok := strings.Cut(line, "=")
+			if !ok || !strings.HasPrefix(key, "ip-to-country/") || value == "??" {
+				continue
+			}
+			countries[strings.TrimPrefix(key, "ip-to-country/")] = strings.ToLower(value)
+		}
+	}
+	for i := range relays {
+		relays[i].Country = countries[relays[i].Address]
+	}
+}
+
+// RelayFilter selects relays in FilterRelays.
+type RelayFilter func(Relay) bool
+
+// FilterRelays returns the relays that satisfy every filter, preserving order.
+func FilterRelays(relays []Relay, filters ...RelayFilter) []Relay {
+	out := make([]Relay, 0, len(relays))
+	for _, r := range relays {
+		match := true
+		for _, f := range filters {
+			if f != nil && !f(r) {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// RelayHasFlag matches relays carrying all of the given consensus flags.
+func RelayHasFlag(flags ...string) RelayFilter {
+	return func(r Relay) bool {
+		for _, f := range flags {
+			if !r.HasFlag(f) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RelayInCountry matches relays located in any of the given country codes.
+func RelayInCountry(codes ...string) RelayFilter {
+	want := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		want[strings.ToLower(strings.Trim(code, "{} "))] = true
+	}
+	return func(r Relay) bool {
+		return r.Country != "" && want[r.Country]
+	}
+}
+
+// RelayMinBandwidth matches relays whose consensus weight is at least kbps.
+func RelayMinBandwidth(kbps int64) RelayFilter {
+	return func(r Relay) bool {
+		return r.Bandwidth >= kbps
+	}
+}
+
+// RelayAllowsExitPort matches usable exits whose policy summary permits port.
+func RelayAllowsExitPort(port int) RelayFilter {
+	return func(r Relay) bool {
+		return r.IsExit() && r.ExitPolicy.AllowsPort(port)
+	}
+}
+
+// SortRelaysByBandwidth sorts relays in place, fastest first.
+func SortRelaysByBandwidth(relays []Relay) {
+	sort.SliceStable(relays, func(i, j int) bool {
+		return relays[i].Bandwidth > relays[j].Bandwidth
+	})
+}
+
+// normalizeFingerprint strips "$" and "~nickname"/"=nickname" decorations and
+// validates that the remainder is a 40-character hex digest.
+func normalizeFingerprint(fingerprint string) (string, error) {
+	fp := strings.TrimPrefix(strings.TrimSpace(fingerprint), "$")
+	if idx := strings.IndexAny(fp, "~="); idx >= 0 {
+		fp = fp[:idx]
+	}
+	fp = strings.ToUpper(fp)
+	if len(fp) != 40 {
+		return "", newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid relay fingerprint %q", fingerprint), nil)
+	}
+	if _, err := hex.DecodeString(fp); err != nil {
+		return "", newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("invalid relay fingerprint %q", fingerprint), err)
+	}
+	return fp, nil
+}
+
+// parseRouterStatus parses router status entries from either the "ns" or the
+// "microdesc" consensus flavour. Entries start with an "r" line; unknown
+// keywords are ignored.
+func parseRouterStatus(lines []string) []Relay {
+	var relays []Relay
+	var current *Relay
+	flush := func() {
+		if current != nil && current.Fingerprint != "" {
+			relays = append(relays, *current)
+		}
+		current = nil
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "r":
+			flush()
+			if relay, ok := parseRouterLine(fields); ok {
+				current = &relay
+			}
+		case "a":
+			if current != nil && len(fields) > 1 {
+				current.IPv6Addresses = append(current.IPv6Addresses, fields[1])
+			}
+		case "s":
+			if current != nil {
+				current.Flags = append([]string(nil), fields[1:]...)
+			}
+		case "v":
+			if current != nil {
+				current.Version = strings.TrimSpace(strings.TrimPrefix(line, "v"))
+			}
+		case "w":
+			if current != nil {
+				parseBandwidthLine(current, fields[1:])
+			}
+		case "p":
+			if current != nil && len(fields) == 3 {
+				current.ExitPolicy = parseExitPolicySummary(fields[1], fields[2])
+			}
+		}
+	}
+	flush()
+	return relays
+}
+
+// parseRouterLine parses an "r" line. The ns flavour carries a descriptor
+// digest ("r nick id digest date time ip orport dirport") that the microdesc
+// flavour omits ("r nick id date time ip orport dirport").
+func parseRouterLine(fields []string) (Relay, bool) {
+	var rest []string
+	switch len(fields) {
+	case 9:
+		rest = fields[4:]
+	case 8:
+		rest = fields[3:]
+	default:
+		return Relay{}, false
+	}
+	fp, ok := identityToFingerprint(fields[2])
+	if !ok {
+		return Relay{}, false
+	}
+	relay := Relay{
+		Nickname:    fields[1],
+		Fingerprint: fp,
+		Address:     rest[2],
+	}
+	if published, err := time.Parse(time.DateTime, rest[0]+" "+rest[1]); err == nil {
+		relay.Published = published.UTC()
+	}
+	relay.ORPort = atoiOrZero(rest[3])
+	relay.DirPort = atoiOrZero(rest[4])
+	return relay, true
+}
+
+// identityToFingerprint converts a base64 identity digest into uppercase hex.
+func identityToFingerprint(identity string) (string, bool) {
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(identity, "="))
+	if err != nil || len(raw) != 20 {
+		return "", false
+	}
+	return strings.ToUpper(hex.EncodeToString(raw)), true
+}
+
+// parseBandwidthLine parses "w Bandwidth=N [Measured=N] [Unmeasured=1]".
+func parseBandwidthLine(relay *Relay, fields []string) {
+	relay.Measured = true
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Bandwidth":
+			if bw, err := strconv.ParseInt(value, 10, 64); err == nil {
+				relay.Bandwidth = bw
+			}
+		case "Unmeasured":
+			relay.Measured = value != "1"
+		}
+	}
+}
+
+// parseExitPolicySummary parses the action and port list of a "p" line.
+func parseExitPolicySummary(action, portList string) ExitPolicySummary {
+	summary := ExitPolicySummary{Accept: action == "accept"}
+	for _, item := range strings.Split(portList, ",") {
+		lo, hi, isRange := strings.Cut(item, "-")
+		minPort, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		maxPort := minPort
+		if isRange {
+			if maxPort, err = strconv.Atoi(hi); err != nil {
+				continue
+			}
+		}
+		summary.Ports = append(summary.Ports, PortRange{Min: minPort, Max: maxPort})
+	}
+	return summary
+}
+
+// applyMicrodescriptor copies the family and exit policy from a microdescriptor.
+func applyMicrodescriptor(relay *Relay, lines []string) {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "family":
+			relay.Family = append([]string(nil), fields[1:]...)
+		case "p":
+			if len(relay.ExitPolicy.Ports) == 0 && len(fields) == 3 {
+				relay.ExitPolicy = parseExitPolicySummary(fields[1], fields[2])
+			}
+		}
+	}
+}
+
+// parseServerDescriptor parses the fields of a server descriptor that are
+// useful to callers; signatures and keys are skipped.
+func parseServerDescriptor(lines []string) RelayDescriptor {
+	var desc RelayDescriptor
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "router":
+			if len(fields) >= 4 {
+				desc.Nickname = fields[1]
+				desc.Address = fields[2]
+				desc.ORPort = atoiOrZero(fields[3])
+			}
+		case "fingerprint":
+			desc.Fingerprint = strings.Join(fields[1:], "")
+		case "platform":
+			desc.Platform = strings.TrimSpace(strings.TrimPrefix(line, "platform"))
+		case "contact":
+			desc.Contact = strings.TrimSpace(strings.TrimPrefix(line, "contact"))
+		case "bandwidth":
+			values := make([]int64, 3)
+			for i := 0; i < 3 && i+1 < len(fields); i++ {
+				if v, err := strconv.ParseInt(fields[i+1], 10, 64); err == nil {
+					values[i] = v
+				}
+			}
+			desc.BandwidthAverage, desc.BandwidthBurst, desc.BandwidthObserved = values[0], values[1], values[2]
+		case "accept", "reject":
+			desc.ExitPolicy = append(desc.ExitPolicy, line)
+		case "family":
+			desc.Family = append([]string(nil), fields[1:]...)
+		}
+	}
+	return desc
+}
+
+// atoiOrZero parses a decimal integer, returning 0 for malformed input.
+func atoiOrZero(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,349 @@
+package tornago
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRelayFingerprintA = "000102030405060708090A0B0C0D0E0F10111213"
+	testRelayFingerprintB = "1415161718191A1B1C1D1E1F2021222324252627"
+)
+
+// testConsensus is a microdesc-flavoured consensus excerpt with two relays.
+const testConsensus = "r exitRelay AAECAwQFBgcICQoLDA0ODxAREhM 2025-01-02 03:04:05 192.0.2.1 9001 0\r\n" +
+	"a [2001:db8::1]:9001\r\n" +
+	"m sha256digest\r\n" +
+	"s Exit Fast Running Stable Valid\r\n" +
+	"v Tor 0.4.8.9\r\n" +
+	"pr Cons=1-2\r\n" +
+	"w Bandwidth=5000\r\n" +
+	"p accept 80,443,8000-8100\r\n" +
+	"r guardRelay FBUWFxgZGhscHR4fICEiIyQlJic 2025-01-02 03:04:05 198.51.100.7 443 80\r\n" +
+	"s Fast Guard Running Valid\r\n" +
+	"w Bandwidth=200 Unmeasured=1\r\n" +
+	"p reject 1-65535\r\n"
+
+func TestParseRouterStatus(t *testing.T) {
+	t.Run("should parse microdesc flavoured entries", func(t *testing.T) {
+		relays := parseRouterStatus(strings.Split(strings.ReplaceAll(testConsensus, "\r\n", "\n"), "\n"))
+		if len(relays) != 2 {
+			t.Fatalf("expected 2 relays, got %d", len(relays))
+		}
+
+		exit := relays[0]
+		if exit.Nickname != "exitRelay" || exit.Fingerprint != testRelayFingerprintA {
+			t.Errorf("unexpected identity: %s %s", exit.Nickname, exit.Fingerprint)
+		}
+		if exit.Address != "192.0.2.1" || exit.ORPort != 9001 || exit.DirPort != 0 {
+			t.Errorf("unexpected address: %s:%d dir %d", exit.Address, exit.ORPort, exit.DirPort)
+		}
+		if !exit.Published.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)) {
+			t.Errorf("unexpected published time: %v", exit.Published)
+		}
+		if len(exit.IPv6Addresses) != 1 || exit.IPv6Addresses[0] != "[2001:db8::1]:9001" {
+			t.Errorf("unexpected IPv6 addresses: %v", exit.IPv6Addresses)
+		}
+		if !exit.IsExit() || !exit.HasFlag("stable") {
+			t.Errorf("expected Exit and Stable flags, got %v", exit.Flags)
+		}
+		if exit.Version != "Tor 0.4.8.9" {
+			t.Errorf("unexpected version: %q", exit.Version)
+		}
+		if exit.Bandwidth != 5000 || !exit.Measured {
+			t.Errorf("unexpected bandwidth: %d measured=%v", exit.Bandwidth, exit.Measured)
+		}
+		if exit.ExitPolicy.String() != "accept 80,443,8000-8100" {
+			t.Errorf("unexpected exit policy: %q", exit.ExitPolicy.String())
+		}
+
+		guard := relays[1]
+		if guard.Fingerprint != testRelayFingerprintB || guard.DirPort != 80 {
+			t.Errorf("unexpected guard: %+v", guard)
+		}
+		if guard.Measured {
+			t.Error("expected guard bandwidth to be unmeasured")
+		}
+		if guard.IsExit() {
+			t.Error("guard should not be an exit")
+		}
+	})
+
+	t.Run("should parse ns flavoured entries with descriptor digest", func(t *testing.T) {
+		lines := []string{
+			"r nsRelay AAECAwQFBgcICQoLDA0ODxAREhM ZGlnZXN0ZGlnZXN0ZGlnZXN0 2025-01-02 03:04:05 203.0.113.9 9001 9030",
+			"s Running Valid",
+		}
+		relays := parseRouterStatus(lines)
+		if len(relays) != 1 {
+			t.Fatalf("expected 1 relay, got %d", len(relays))
+		}
+		if relays[0].Address != "203.0.113.9" || relays[0].DirPort != 9030 {
+			t.Errorf("unexpected relay: %+v", relays[0])
+		}
+	})
+
+	t.Run("should skip malformed router lines", func(t *testing.T) {
+		relays := parseRouterStatus([]string{"r broken", "s Running", "r bad !!! 2025-01-02 03:04:05 1.2.3.4 1 0"})
+		if len(relays) != 0 {
+			t.Errorf("expected no relays, got %d", len(relays))
+		}
+	})
+}
+
+func TestExitPolicySummary(t *testing.T) {
+	accept := parseExitPolicySummary("accept", "80,443,8000-8100")
+	reject := parseExitPolicySummary("reject", "25,119")
+
+	tests := []struct {
+		name   string
+		policy ExitPolicySummary
+		port   int
+		want   bool
+	}{
+		{"accept listed port", accept, 443, true},
+		{"accept port in range", accept, 8050, true},
+		{"accept unlisted port", accept, 22, false},
+		{"reject listed port", reject, 25, false},
+		{"reject unlisted port", reject, 443, true},
+		{"empty policy", ExitPolicySummary{}, 80, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.AllowsPort(tt.port); got != tt.want {
+				t.Errorf("AllowsPort(%d) = %v, want %v", tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"$" + testRelayFingerprintA, testRelayFingerprintA, false},
+		{strings.ToLower(testRelayFingerprintA) + "~nick", testRelayFingerprintA, false},
+		{"$" + testRelayFingerprintB + "=nick", testRelayFingerprintB, false},
+		{"ABC", "", true},
+		{strings.Repeat("Z", 40), "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeFingerprint(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeFingerprint(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeFingerprint(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseServerDescriptor(t *testing.T) {
+	lines := []string{
+		"router exitRelay 192.0.2.1 9001 0 0",
+		"platform Tor 0.4.8.9 on Linux",
+		"fingerprint 0001 0203 0405 0607 0809 0A0B 0C0D 0E0F 1011 1213",
+		"bandwidth 1000 2000 1500",
+		"family $" + testRelayFingerprintB,
+		"contact admin@example.org",
+		"accept *:80",
+		"reject *:*",
+	}
+	desc := parseServerDescriptor(lines)
+	if desc.Nickname != "exitRelay" || desc.ORPort != 9001 || desc.Fingerprint != testRelayFingerprintA {
+		t.Errorf("unexpected router fields: %+v", desc)
+	}
+	if desc.Platform != "Tor 0.4.8.9 on Linux" || desc.Contact != "admin@example.org" {
+		t.Errorf("unexpected platform/contact: %q %q", desc.Platform, desc.Contact)
+	}
+	if desc.BandwidthAverage != 1000 || desc.BandwidthBurst != 2000 || desc.BandwidthObserved != 1500 {
+		t.Errorf("unexpected bandwidth: %+v", desc)
+	}
+	if len(desc.ExitPolicy) != 2 || len(desc.Family) != 1 {
+		t.Errorf("unexpected policy/family: %v %v", desc.ExitPolicy, desc.Family)
+	}
+}
+
+func TestRelayFilters(t *testing.T) {
+	relays := []Relay{
+		{Nickname: "a", Flags: []string{"Exit", "Fast"}, Bandwidth: 100, Country: "de", ExitPolicy: parseExitPolicySummary("accept", "443")},
+		{Nickname: "b", Flags: []string{"Exit", "BadExit"}, Bandwidth: 900, Country: "nl", ExitPolicy: parseExitPolicySummary("accept", "443")},
+		{Nickname: "c", Flags: []string{"Guard", "Fast"}, Bandwidth: 500, Country: "us"},
+	}
+
+	t.Run("should filter by flag", func(t *testing.T) {
+		got := FilterRelays(relays, RelayHasFlag(RelayFlagFast))
+		if len(got) != 2 || got[0].Nickname != "a" || got[1].Nickname != "c" {
+			t.Errorf("unexpected result: %+v", got)
+		}
+	})
+
+	t.Run("should filter by country and bandwidth", func(t *testing.T) {
+		got := FilterRelays(relays, RelayInCountry("{DE}", "nl"), RelayMinBandwidth(500))
+		if len(got) != 1 || got[0].Nickname != "b" {
+			t.Errorf("unexpected result: %+v", got)
+		}
+	})
+
+	t.Run("should exclude bad exits when matching exit port", func(t *testing.T) {
+		got := FilterRelays(relays, RelayAllowsExitPort(443))
+		if len(got) != 1 || got[0].Nickname != "a" {
+			t.Errorf("unexpected result: %+v", got)
+		}
+	})
+
+	t.Run("should sort by bandwidth", func(t *testing.T) {
+		sorted := append([]Relay(nil), relays...)
+		SortRelaysByBandwidth(sorted)
+		if sorted[0].Nickname != "b" || sorted[2].Nickname != "a" {
+			t.Errorf("unexpected order: %s %s %s", sorted[0].Nickname, sorted[1].Nickname, sorted[2].Nickname)
+		}
+	})
+}
+
+func TestRelays(t *testing.T) {
+	t.Run("should list relays with countries", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			switch {
+			case cmd == "GETINFO ns/all":
+				return "250+ns/all=\r\n" + testConsensus + ".\r\n250 OK\r\n"
+			case strings.HasPrefix(cmd, "GETINFO ip-to-country/"):
+				return "250-ip-to-country/192.0.2.1=DE\r\n250-ip-to-country/198.51.100.7=??\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		relays, err := client.Relays(context.Background())
+		if err != nil {
+			t.Fatalf("Relays failed: %v", err)
+		}
+		if len(relays) != 2 {
+			t.Fatalf("expected 2 relays, got %d", len(relays))
+		}
+		if relays[0].Country != "de" {
+			t.Errorf("expected country de, got %q", relays[0].Country)
+		}
+		if relays[1].Country != "" {
+			t.Errorf("expected unknown country, got %q", relays[1].Country)
+		}
+	})
+
+	t.Run("should tolerate missing GeoIP data", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			switch {
+			case cmd == "GETINFO ns/all":
+				return "250+ns/all=\r\n" + testConsensus + ".\r\n250 OK\r\n"
+			case strings.HasPrefix(cmd, "GETINFO ip-to-country/"):
+				return "551 GeoIP data not loaded\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		relays, err := client.Relays(context.Background())
+		if err != nil {
+			t.Fatalf("Relays failed: %v", err)
+		}
+		if len(relays) != 2 || relays[0].Country != "" {
+			t.Errorf("unexpected relays: %+v", relays)
+		}
+	})
+}
+
+func TestRelay(t *testing.T) {
+	t.Run("should fetch a relay with microdescriptor family", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			switch cmd {
+			case "GETINFO ns/id/" + testRelayFingerprintA:
+				entry := strings.SplitAfter(testConsensus, "p accept 80,443,8000-8100\r\n")[0]
+				return "250+ns/id/" + testRelayFingerprintA + "=\r\n" + entry + ".\r\n250 OK\r\n"
+			case "GETINFO md/id/" + testRelayFingerprintA:
+				return "250+md/id/" + testRelayFingerprintA + "=\r\nonion-key\r\nfamily $" + testRelayFingerprintB + "\r\n.\r\n250 OK\r\n"
+			case "GETINFO ip-to-country/192.0.2.1":
+				return "250-ip-to-country/192.0.2.1=nl\r\n250 OK\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		relay, err := client.Relay(context.Background(), "$"+testRelayFingerprintA+"~exitRelay")
+		if err != nil {
+			t.Fatalf("Relay failed: %v", err)
+		}
+		if relay.Nickname != "exitRelay" || relay.Country != "nl" {
+			t.Errorf("unexpected relay: %+v", relay)
+		}
+		if len(relay.Family) != 1 || relay.Family[0] != "$"+testRelayFingerprintB {
+			t.Errorf("unexpected family: %v", relay.Family)
+		}
+	})
+
+	t.Run("should reject invalid fingerprint", func(t *testing.T) {
+		client := &ControlClient{authenticated: true}
+		if _, err := client.Relay(context.Background(), "nope"); err == nil {
+			t.Error("expected error for invalid fingerprint")
+		}
+	})
+
+	t.Run("should report unknown relay", func(t *testing.T) {
+		addr := startMockControlServer(t, func(cmd string) string {
+			if strings.HasPrefix(cmd, "GETINFO ns/id/") {
+				return "552 Unrecognized key \"ns/id/" + testRelayFingerprintB + "\"\r\n"
+			}
+			return ""
+		})
+		client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		if _, err := client.Relay(context.Background(), testRelayFingerprintB); err == nil {
+			t.Error("expected error for unknown relay")
+		}
+	})
+}
+
+func TestRelayDescriptor(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		if cmd == "GETINFO desc/id/"+testRelayFingerprintA {
+			return "250+desc/id/" + testRelayFingerprintA + "=\r\n" +
+				"router exitRelay 192.0.2.1 9001 0 0\r\n" +
+				"bandwidth 1 2 3\r\n" +
+				"reject *:*\r\n" +
+				".\r\n250 OK\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	desc, err := client.RelayDescriptor(context.Background(), testRelayFingerprintA)
+	if err != nil {
+		t.Fatalf("RelayDescriptor failed: %v", err)
+	}
+	if desc.Fingerprint != testRelayFingerprintA || desc.BandwidthObserved != 3 {
+		t.Errorf("unexpected descriptor: %+v", desc)
+	}
+}
@@ -0,0 +1,378 @@
+package tornago
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// RequestOutcome describes a finished request made through a Client. Outcomes
+// are delivered to MetricsCollector observers and drive RotationPolicy
+// decisions.
+type RequestOutcome struct {
+	// Target is the URL host of an HTTP request or the address of a dial.
+	Target string
+	// StatusCode is the HTTP status code, or 0 for dials and failed requests.
+	StatusCode int
+	// Err is the error returned by the request, or nil on success.
+	Err error
+	// Bytes is the number of HTTP body bytes sent and received. Raw dials
+	// report 0 because their traffic is not observed.
+	Bytes int64
+	// Latency is the time until the response headers arrived or the dial
+	// completed.
+	Latency time.Duration
+}
+
+// RotationPolicy decides when CircuitManager should rotate circuits. Policies
+// are consulted under the manager's lock, so implementations need not be
+// safe for concurrent use.
+type RotationPolicy interface {
+	// Observe records a request outcome and reports whether circuits should
+	// be rotated now.
+	Observe(outcome RequestOutcome) bool
+	// NextRotation returns when a time-based rotation is due, or the zero
+	// time if the policy does not rotate on a schedule.
+	NextRotation(now time.Time) time.Time
+	// Reset clears the policy state after circuits were rotated at now.
+	Reset(now time.Time)
+}
+
+// requestCountPolicy rotates after a fixed number of requests.
+type requestCountPolicy struct {
+	// limit is the number of requests per identity.
+	limit int
+	// count is the number of requests since the last rotation.
+	count int
+}
+
+// RequestCountPolicy rotates circuits after every n requests, successful or
+// not. Values below 1 are treated as 1.
+func RequestCountPolicy(n int) RotationPolicy {
+	return &requestCountPolicy{limit: max(n, 1)}
+}
+
+// Observe implements RotationPolicy.
+func (p *requestCountPolicy) Observe(RequestOutcome) bool {
+	p.count++
+	return p.count >= p.limit
+}
+
+// NextRotation implements RotationPolicy.
+func (p *requestCountPolicy) NextRotation(time.Time) time.Time { return time.Time{} }
+
+// Reset implements RotationPolicy.
+func (p *requestCountPolicy) Reset(time.Time) { p.count = 0 }
+
+// consecutiveErrorPolicy rotates after a run of matching errors.
+type consecutiveErrorPolicy struct {
+	// limit is the length of the error run that triggers a rotation.
+	limit int
+	// kinds restricts which errors count; empty means all errors.
+	kinds []ErrorKind
+	// count is the current run length.
+	count int
+}
+
+// ConsecutiveErrorPolicy rotates circuits after n consecutive failed requests.
+// When kinds are given, only errors of those kinds extend the run and other
+// outcomes end it. Values of n below 1 are treated as 1.
+//
+// Example:
+//
+//	// Rotate after three dial failures in a row.
+//	policy := tornago.ConsecutiveErrorPolicy(3, tornago.ErrSocksDialFailed)
+func ConsecutiveErrorPolicy(n int, kinds ...ErrorKind) RotationPolicy {
+	return &consecutiveErrorPolicy{limit: max(n, 1), kinds: slices.Clone(kinds)}
+}
+
+// Observe implements RotationPolicy.
+func (p *consecutiveErrorPolicy) Observe(outcome RequestOutcome) bool {
+	if !p.matches(outcome.Err) {
+		p.count = 0
+		return false
+	}
+	p.count++
+	return p.count >= p.limit
+}
+
+// matches reports whether err extends the error run.
+func (p *consecutiveErrorPolicy) matches(err error) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.kinds) == 0 {
+		return true
+	}
+	var torErr *TornagoError
+	if !As(err, &torErr) {
+		return false
+	}
+	return slices.Contains(p.kinds, torErr.Kind)
+}
+
+// NextRotation implements RotationPolicy.
+func (p *consecutiveErrorPolicy) NextRotation(time.Time) time.Time { return time.Time{} }
+
+// Reset implements RotationPolicy.
+func (p *consecutiveErrorPolicy) Reset(time.Time) { p.count = 0 }
+
+// statusCodePolicy rotates when a response carries one of the given codes.
+type statusCodePolicy struct {
+	// codes lists the HTTP status codes that trigger a rotation.
+	codes []int
+}
+
+// StatusCodePolicy rotates circuits as soon as a response carries one of the
+// given HTTP status codes, typically 403 and 429 from sites that block or
+// throttle an exit. Without codes it uses 403 and 429.
+func StatusCodePolicy(codes ...int) RotationPolicy {
+	if len(codes) == 0 {
+		codes = []int{403, 429}
+	}
+	return &statusCodePolicy{codes: slices.Clone(codes)}
+}
+
+// Observe implements RotationPolicy.
+func (p *statusCodePolicy) Observe(outcome RequestOutcome) bool {
+	return outcome.StatusCode != 0 && slices.Contains(p.codes, outcome.StatusCode)
+}
+
+// NextRotation implements RotationPolicy.
+func (p *statusCodePolicy) NextRotation(time.Time) time.Time { return time.Time{} }
+
+// Reset implements RotationPolicy.
+func (p *statusCodePolicy) Reset(time.Time) {}
+
+// byteBudgetPolicy rotates after a number of transferred bytes.
+type byteBudgetPolicy struct {
+	// budget is the number of bytes per identity.
+	budget int64
+	// used is the number of bytes since the last rotation.
+	used int64
+}
+
+// ByteBudgetPolicy rotates circuits once the HTTP bodies transferred since the
+// last rotation reach budget bytes. Values below 1 are treated as 1.
+func ByteBudgetPolicy(budget int64) RotationPolicy {
+	return &byteBudgetPolicy{budget: max(budget, 1)}
+}
+
+// Observe implements RotationPolicy.
+func (p *byteBudgetPolicy) Observe(outcome RequestOutcome) bool {
+	p.used += outcome.Bytes
+	return p.used >= p.budget
+}
+
+// NextRotation implements RotationPolicy.
+func (p *byteBudgetPolicy) NextRotation(time.Time) time.Time { return time.Time{} }
+
+// Reset implements RotationPolicy.
+func (p *byteBudgetPolicy) Reset(time.Time) { p.used = 0 }
+
+// schedulePolicy rotates on a jittered timer.
+type schedulePolicy struct {
+	// interval is the mean time between rotations.
+	interval time.Duration
+	// jitter is the maximum deviation from interval in either direction.
+	jitter time.Duration
+	// next is when the next rotation is due; zero until first scheduled.
+	next time.Time
+}
+
+// JitteredSchedulePolicy rotates circuits every interval, shifted by a random
+// amount of up to jitter in either direction so rotations do not form an
+// observable pattern. Intervals below the Tor NEWNYM rate limit (10s) are
+// raised to it.
+func JitteredSchedulePolicy(interval, jitter time.Duration) RotationPolicy {
+	interval = max(interval, newnymRateLimit)
+	jitter = min(max(jitter, 0), interval-newnymRateLimit)
+	return &schedulePolicy{interval: interval, jitter: jitter}
+}
+
+// Observe implements RotationPolicy.
+func (p *schedulePolicy) Observe(RequestOutcome) bool { return false }
+
+// NextRotation implements RotationPolicy.
+func (p *schedulePolicy) NextRotation(now time.Time) time.Time {
+	if p.next.IsZero() {
+		p.schedule(now)
+	}
+	return p.next
+}
+
+// Reset implements RotationPolicy.
+func (p *schedulePolicy) Reset(now time.Time) { p.schedule(now) }
+
+// schedule picks the next rotation time relative to now.
+func (p *schedulePolicy) schedule(now time.Time) {
+	offset := time.Duration(0)
+	if p.jitter > 0 {
+		offset = time.Duration(rand.Int64N(int64(2*p.jitter)+1)) - p.jitter //nolint:gosec // jitter does not need a cryptographic source
+	}
+	p.next = now.Add(p.interval + offset)
+}
+
+// StartPolicyRotation rotates circuits whenever one of policies asks for it.
+// Request outcomes are taken from metrics, so pass the MetricsCollector
+// configured on the Client (WithClientMetrics); outcomes can also be fed
+// manually with Observe. Policies with a schedule are driven by a timer.
+//
+// Rotations respect Tor's NEWNYM rate limit: a trigger that arrives too soon
+// is carried out once the limit allows. All policies are reset after each
+// rotation. Like StartAutoRotation, it runs until Stop is called or ctx is
+// canceled, and only one of the two can be active at a time.
+//
+// Example:
+//
+//	metrics := tornago.NewMetricsCollector()
+//	cfg, _ := tornago.NewClientConfig(tornago.WithClientMetrics(metrics), ...)
+//	client, _ := tornago.NewClient(cfg)
+//	manager := tornago.NewCircuitManager(client.Control())
+//	_ = manager.StartPolicyRotation(ctx, metrics,
+//	    tornago.StatusCodePolicy(403, 429),
+//	    tornago.RequestCountPolicy(100),
+//	)
+func (m *CircuitManager) StartPolicyRotation(ctx context.Context, metrics *MetricsCollector, policies ...RotationPolicy) error {
+	policies = slices.DeleteFunc(slices.Clone(policies), func(p RotationPolicy) bool { return p == nil })
+	if len(policies) == 0 {
+		return newError(ErrInvalidConfig, opCircuitManager, "at least one rotation policy is required", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return newError(ErrInvalidConfig, opCircuitManager, "auto-rotation already running", nil)
+	}
+	m.running = true
+	m.rotationInterval = 0
+	m.policies = policies
+	m.rotateCh = make(chan struct{}, 1)
+	now := time.Now()
+	for _, p := range policies {
+		p.Reset(now)
+	}
+
+	var removeObserver func()
+	if metrics != nil {
+		removeObserver = metrics.OnRequest(m.Observe)
+	}
+
+	m.logger.Log("info", "starting policy-driven rotation", "policies", len(policies))
+	go m.policyRotateLoop(ctx, m.rotateCh, removeObserver)
+	return nil
+}
+
+// Observe feeds a request outcome to the policies registered with
+// StartPolicyRotation and schedules a rotation if any of them asks for one.
+// It is a no-op when policy-driven rotation is not running.
+func (m *CircuitManager) Observe(outcome RequestOutcome) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rotateCh == nil {
+		return
+	}
+	rotate := false
+	for _, p := range m.policies {
+		if p.Observe(outcome) {
+			rotate = true
+		}
+	}
+	if rotate {
+		select {
+		case m.rotateCh <- struct{}{}:
+		default:
+			// A rotation is already pending.
+		}
+	}
+}
+
+// nextScheduledRotation returns the earliest rotation time requested by the
+// scheduled policies, or the zero time if there is none.
+func (m *CircuitManager) nextScheduledRotation(now time.Time) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var next time.Time
+	for _, p := range m.policies {
+		if t := p.NextRotation(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// policyRotateLoop performs rotations requested by policies.
+func (m *CircuitManager) policyRotateLoop(ctx context.Context, rotateCh chan struct{}, removeObserver func()) {
+	defer func() {
+		if removeObserver != nil {
+			removeObserver()
+		}
+		m.mu.Lock()
+		m.running = false
+		m.policies = nil
+		m.rotateCh = nil
+		m.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		wait := time.Hour
+		if next := m.nextScheduledRotation(time.Now()); !next.IsZero() {
+			wait = max(time.Until(next), 0)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			m.logger.Log("info", "policy-driven rotation stopped", "reason", "context canceled")
+			return
+		case <-m.stopCh:
+			m.logger.Log("info", "policy-driven rotation stopped", "reason", "stop requested")
+			return
+		case <-rotateCh:
+		case <-timer.C:
+			next := m.nextScheduledRotation(time.Now())
+			if next.IsZero() || time.Now().Before(next) {
+				continue
+			}
+		}
+
+		if delay := m.newnymDelay(); delay > 0 {
+			m.logger.Log("debug", "delaying policy rotation for NEWNYM rate limit", "delay", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.stopCh:
+				return
+			case <-time.After(delay):
+			}
+		}
+		if err := m.newnym(ctx); err != nil {
+			m.logger.Log("error", "policy-driven rotation failed", "error", err)
+			// Back off so a persistent failure does not spin on due schedules.
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.stopCh:
+				return
+			case <-time.After(newnymRateLimit):
+			}
+			continue
+		}
+		m.logger.Log("info", "circuits rotated by policy")
+
+		m.mu.Lock()
+		now := time.Now()
+		for _, p := range m.policies {
+			p.Reset(now)
+		}
+		// Drop triggers that arrived while rotating; they refer to the old identity.
+		select {
+		case <-rotateCh:
+		default:
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,251 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotationPolicies(t *testing.T) {
+	ok := RequestOutcome{StatusCode: http.StatusOK}
+	dialErr := RequestOutcome{Err: newError(ErrSocksDialFailed, opClient, "dial failed", nil)}
+	httpErr := RequestOutcome{Err: newError(ErrHTTPFailed, opClient, "http failed", nil)}
+
+	t.Run("should rotate after N requests", func(t *testing.T) {
+		p := RequestCountPolicy(3)
+		if p.Observe(ok) || p.Observe(dialErr) {
+			t.Fatal("rotated too early")
+		}
+		if !p.Observe(ok) {
+			t.Fatal("expected rotation on third request")
+		}
+		p.Reset(time.Now())
+		if p.Observe(ok) {
+			t.Error("expected counter to reset")
+		}
+	})
+
+	t.Run("should rotate after consecutive errors of selected kinds", func(t *testing.T) {
+		p := ConsecutiveErrorPolicy(2, ErrSocksDialFailed)
+		if p.Observe(dialErr) {
+			t.Fatal("rotated after a single error")
+		}
+		if p.Observe(httpErr) {
+			t.Fatal("unselected error kind should end the run")
+		}
+		if p.Observe(dialErr) || !p.Observe(dialErr) {
+			t.Fatal("expected rotation after two dial errors in a row")
+		}
+	})
+
+	t.Run("should count any error without kinds", func(t *testing.T) {
+		p := ConsecutiveErrorPolicy(2)
+		if p.Observe(httpErr) || !p.Observe(RequestOutcome{Err: errors.New("plain")}) {
+			t.Fatal("expected rotation after two errors")
+		}
+		p.Reset(time.Now())
+		if p.Observe(ok) {
+			t.Error("success must not trigger rotation")
+		}
+	})
+
+	t.Run("should rotate on status codes", func(t *testing.T) {
+		p := StatusCodePolicy()
+		if p.Observe(ok) {
+			t.Fatal("200 must not trigger rotation")
+		}
+		if !p.Observe(RequestOutcome{StatusCode: http.StatusTooManyRequests}) {
+			t.Fatal("expected 429 to trigger rotation")
+		}
+		if !StatusCodePolicy(503).Observe(RequestOutcome{StatusCode: 503}) {
+			t.Fatal("expected custom code to trigger rotation")
+		}
+	})
+
+	t.Run("should rotate after byte budget", func(t *testing.T) {
+		p := ByteBudgetPolicy(1000)
+		if p.Observe(RequestOutcome{Bytes: 600}) {
+			t.Fatal("rotated before budget was spent")
+		}
+		if !p.Observe(RequestOutcome{Bytes: 400}) {
+			t.Fatal("expected rotation once budget is spent")
+		}
+	})
+
+	t.Run("should schedule with jitter", func(t *testing.T) {
+		p := JitteredSchedulePolicy(time.Minute, 10*time.Second)
+		now := time.Now()
+		p.Reset(now)
+		next := p.NextRotation(now)
+		if next.Before(now.Add(50*time.Second)) || next.After(now.Add(70*time.Second)) {
+			t.Errorf("next rotation %v outside jitter window", next.Sub(now))
+		}
+		if p.Observe(ok) {
+			t.Error("schedule must not react to requests")
+		}
+		if RequestCountPolicy(1).NextRotation(now) != (time.Time{}) {
+			t.Error("request policy must not schedule rotations")
+		}
+	})
+
+	t.Run("should clamp schedules to the NEWNYM rate limit", func(t *testing.T) {
+		p := JitteredSchedulePolicy(time.Second, time.Hour)
+		now := time.Now()
+		if got := p.NextRotation(now).Sub(now); got != newnymRateLimit {
+			t.Errorf("expected %v, got %v", newnymRateLimit, got)
+		}
+	})
+}
+
+func TestStartPolicyRotation(t *testing.T) {
+	var mu sync.Mutex
+	newnyms := 0
+	addr := startMockControlServer(t, func(cmd string) string {
+		if cmd == "SIGNAL NEWNYM" {
+			mu.Lock()
+			newnyms++
+			mu.Unlock()
+		}
+		return ""
+	})
+	ctrl, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer ctrl.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return newnyms
+	}
+
+	t.Run("should rotate when metrics report a blocking status", func(t *testing.T) {
+		metrics := NewMetricsCollector()
+		manager := NewCircuitManager(ctrl)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := manager.StartPolicyRotation(ctx, metrics, StatusCodePolicy(403)); err != nil {
+			t.Fatalf("StartPolicyRotation failed: %v", err)
+		}
+		if err := manager.StartAutoRotation(ctx, time.Minute); err == nil {
+			t.Error("expected error when another rotation mode is active")
+		}
+
+		metrics.recordOutcome(RequestOutcome{StatusCode: http.StatusOK})
+		metrics.recordOutcome(RequestOutcome{StatusCode: http.StatusForbidden})
+		deadline := time.Now().Add(2 * time.Second)
+		for count() < 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if count() != 1 {
+			t.Fatalf("expected one rotation, got %d", count())
+		}
+
+		manager.Stop()
+		deadline = time.Now().Add(2 * time.Second)
+		for manager.IsRunning() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if manager.IsRunning() {
+			t.Error("expected manager to stop")
+		}
+		// Observers are removed once the loop exits.
+		manager.Observe(RequestOutcome{StatusCode: http.StatusForbidden})
+	})
+
+	t.Run("should run scheduled policies", func(t *testing.T) {
+		before := count()
+		manager := NewCircuitManager(ctrl)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		schedule := &schedulePolicy{interval: 50 * time.Millisecond}
+		if err := manager.StartPolicyRotation(ctx, nil, schedule); err != nil {
+			t.Fatalf("StartPolicyRotation failed: %v", err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for count() == before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if count() != before+1 {
+			t.Fatalf("expected scheduled rotation, got %d", count()-before)
+		}
+	})
+
+	t.Run("should require a policy", func(t *testing.T) {
+		if err := NewCircuitManager(ctrl).StartPolicyRotation(context.Background(), nil, nil); err == nil {
+			t.Error("expected error without policies")
+		}
+	})
+}
+
+func TestClientRequestOutcomes(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("slow down")) //nolint:errcheck
+	}))
+	defer testServer.Close()
+	mockSOCKS := createMockSOCKS5ServerWithForwarding(t, testServer.Listener.Addr().String())
+	defer mockSOCKS.Close()
+
+	metrics := NewMetricsCollector()
+	cfg, err := NewClientConfig(
+		WithClientSocksAddr(mockSOCKS.Addr().String()),
+		WithClientMetrics(metrics),
+		WithClientRequestTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("failed to create config: %v", err)
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	var mu sync.Mutex
+	var outcomes []RequestOutcome
+	remove := metrics.OnRequest(func(o RequestOutcome) {
+		mu.Lock()
+		defer mu.Unlock()
+		outcomes = append(outcomes, o)
+	})
+	defer remove()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, testServer.URL, strings.NewReader("12345"))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	mu.Lock()
+	if len(outcomes) != 0 {
+		t.Error("outcome must be reported when the body is closed")
+	}
+	mu.Unlock()
+	_ = resp.Body.Close()
+	_ = resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(outcomes) != 1 {
+		t.Fatalf("expected one outcome, got %d", len(outcomes))
+	}
+	got := outcomes[0]
+	if got.StatusCode != http.StatusTooManyRequests || got.Bytes != int64(len("12345")+len("slow down")) || got.Err != nil {
+		t.Errorf("unexpected outcome: %+v", got)
+	}
+	if got.Target != testServer.Listener.Addr().String() {
+		t.Errorf("unexpected target %q", got.Target)
+	}
+}
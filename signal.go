@@ -0,0 +1,96 @@
+package tornago
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Signal is a Tor control-port SIGNAL name. Each signal has the same effect
+// as sending the corresponding Unix signal to the tor process, but works on
+// every platform and over remote control connections.
+type Signal string
+
+const (
+	// SignalReload reloads torrc and reopens log files (equivalent to SIGHUP).
+	SignalReload Signal = "RELOAD"
+	// SignalShutdown shuts Tor down cleanly. Relays wait ShutdownWaitLength
+	// before exiting; clients exit immediately (equivalent to SIGINT).
+	SignalShutdown Signal = "SHUTDOWN"
+	// SignalHalt exits Tor immediately without cleanup (equivalent to SIGTERM).
+	SignalHalt Signal = "HALT"
+	// SignalDump logs information about open connections and circuits
+	// (equivalent to SIGUSR1).
+	SignalDump Signal = "DUMP"
+	// SignalDebug switches all open logs to loglevel debug (equivalent to SIGUSR2).
+	SignalDebug Signal = "DEBUG"
+	// SignalNewnym switches to clean circuits for new streams. See NewIdentity.
+	SignalNewnym Signal = "NEWNYM"
+	// SignalClearDNSCache forgets the client-side cached IPs for all hostnames.
+	SignalClearDNSCache Signal = "CLEARDNSCACHE"
+	// SignalHeartbeat makes Tor emit a heartbeat log message immediately.
+	SignalHeartbeat Signal = "HEARTBEAT"
+	// SignalDormant tells Tor to become dormant and stop building circuits.
+	SignalDormant Signal = "DORMANT"
+	// SignalActive wakes Tor from the dormant state.
+	SignalActive Signal = "ACTIVE"
+)
+
+// String returns the control-protocol name of the signal.
+func (s Signal) String() string { return string(s) }
+
+// valid reports whether s is a signal understood by Tor.
+func (s Signal) valid() bool {
+	switch s {
+	case SignalReload, SignalShutdown, SignalHalt, SignalDump, SignalDebug,
+		SignalNewnym, SignalClearDNSCache, SignalHeartbeat, SignalDormant, SignalActive:
+		return true
+	default:
+		return false
+	}
+}
+
+// Signal sends SIGNAL to Tor. Names are matched case-insensitively.
+//
+// SignalShutdown and SignalHalt make Tor close the control connection after
+// acknowledging the command, so the client is unusable afterwards.
+//
+// Example:
+//
+//	// Drop cached DNS answers after switching exits.
+//	err := ctrl.Signal(ctx, tornago.SignalClearDNSCache)
+func (c *ControlClient) Signal(ctx context.Context, sig Signal) error {
+	sig = Signal(strings.ToUpper(strings.TrimSpace(string(sig))))
+	if !sig.valid() {
+		return newError(ErrInvalidConfig, opControlClient, fmt.Sprintf("unknown signal %q", string(sig)), nil)
+	}
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "SIGNAL "+string(sig))
+	return err
+}
+
+// TakeOwnership makes Tor exit when this control connection is closed,
+// including when the controlling process dies unexpectedly. It is useful
+// for daemons launched on behalf of the application that must not outlive it.
+//
+// Tor also honours __OwningControllerProcess; both mechanisms may be used
+// together.
+func (c *ControlClient) TakeOwnership(ctx context.Context) error {
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "TAKEOWNERSHIP")
+	return err
+}
+
+// DropOwnership releases ownership taken by TakeOwnership so Tor keeps
+// running after this control connection closes.
+func (c *ControlClient) DropOwnership(ctx context.Context) error {
+	if err := c.ensureAuthenticated(); err != nil {
+		return err
+	}
+	_, err := c.execCommand(ctx, "DROPOWNERSHIP")
+	return err
+}
@@ -0,0 +1,80 @@
+package tornago
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignal(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	addr := startMockControlServer(t, func(cmd string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(cmd, "AUTHENTICATE") {
+			return ""
+		}
+		commands = append(commands, cmd)
+		if cmd == "SIGNAL DORMANT" {
+			return "552 Unrecognized signal code \"DORMANT\"\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	t.Run("should send typed signals", func(t *testing.T) {
+		for _, sig := range []Signal{SignalReload, SignalClearDNSCache, Signal("heartbeat")} {
+			if err := client.Signal(ctx, sig); err != nil {
+				t.Fatalf("Signal(%s) failed: %v", sig, err)
+			}
+		}
+		if err := client.NewIdentity(ctx); err != nil {
+			t.Fatalf("NewIdentity failed: %v", err)
+		}
+	})
+
+	t.Run("should reject unknown signals locally", func(t *testing.T) {
+		if err := client.Signal(ctx, Signal("REBOOT")); err == nil {
+			t.Error("expected error for unknown signal")
+		}
+	})
+
+	t.Run("should surface Tor errors", func(t *testing.T) {
+		if err := client.Signal(ctx, SignalDormant); err == nil {
+			t.Error("expected error from Tor reply")
+		}
+	})
+
+	t.Run("should take and drop ownership", func(t *testing.T) {
+		if err := client.TakeOwnership(ctx); err != nil {
+			t.Fatalf("TakeOwnership failed: %v", err)
+		}
+		if err := client.DropOwnership(ctx); err != nil {
+			t.Fatalf("DropOwnership failed: %v", err)
+		}
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"SIGNAL RELOAD",
+		"SIGNAL CLEARDNSCACHE",
+		"SIGNAL HEARTBEAT",
+		"SIGNAL NEWNYM",
+		"SIGNAL DORMANT",
+		"TAKEOWNERSHIP",
+		"DROPOWNERSHIP",
+	}
+	if !slices.Equal(commands, want) {
+		t.Errorf("unexpected commands:\n got %q\nwant %q", commands, want)
+	}
+}
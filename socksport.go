@@ -0,0 +1,125 @@
+package tornago
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultSocksPort names the SocksPort set with WithTorSocksAddr in
+// TorProcess.SocksPorts.
+const DefaultSocksPort = "default"
+
+// SocksPortFlag is a SocksPort isolation or policy flag.
+type SocksPortFlag string
+
+// SocksPort flags supported by WithTorSocksPort and WithTorSocksFlags.
+const (
+	// SocksIsolateDestAddr uses separate circuits for different destination addresses.
+	SocksIsolateDestAddr SocksPortFlag = "IsolateDestAddr"
+	// SocksIsolateDestPort uses separate circuits for different destination ports.
+	SocksIsolateDestPort SocksPortFlag = "IsolateDestPort"
+	// SocksIsolateClientProtocol uses separate circuits for SOCKS4, SOCKS4a and SOCKS5 clients.
+	SocksIsolateClientProtocol SocksPortFlag = "IsolateClientProtocol"
+	// SocksIsolateSOCKSAuth uses separate circuits per SOCKS username/password (on by default in Tor).
+	SocksIsolateSOCKSAuth SocksPortFlag = "IsolateSOCKSAuth"
+	// SocksKeepAliveIsolateSOCKSAuth extends circuit lifetime while streams with the same SOCKS credentials keep using it.
+	SocksKeepAliveIsolateSOCKSAuth SocksPortFlag = "KeepAliveIsolateSOCKSAuth"
+	// SocksOnionTrafficOnly refuses every destination except onion services.
+	SocksOnionTrafficOnly SocksPortFlag = "OnionTrafficOnly"
+	// SocksNoDNSRequest refuses hostname resolution; only IP addresses and onion addresses work.
+	SocksNoDNSRequest SocksPortFlag = "NoDNSRequest"
+	// SocksPreferIPv6 prefers IPv6 when exits resolve a hostname to both families.
+	SocksPreferIPv6 SocksPortFlag = "PreferIPv6"
+)
+
+// knownSocksPortFlags lists the flags accepted by validation.
+var knownSocksPortFlags = []SocksPortFlag{
+	SocksIsolateDestAddr, SocksIsolateDestPort, SocksIsolateClientProtocol,
+	SocksIsolateSOCKSAuth, SocksKeepAliveIsolateSOCKSAuth, SocksOnionTrafficOnly,
+	SocksNoDNSRequest, SocksPreferIPv6,
+}
+
+// SocksPort is an additional named SOCKS listener of a launched tor.
+type SocksPort struct {
+	// Name identifies the listener in TorProcess.SocksPorts.
+	Name string
+	// Addr is the listen address; ":0" lets Tor pick a free port.
+	Addr string
+	// Flags are the isolation and policy flags of the listener.
+	Flags []SocksPortFlag
+}
+
+// socksPortArg renders a SocksPort value such as "127.0.0.1:auto OnionTrafficOnly".
+func socksPortArg(addr string, flags []SocksPortFlag) string {
+	parts := []string{addr}
+	for _, f := range flags {
+		parts = append(parts, string(f))
+	}
+	return strings.Join(parts, " ")
+}
+
+// validateSocksPorts checks the flags and names of the configured SocksPorts.
+func validateSocksPorts(cfg TorLaunchConfig) error {
+	if cfg.torConfigFile != "" && (len(cfg.socksPorts) > 0 || len(cfg.socksFlags) > 0) {
+		return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+			"WithTorSocksPort and WithTorSocksFlags cannot be combined with WithTorConfigFile. Declare the SocksPorts in the torrc file instead", nil)
+	}
+	if err := validateSocksFlags(cfg.socksFlags); err != nil {
+		return err
+	}
+	names := []string{DefaultSocksPort}
+	for _, p := range cfg.socksPorts {
+		if p.Name == "" || slices.Contains(names, p.Name) {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				fmt.Sprintf("SocksPort name %q is empty or already used", p.Name), nil)
+		}
+		names = append(names, p.Name)
+		if _, _, err := listenArg(p.Addr); err != nil {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig", fmt.Sprintf("invalid address for SocksPort %q", p.Name), err)
+		}
+		if err := validateSocksFlags(p.Flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSocksFlags rejects unknown and repeated flags.
+func validateSocksFlags(flags []SocksPortFlag) error {
+	for i, f := range flags {
+		if !slices.Contains(knownSocksPortFlags, f) {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig", fmt.Sprintf("unknown SocksPort flag %q", f), nil)
+		}
+		if slices.Contains(flags[:i], f) {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig", fmt.Sprintf("repeated SocksPort flag %q", f), nil)
+		}
+	}
+	return nil
+}
+
+// assignSocksListeners fills the empty ("auto") entries of configured with
+// the SocksPort addresses tor reported. Tor opens listeners in configuration
+// order, so after removing the explicitly configured addresses the remaining
+// listeners are assigned in order. It reports false until tor lists enough
+// listeners.
+func assignSocksListeners(configured, listeners []string) bool {
+	remaining := slices.DeleteFunc(slices.Clone(listeners), func(l string) bool {
+		return strings.HasPrefix(l, "unix:") || slices.Contains(configured, l)
+	})
+	missing := 0
+	for _, addr := range configured {
+		if addr == "" {
+			missing++
+		}
+	}
+	if len(remaining) < missing {
+		return false
+	}
+	for i, addr := range configured {
+		if addr == "" {
+			configured[i], remaining = remaining[0], remaining[1:]
+		}
+	}
+	return true
+}
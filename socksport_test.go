@@ -0,0 +1,109 @@
+package tornago
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSocksPortOptions(t *testing.T) {
+	t.Run("should keep named ports and flags", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig(
+			WithTorSocksFlags(SocksIsolateDestAddr),
+			WithTorSocksPort("onion-only", ":0", SocksOnionTrafficOnly, SocksNoDNSRequest),
+			WithTorSocksPort("isolated", "127.0.0.1:0", SocksIsolateSOCKSAuth, SocksKeepAliveIsolateSOCKSAuth),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		ports := cfg.SocksPorts()
+		if len(ports) != 2 || ports[0].Name != "onion-only" || !slices.Equal(ports[0].Flags, []SocksPortFlag{SocksOnionTrafficOnly, SocksNoDNSRequest}) {
+			t.Errorf("unexpected SocksPorts: %+v", ports)
+		}
+		ports[0].Flags[0] = SocksPreferIPv6
+		if cfg.SocksPorts()[0].Flags[0] != SocksOnionTrafficOnly {
+			t.Error("SocksPorts must return a copy")
+		}
+		if got := socksPortArg("127.0.0.1:auto", cfg.SocksFlags()); got != "127.0.0.1:auto IsolateDestAddr" {
+			t.Errorf("socksPortArg() = %q", got)
+		}
+	})
+
+	t.Run("should reject invalid ports", func(t *testing.T) {
+		tests := map[string][]TorLaunchOption{
+			"unknown flag":   {WithTorSocksFlags("IsolateEverything")},
+			"repeated flag":  {WithTorSocksPort("a", ":0", SocksPreferIPv6, SocksPreferIPv6)},
+			"empty name":     {WithTorSocksPort("", ":0")},
+			"default name":   {WithTorSocksPort(DefaultSocksPort, ":0")},
+			"duplicate name": {WithTorSocksPort("a", ":0"), WithTorSocksPort("a", ":0")},
+			"bad address":    {WithTorSocksPort("a", "nowhere")},
+			"torrc file":     {WithTorConfigFile("/etc/tor/torrc"), WithTorSocksPort("a", ":0")},
+		}
+		for name, opts := range tests {
+			if _, err := NewTorLaunchConfig(opts...); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
+
+func TestAssignSocksListeners(t *testing.T) {
+	t.Run("should assign auto ports in order around explicit ones", func(t *testing.T) {
+		configured := []string{"", "127.0.0.1:9150", ""}
+		listeners := []string{"127.0.0.1:40001", "127.0.0.1:9150", "unix:/tmp/socks", "127.0.0.1:40002"}
+		if !assignSocksListeners(configured, listeners) {
+			t.Fatal("expected assignment")
+		}
+		want := []string{"127.0.0.1:40001", "127.0.0.1:9150", "127.0.0.1:40002"}
+		if !slices.Equal(configured, want) {
+			t.Errorf("assigned %q, want %q", configured, want)
+		}
+	})
+
+	t.Run("should wait for missing listeners", func(t *testing.T) {
+		configured := []string{"", ""}
+		if assignSocksListeners(configured, []string{"127.0.0.1:40001"}) {
+			t.Error("expected no assignment")
+		}
+		if configured[0] != "" {
+			t.Error("configured must be unchanged")
+		}
+	})
+}
+
+func TestStartTorDaemonSocksPorts(t *testing.T) {
+	defaultAddr := startMockControlServer(t, func(string) string { return "" })
+	controlAddr := startMockControlServer(t, func(cmd string) string {
+		if cmd == "GETINFO net/listeners/socks" {
+			return "250-net/listeners/socks=\"" + defaultAddr + "\" \"127.0.0.1:9150\" \"127.0.0.1:40002\"\r\n250 OK\r\n"
+		}
+		return ""
+	})
+	cfg, err := NewTorLaunchConfig(
+		WithTorBinary(writePortFileTor(t, controlAddr)),
+		WithTorStartupTimeout(10*time.Second),
+		WithTorSocksPort("explicit", "127.0.0.1:9150", SocksIsolateDestPort),
+		WithTorSocksPort("onion-only", ":0", SocksOnionTrafficOnly),
+	)
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+	proc, err := StartTorDaemon(cfg)
+	if err != nil {
+		t.Fatalf("StartTorDaemon failed: %v", err)
+	}
+	defer proc.Stop()
+
+	want := map[string]string{DefaultSocksPort: defaultAddr, "explicit": "127.0.0.1:9150", "onion-only": "127.0.0.1:40002"}
+	for name, addr := range want {
+		if got, ok := proc.SocksPortAddr(name); !ok || got != addr {
+			t.Errorf("SocksPortAddr(%q) = %q, %v; want %q", name, got, ok, addr)
+		}
+	}
+	if proc.SocksAddr() != defaultAddr {
+		t.Errorf("SocksAddr() = %q", proc.SocksAddr())
+	}
+	if _, ok := proc.SocksPortAddr("missing"); ok {
+		t.Error("unexpected address for unknown name")
+	}
+}
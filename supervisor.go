@@ -0,0 +1,602 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// opTorSupervisor labels errors originating from TorSupervisor operations.
+	opTorSupervisor = "TorSupervisor"
+	// defaultSupervisorInitialBackoff is the delay before the first restart.
+	defaultSupervisorInitialBackoff = time.Second
+	// defaultSupervisorMaxBackoff caps the delay between restarts.
+	defaultSupervisorMaxBackoff = time.Minute
+	// supervisorPollInterval is how often bootstrap progress is polled.
+	supervisorPollInterval = time.Second
+	// supervisorEventBuffer is the capacity of the States channel.
+	supervisorEventBuffer = 16
+)
+
+// SupervisorState is the lifecycle state of a supervised Tor daemon.
+type SupervisorState string
+
+const (
+	// SupervisorStarting means tor is being launched.
+	SupervisorStarting SupervisorState = "starting"
+	// SupervisorBootstrapping means tor is running but not yet bootstrapped.
+	SupervisorBootstrapping SupervisorState = "bootstrapping"
+	// SupervisorReady means tor reported 100% bootstrap progress.
+	SupervisorReady SupervisorState = "ready"
+	// SupervisorCrashed means tor exited unexpectedly or failed to start; a
+	// restart follows unless the restart limit was reached.
+	SupervisorCrashed SupervisorState = "crashed"
+	// SupervisorStopped means the supervisor stopped and tor is not running.
+	SupervisorStopped SupervisorState = "stopped"
+)
+
+// SupervisorEvent reports a state transition of a TorSupervisor.
+type SupervisorEvent struct {
+	// State is the new state.
+	State SupervisorState
+	// Time is when the transition happened.
+	Time time.Time
+	// Restarts is the number of restarts performed so far.
+	Restarts int
+	// Err explains a SupervisorCrashed or abnormal SupervisorStopped state.
+	Err error
+}
+
+// SupervisorOption customizes a TorSupervisor.
+type SupervisorOption func(*supervisorOptions)
+
+// supervisorOptions holds TorSupervisor settings.
+type supervisorOptions struct {
+	// initialBackoff is the delay before the first restart after a crash.
+	initialBackoff time.Duration
+	// maxBackoff caps the doubling restart delay.
+	maxBackoff time.Duration
+	// maxRestarts bounds consecutive failed restarts; 0 means unlimited.
+	maxRestarts int
+}
+
+// WithSupervisorBackoff sets the delay before restarting a crashed tor. The
+// delay doubles after each consecutive failure up to max and resets once tor
+// becomes ready again. Defaults to 1s and 1m.
+func WithSupervisorBackoff(initial, maxDelay time.Duration) SupervisorOption {
+	return func(o *supervisorOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = maxDelay
+	}
+}
+
+// WithSupervisorMaxRestarts gives up after n consecutive restarts that did not
+// reach the ready state. Zero (the default) restarts forever.
+func WithSupervisorMaxRestarts(n int) SupervisorOption {
+	return func(o *supervisorOptions) {
+		o.maxRestarts = n
+	}
+}
+
+// TorSupervisor runs a Tor daemon and restarts it when it exits unexpectedly.
+// Restarts reuse the same DataDirectory and ports, so Tor keeps its guards,
+// cached consensus and onion service keys, and clients configured with
+// SocksAddr keep working. Ephemeral onion services and ControlPort
+// connections do not survive a restart; use OnRestart to recreate them.
+//
+// Example:
+//
+//	sup, _ := tornago.NewTorSupervisor(launchCfg)
+//	if err := sup.Start(ctx); err != nil {
+//	    return err
+//	}
+//	defer sup.Stop()
+//	go func() {
+//	    for ev := range sup.States() {
+//	        log.Printf("tor %s (restarts=%d, err=%v)", ev.State, ev.Restarts, ev.Err)
+//	    }
+//	}()
+//	_ = sup.WaitReady(ctx)
+type TorSupervisor struct {
+	// cfg is the launch config; ports and DataDir are pinned by Start.
+	cfg TorLaunchConfig
+	// opts holds restart settings.
+	opts supervisorOptions
+	// launch starts tor, aborting when ctx is done; StartTorDaemonContext
+	// outside of tests.
+	launch func(context.Context, TorLaunchConfig) (*TorProcess, error)
+	// bootstrap reports bootstrap progress of the tor watched by probe.
+	bootstrap func(context.Context, *bootstrapProbe) (int, error)
+	// ownsDataDir reports whether Stop removes the data directory.
+	ownsDataDir bool
+	// states delivers state transitions.
+	states chan SupervisorEvent
+	// stopCh is closed by Stop.
+	stopCh chan struct{}
+	// done is closed when the supervision loop exits.
+	done chan struct{}
+	// mu protects the fields below.
+	mu sync.Mutex
+	// state is the current state.
+	state SupervisorState
+	// proc is the current tor process.
+	proc *TorProcess
+	// restarts counts restarts since Start.
+	restarts int
+	// ready is closed while the state is SupervisorReady.
+	ready chan struct{}
+	// lastErr is the error of the last crash, reported by WaitReady after Stop.
+	lastErr error
+	// started reports whether Start was called.
+	started bool
+	// stopped reports whether Stop was called.
+	stopped bool
+	// cancel cancels the context of Start, aborting a launch in progress.
+	cancel context.CancelFunc
+	// onRestart holds callbacks run after a restarted tor becomes ready.
+	onRestart map[int]func(*TorProcess)
+	// nextHookID identifies onRestart callbacks.
+	nextHookID int
+}
+
+// NewTorSupervisor returns a supervisor for a Tor daemon launched with cfg.
+// Call Start to launch it.
+func NewTorSupervisor(cfg TorLaunchConfig, opts ...SupervisorOption) (*TorSupervisor, error) {
+	cfg, err := normalizeTorLaunchConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	o := supervisorOptions{
+		initialBackoff: defaultSupervisorInitialBackoff,
+		maxBackoff:     defaultSupervisorMaxBackoff,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	switch {
+	case o.initialBackoff <= 0 || o.maxBackoff < o.initialBackoff:
+		return nil, newError(ErrInvalidConfig, opTorSupervisor,
+			fmt.Sprintf("invalid restart backoff %v..%v", o.initialBackoff, o.maxBackoff), nil)
+	case o.maxRestarts < 0:
+		return nil, newError(ErrInvalidConfig, opTorSupervisor,
+			fmt.Sprintf("max restarts must not be negative, got %d", o.maxRestarts), nil)
+	}
+	return &TorSupervisor{
+		cfg:       cfg,
+		opts:      o,
+		launch:    StartTorDaemonContext,
+		bootstrap: bootstrapProgress,
+		states:    make(chan SupervisorEvent, supervisorEventBuffer),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+		ready:     make(chan struct{}),
+		onRestart: make(map[int]func(*TorProcess)),
+	}, nil
+}
+
+// Start pins the ports and DataDirectory, launches tor and begins supervising
+// it until Stop is called or ctx is canceled. An error is returned if the
+// first launch fails; later failures are retried with backoff. Stop or
+// canceling ctx aborts a launch in progress.
+func (s *TorSupervisor) Start(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return newError(ErrInvalidConfig, opTorSupervisor, "supervisor already started", nil)
+	}
+	if s.stopped {
+		s.mu.Unlock()
+		return newError(ErrInvalidConfig, opTorSupervisor, "supervisor already stopped", nil)
+	}
+	s.started = true
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	if err := s.pinConfig(); err != nil {
+		s.finish(err)
+		return err
+	}
+	s.setState(SupervisorStarting, nil)
+	proc, err := s.launch(ctx, s.cfg)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	s.mu.Lock()
+	s.proc = proc
+	// Keep the ports tor picked for "auto" so restarts reuse them.
+	s.cfg.socksAddr, s.cfg.controlAddr = proc.SocksAddr(), proc.ControlAddr()
+	if proc.HTTPTunnelAddr() != "" {
+		s.cfg.httpTunnelAddr = proc.HTTPTunnelAddr()
+	}
+	if proc.DNSAddr() != "" {
+		s.cfg.dnsAddr = proc.DNSAddr()
+	}
+	s.cfg.socksPorts = s.cfg.SocksPorts()
+	for i, p := range s.cfg.socksPorts {
+		if addr, ok := proc.SocksPortAddr(p.Name); ok {
+			s.cfg.socksPorts[i].Addr = addr
+		}
+	}
+	s.mu.Unlock()
+
+	go s.run(ctx, proc)
+	return nil
+}
+
+// pinConfig creates a DataDirectory when none was configured, so every restart
+// uses the same one. Ports are pinned after the first launch instead.
+func (s *TorSupervisor) pinConfig() error {
+	if s.cfg.dataDir == "" {
+		dir, err := os.MkdirTemp("", "tornago-tor-data-*")
+		if err != nil {
+			return newError(ErrIO, opTorSupervisor, "failed to create data directory", err)
+		}
+		s.cfg.dataDir = dir
+		s.ownsDataDir = true
+	} else {
+		s.cfg.dataDir = filepath.Clean(s.cfg.dataDir)
+	}
+	return nil
+}
+
+// run supervises proc and its replacements until stopped.
+func (s *TorSupervisor) run(ctx context.Context, proc *TorProcess) {
+	logger := s.cfg.Logger()
+	failures := 0
+	for {
+		err := s.watch(ctx, proc)
+		if err == nil {
+			s.finish(nil)
+			return
+		}
+		if s.readyClosed() {
+			failures = 0
+		}
+		s.setState(SupervisorCrashed, err)
+		logger.Log("error", "tor exited unexpectedly", "error", err)
+
+		for {
+			failures++
+			if s.opts.maxRestarts > 0 && failures > s.opts.maxRestarts {
+				s.finish(newError(ErrTorLaunchFailed, opTorSupervisor,
+					fmt.Sprintf("giving up after %d restarts", s.opts.maxRestarts), err))
+				return
+			}
+			delay := min(s.opts.initialBackoff<<min(failures-1, 30), s.opts.maxBackoff)
+			logger.Log("info", "restarting tor", "delay", delay, "attempt", failures)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				s.finish(nil)
+				return
+			case <-s.stopCh:
+				timer.Stop()
+				s.finish(nil)
+				return
+			case <-timer.C:
+			}
+
+			s.mu.Lock()
+			s.restarts++
+			s.mu.Unlock()
+			s.setState(SupervisorStarting, nil)
+			next, launchErr := s.launch(ctx, s.cfg)
+			if launchErr != nil {
+				if ctx.Err() != nil {
+					// Stop or the Start context aborted the launch.
+					s.finish(nil)
+					return
+				}
+				s.setState(SupervisorCrashed, launchErr)
+				logger.Log("error", "failed to restart tor", "error", launchErr)
+				err = launchErr
+				continue
+			}
+			s.mu.Lock()
+			s.proc = next
+			s.mu.Unlock()
+			proc = next
+			break
+		}
+	}
+}
+
+// watch waits for proc to bootstrap and then to exit. It returns nil when
+// supervision should end and the exit reason when tor crashed.
+func (s *TorSupervisor) watch(ctx context.Context, proc *TorProcess) error {
+	s.setState(SupervisorBootstrapping, nil)
+	ticker := time.NewTicker(supervisorPollInterval)
+	defer ticker.Stop()
+	probe := &bootstrapProbe{proc: proc}
+	defer probe.close()
+	bootstrapped := false
+	check := func() {
+		progress, err := s.bootstrap(ctx, probe)
+		if err == nil && progress >= 100 {
+			bootstrapped = true
+			// No more polls; do not hold the connection while tor runs.
+			probe.close()
+			s.setState(SupervisorReady, nil)
+			s.runRestartHooks(proc)
+		}
+	}
+	check()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.stopCh:
+			return nil
+		case <-proc.Done():
+			if exitErr := proc.ExitErr(); exitErr != nil {
+				return newError(ErrTorLaunchFailed, opTorSupervisor, "tor exited", exitErr)
+			}
+			return newError(ErrTorLaunchFailed, opTorSupervisor, "tor exited", nil)
+		case <-ticker.C:
+			if !bootstrapped {
+				check()
+			}
+		}
+	}
+}
+
+// runRestartHooks notifies OnRestart callbacks after a restart.
+func (s *TorSupervisor) runRestartHooks(proc *TorProcess) {
+	s.mu.Lock()
+	if s.restarts == 0 {
+		s.mu.Unlock()
+		return
+	}
+	hooks := make([]func(*TorProcess), 0, len(s.onRestart))
+	for _, fn := range s.onRestart {
+		hooks = append(hooks, fn)
+	}
+	s.mu.Unlock()
+	for _, fn := range hooks {
+		fn(proc)
+	}
+}
+
+// finish stops the current process, removes an owned DataDirectory and
+// reports the stopped state.
+func (s *TorSupervisor) finish(err error) {
+	s.mu.Lock()
+	proc := s.proc
+	s.proc = nil
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	var stopErr error
+	if proc != nil {
+		stopErr = proc.Stop()
+	}
+	if s.ownsDataDir && s.cfg.dataDir != "" {
+		if rmErr := os.RemoveAll(s.cfg.dataDir); rmErr != nil {
+			stopErr = errors.Join(stopErr, rmErr)
+		}
+	}
+	if stopErr != nil {
+		s.cfg.Logger().Log("error", "failed to stop supervised tor", "error", stopErr)
+	}
+	s.setState(SupervisorStopped, err)
+	close(s.done)
+}
+
+// setState records and publishes a state transition. When the States
+// channel is full the oldest event is dropped so the loop never blocks.
+func (s *TorSupervisor) setState(state SupervisorState, err error) {
+	s.mu.Lock()
+	if state == SupervisorReady {
+		if !s.readyClosedLocked() {
+			close(s.ready)
+		}
+	} else if s.readyClosedLocked() {
+		s.ready = make(chan struct{})
+	}
+	if err != nil {
+		s.lastErr = err
+	}
+	s.state = state
+	ev := SupervisorEvent{State: state, Time: time.Now(), Restarts: s.restarts, Err: err}
+	s.mu.Unlock()
+
+	for {
+		select {
+		case s.states <- ev:
+			return
+		default:
+		}
+		select {
+		case <-s.states:
+		default:
+		}
+	}
+}
+
+// readyClosed reports whether the current state is SupervisorReady.
+func (s *TorSupervisor) readyClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readyClosedLocked()
+}
+
+// readyClosedLocked is readyClosed for callers holding mu.
+func (s *TorSupervisor) readyClosedLocked() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops supervision, shuts tor down and removes the DataDirectory if
+// the supervisor created it.
+func (s *TorSupervisor) Stop() error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		<-s.done
+		return nil
+	}
+	s.stopped = true
+	started := s.started
+	cancel := s.cancel
+	s.mu.Unlock()
+	close(s.stopCh)
+	if cancel != nil {
+		cancel()
+	}
+	if !started {
+		s.setState(SupervisorStopped, nil)
+		close(s.done)
+		return nil
+	}
+	<-s.done
+	return nil
+}
+
+// Done returns a channel that is closed once the supervisor has stopped,
+// either through Stop, context cancellation or giving up on restarts.
+func (s *TorSupervisor) Done() <-chan struct{} { return s.done }
+
+// States returns the channel of state transitions. It is buffered; if the
+// consumer falls behind, the oldest events are dropped.
+func (s *TorSupervisor) States() <-chan SupervisorEvent { return s.states }
+
+// State returns the current state.
+func (s *TorSupervisor) State() SupervisorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Restarts returns how many times tor has been restarted.
+func (s *TorSupervisor) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+// Process returns the currently running tor process, or nil while none is running.
+func (s *TorSupervisor) Process() *TorProcess {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.proc
+}
+
+// SocksAddr returns the SocksPort address, which stays the same across restarts.
+func (s *TorSupervisor) SocksAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.socksAddr
+}
+
+// ControlAddr returns the ControlPort address, which stays the same across restarts.
+func (s *TorSupervisor) ControlAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.controlAddr
+}
+
+// DataDir returns the DataDirectory shared by all restarts.
+func (s *TorSupervisor) DataDir() string { return s.cfg.dataDir }
+
+// WaitReady blocks until tor is bootstrapped, the supervisor stops or ctx is done.
+func (s *TorSupervisor) WaitReady(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		ready := s.ready
+		s.mu.Unlock()
+		select {
+		case <-ready:
+			return nil
+		case <-s.done:
+			s.mu.Lock()
+			err := s.lastErr
+			s.mu.Unlock()
+			return newError(ErrTorLaunchFailed, opTorSupervisor, "supervisor stopped before tor became ready", err)
+		case <-ctx.Done():
+			return newError(ErrTimeout, opTorSupervisor, "timed out waiting for tor to become ready", ctx.Err())
+		}
+	}
+}
+
+// OnRestart registers fn to run each time a restarted tor becomes ready, for
+// example to reconnect a Client's ControlPort or recreate ephemeral onion
+// services. fn runs on the supervision goroutine. The returned function
+// unregisters fn.
+func (s *TorSupervisor) OnRestart(fn func(*TorProcess)) (remove func()) {
+	if fn == nil {
+		return func() {}
+	}
+	s.mu.Lock()
+	id := s.nextHookID
+	s.nextHookID++
+	s.onRestart[id] = fn
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.onRestart, id)
+		s.mu.Unlock()
+	}
+}
+
+// bootstrapProbe polls the bootstrap progress of one tor process over a
+// ControlPort connection that is authenticated once and then reused.
+type bootstrapProbe struct {
+	// proc is the watched tor process.
+	proc *TorProcess
+	// client is the authenticated connection, nil until the first poll or
+	// after a failed request.
+	client *ControlClient
+}
+
+// bootstrapProgress queries the bootstrap progress of the process watched by
+// p, connecting first when p has no open connection.
+func bootstrapProgress(ctx context.Context, p *bootstrapProbe) (int, error) {
+	if p.client == nil {
+		auth, _, err := ControlAuthFromTor(p.proc.ControlAddr(), 2*time.Second)
+		if err != nil {
+			return 0, err
+		}
+		client, err := NewControlClient(p.proc.ControlAddr(), auth, 2*time.Second)
+		if err != nil {
+			return 0, err
+		}
+		p.client = client
+	}
+	info, err := p.client.GetInfo(ctx, "status/bootstrap-phase")
+	if err != nil {
+		// The connection may be broken; reconnect on the next poll.
+		p.close()
+		return 0, err
+	}
+	progress, ok := parseBootstrapProgress(info)
+	if !ok {
+		return 0, newError(ErrControlRequestFail, opTorSupervisor, "malformed bootstrap status "+info, nil)
+	}
+	return progress, nil
+}
+
+// close closes the connection, if any.
+func (p *bootstrapProbe) close() {
+	if p.client != nil {
+		_ = p.client.Close()
+		p.client = nil
+	}
+}
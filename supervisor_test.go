@@ -0,0 +1,396 @@
+package tornago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSupervisor returns a supervisor whose "tor" is a sleep process that
+// reports itself bootstrapped immediately.
+func newTestSupervisor(t *testing.T, opts ...SupervisorOption) (*TorSupervisor, *fakeLauncher) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on the sleep command")
+	}
+	cfg, err := NewTorLaunchConfig(WithTorSocksAddr("127.0.0.1:0"), WithTorControlAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+	opts = append([]SupervisorOption{WithSupervisorBackoff(10*time.Millisecond, 20*time.Millisecond)}, opts...)
+	sup, err := NewTorSupervisor(cfg, opts...)
+	if err != nil {
+		t.Fatalf("NewTorSupervisor failed: %v", err)
+	}
+	fl := &fakeLauncher{t: t}
+	sup.launch = fl.launch
+	sup.bootstrap = func(context.Context, *bootstrapProbe) (int, error) { return 100, nil }
+	return sup, fl
+}
+
+// fakeLauncher starts sleep processes in place of tor.
+type fakeLauncher struct {
+	t       *testing.T
+	mu      sync.Mutex
+	configs []TorLaunchConfig
+	procs   []*TorProcess
+	fail    bool
+}
+
+func (f *fakeLauncher) launch(_ context.Context, cfg TorLaunchConfig) (*TorProcess, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs = append(f.configs, cfg)
+	if f.fail {
+		return nil, errors.New("launch failed")
+	}
+	// Like tor with "auto" ports, pick concrete addresses for ":0".
+	socksAddr, err := resolveAddr(cfg.SocksAddr())
+	if err != nil {
+		return nil, err
+	}
+	controlAddr, err := resolveAddr(cfg.ControlAddr())
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		f.t.Skipf("sleep not available: %v", err)
+	}
+	proc := &TorProcess{
+		pid:         cmd.Process.Pid,
+		socksAddr:   socksAddr,
+		controlAddr: controlAddr,
+		cmd:         cmd,
+		process:     cmd.Process,
+		dataDir:     cfg.DataDir(),
+		wait:        watchProcess(cmd),
+	}
+	f.procs = append(f.procs, proc)
+	return proc, nil
+}
+
+func (f *fakeLauncher) current() *TorProcess {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.procs[len(f.procs)-1]
+}
+
+// waitForState reads events until state is seen.
+func waitForState(t *testing.T, sup *TorSupervisor, state SupervisorState) SupervisorEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-sup.States():
+			if ev.State == state {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for state %s (current %s)", state, sup.State())
+		}
+	}
+}
+
+func TestTorSupervisor(t *testing.T) {
+	t.Run("should restart a crashed tor with the same ports and data dir", func(t *testing.T) {
+		sup, fl := newTestSupervisor(t)
+		restarted := make(chan *TorProcess, 1)
+		sup.OnRestart(func(p *TorProcess) { restarted <- p })
+
+		if err := sup.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer sup.Stop()
+		if err := sup.WaitReady(context.Background()); err != nil {
+			t.Fatalf("WaitReady failed: %v", err)
+		}
+		waitForState(t, sup, SupervisorReady)
+		first := fl.current()
+		dataDir := sup.DataDir()
+
+		_ = first.process.Kill()
+		crash := waitForState(t, sup, SupervisorCrashed)
+		if crash.Err == nil {
+			t.Error("expected crash error")
+		}
+		if first.PID() != 0 {
+			t.Errorf("expected PID 0 after exit, got %d", first.PID())
+		}
+		ready := waitForState(t, sup, SupervisorReady)
+		if ready.Restarts != 1 || sup.Restarts() != 1 {
+			t.Errorf("expected 1 restart, got %d/%d", ready.Restarts, sup.Restarts())
+		}
+		select {
+		case p := <-restarted:
+			if p == first {
+				t.Error("OnRestart received the crashed process")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnRestart was not called")
+		}
+
+		fl.mu.Lock()
+		configs := append([]TorLaunchConfig(nil), fl.configs...)
+		fl.mu.Unlock()
+		if len(configs) != 2 {
+			t.Fatalf("expected 2 launches, got %d", len(configs))
+		}
+		if configs[0].SocksAddr() != "127.0.0.1:0" || configs[0].DataDir() != dataDir {
+			t.Errorf("unexpected first launch config: %s %s", configs[0].SocksAddr(), configs[0].DataDir())
+		}
+		if first.SocksAddr() != sup.SocksAddr() || first.ControlAddr() != sup.ControlAddr() {
+			t.Errorf("expected addresses of the first launch, got %s %s", sup.SocksAddr(), sup.ControlAddr())
+		}
+		restart := configs[1]
+		if restart.SocksAddr() != sup.SocksAddr() || restart.ControlAddr() != sup.ControlAddr() || restart.DataDir() != dataDir {
+			t.Errorf("restart config changed: %s %s %s", restart.SocksAddr(), restart.ControlAddr(), restart.DataDir())
+		}
+		if sup.SocksAddr() == "127.0.0.1:0" {
+			t.Error("expected SocksAddr to be pinned to a concrete port")
+		}
+
+		if err := sup.Stop(); err != nil {
+			t.Fatalf("Stop failed: %v", err)
+		}
+		waitForState(t, sup, SupervisorStopped)
+		if !fl.current().Exited() {
+			t.Error("expected tor process to be stopped")
+		}
+		if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+			t.Errorf("expected owned data dir to be removed, stat err: %v", err)
+		}
+	})
+
+	t.Run("should give up after max restarts", func(t *testing.T) {
+		sup, fl := newTestSupervisor(t, WithSupervisorMaxRestarts(2))
+		if err := sup.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer sup.Stop()
+		waitForState(t, sup, SupervisorReady)
+
+		fl.mu.Lock()
+		fl.fail = true
+		fl.mu.Unlock()
+		_ = fl.current().process.Kill()
+
+		stopped := waitForState(t, sup, SupervisorStopped)
+		if stopped.Err == nil {
+			t.Error("expected stopped event to carry an error")
+		}
+		select {
+		case <-sup.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("supervisor did not stop")
+		}
+		if sup.Restarts() != 2 {
+			t.Errorf("expected 2 restarts, got %d", sup.Restarts())
+		}
+		if err := sup.WaitReady(context.Background()); err == nil {
+			t.Error("expected WaitReady to fail after giving up")
+		}
+	})
+
+	t.Run("should stop when the context is canceled", func(t *testing.T) {
+		sup, fl := newTestSupervisor(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := sup.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		waitForState(t, sup, SupervisorReady)
+		cancel()
+		select {
+		case <-sup.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("supervisor did not stop")
+		}
+		if !fl.current().Exited() {
+			t.Error("expected tor process to be stopped")
+		}
+	})
+
+	t.Run("should abort the first launch when stopped", func(t *testing.T) {
+		sup, _ := newTestSupervisor(t)
+		entered := make(chan struct{})
+		sup.launch = func(ctx context.Context, _ TorLaunchConfig) (*TorProcess, error) {
+			close(entered)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		startErr := make(chan error, 1)
+		go func() { startErr <- sup.Start(context.Background()) }()
+		<-entered
+		stopped := make(chan struct{})
+		go func() {
+			_ = sup.Stop()
+			close(stopped)
+		}()
+		select {
+		case err := <-startErr:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Start error = %v, want context.Canceled", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop did not abort the launch")
+		}
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop did not return")
+		}
+	})
+
+	t.Run("should abort a relaunch when the context is canceled", func(t *testing.T) {
+		sup, fl := newTestSupervisor(t)
+		relaunching := make(chan struct{})
+		launches := 0
+		sup.launch = func(ctx context.Context, cfg TorLaunchConfig) (*TorProcess, error) {
+			launches++
+			if launches == 1 {
+				return fl.launch(ctx, cfg)
+			}
+			close(relaunching)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := sup.Start(ctx); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		waitForState(t, sup, SupervisorReady)
+		_ = fl.current().process.Kill()
+		select {
+		case <-relaunching:
+		case <-time.After(5 * time.Second):
+			t.Fatal("tor was not relaunched")
+		}
+		cancel()
+		select {
+		case <-sup.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("canceling the context did not abort the relaunch")
+		}
+		if sup.State() != SupervisorStopped {
+			t.Errorf("expected stopped state, got %s", sup.State())
+		}
+	})
+
+	t.Run("should report a failed first launch", func(t *testing.T) {
+		sup, fl := newTestSupervisor(t)
+		fl.fail = true
+		if err := sup.Start(context.Background()); err == nil {
+			t.Fatal("expected Start to fail")
+		}
+		if sup.State() != SupervisorStopped {
+			t.Errorf("expected stopped state, got %s", sup.State())
+		}
+		if err := sup.Start(context.Background()); err == nil {
+			t.Error("expected second Start to fail")
+		}
+	})
+}
+
+func TestNewTorSupervisorValidation(t *testing.T) {
+	cfg, err := NewTorLaunchConfig()
+	if err != nil {
+		t.Fatalf("NewTorLaunchConfig failed: %v", err)
+	}
+	if _, err := NewTorSupervisor(cfg, WithSupervisorBackoff(0, time.Second)); err == nil {
+		t.Error("expected error for zero backoff")
+	}
+	if _, err := NewTorSupervisor(cfg, WithSupervisorBackoff(time.Minute, time.Second)); err == nil {
+		t.Error("expected error for max below initial backoff")
+	}
+	if _, err := NewTorSupervisor(cfg, WithSupervisorMaxRestarts(-1)); err == nil {
+		t.Error("expected error for negative max restarts")
+	}
+}
+
+func TestTorProcessExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on the sleep command")
+	}
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+	proc := &TorProcess{pid: cmd.Process.Pid, cmd: cmd, process: cmd.Process, wait: watchProcess(cmd)}
+	if proc.Exited() || proc.PID() == 0 {
+		t.Fatal("expected process to be running")
+	}
+	if health := CheckTorDaemon(context.Background(), proc); health.Message() == "Tor process exited" {
+		t.Error("unexpected exited health before kill")
+	}
+	_ = cmd.Process.Kill()
+	select {
+	case <-proc.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done was not closed")
+	}
+	if proc.PID() != 0 || proc.ExitErr() == nil {
+		t.Errorf("unexpected exit state: pid=%d err=%v", proc.PID(), proc.ExitErr())
+	}
+	if health := CheckTorDaemon(context.Background(), proc); !health.IsUnhealthy() {
+		t.Errorf("expected unhealthy after exit, got %s: %s", health.Status(), health.Message())
+	}
+	if err := proc.Stop(); err != nil {
+		t.Errorf("Stop after exit failed: %v", err)
+	}
+}
+
+func TestBootstrapProbe(t *testing.T) {
+	t.Run("should authenticate once and reuse the connection", func(t *testing.T) {
+		cookiePath := filepath.Join(t.TempDir(), "control_auth_cookie")
+		if err := os.WriteFile(cookiePath, []byte("cookie"), 0o600); err != nil {
+			t.Fatalf("failed to write cookie: %v", err)
+		}
+		var protocolInfos, auths, polls atomic.Int32
+		addr := startMockControlServer(t, func(cmd string) string {
+			switch {
+			case cmd == "PROTOCOLINFO 1":
+				protocolInfos.Add(1)
+				return "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=COOKIE COOKIEFILE=\"" + cookiePath + "\"\r\n250 OK\r\n"
+			case strings.HasPrefix(cmd, "AUTHENTICATE"):
+				auths.Add(1)
+			case cmd == "GETINFO status/bootstrap-phase":
+				progress := 50
+				if polls.Add(1) >= 3 {
+					progress = 100
+				}
+				return fmt.Sprintf("250-status/bootstrap-phase=NOTICE BOOTSTRAP PROGRESS=%d TAG=done\r\n250 OK\r\n", progress)
+			}
+			return ""
+		})
+		probe := &bootstrapProbe{proc: &TorProcess{controlAddr: addr}}
+		defer probe.close()
+
+		for i, want := range []int{50, 50, 100} {
+			progress, err := bootstrapProgress(context.Background(), probe)
+			if err != nil {
+				t.Fatalf("poll %d failed: %v", i, err)
+			}
+			if progress != want {
+				t.Errorf("poll %d progress = %d, want %d", i, progress, want)
+			}
+		}
+		if protocolInfos.Load() != 1 {
+			t.Errorf("PROTOCOLINFO sent %d times, want 1", protocolInfos.Load())
+		}
+		// ControlAuthFromTor authenticates its own connection before the
+		// polling connection does.
+		if auths.Load() != 2 {
+			t.Errorf("AUTHENTICATE sent %d times, want 2", auths.Load())
+		}
+	})
+}
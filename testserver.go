@@ -86,6 +86,7 @@ Log notice stdout
 		WithTorSocksAddr(testTorSocksAddr),
 		WithTorControlAddr(testTorControlAddr),
 		WithTorConfigFile(torrcPath),
+		WithTorExitWithParent(),
 	)
 	if err != nil {
 		t.Fatalf("tornago: failed to build launch config: %v", err)
@@ -0,0 +1,364 @@
+package tornago
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TopologySnapshot is a point-in-time view of Tor's circuits, the relays they
+// pass through, the streams attached to them and the onion services involved.
+// It marshals to JSON with encoding/json and renders to Graphviz with DOT.
+type TopologySnapshot struct {
+	// TakenAt is when the snapshot was taken.
+	TakenAt time.Time `json:"taken_at"`
+	// Circuits lists every circuit Tor reported, ordered by ID.
+	Circuits []SnapshotCircuit `json:"circuits"`
+	// OnionServices lists local onion services and those reached by circuits.
+	OnionServices []SnapshotOnionService `json:"onion_services"`
+}
+
+// SnapshotCircuit describes one circuit in a TopologySnapshot.
+type SnapshotCircuit struct {
+	// ID is the circuit identifier.
+	ID string `json:"id"`
+	// Status is the circuit status (e.g. "BUILT", "EXTENDED").
+	Status string `json:"status"`
+	// Purpose is the circuit purpose (e.g. "GENERAL", "HS_CLIENT_REND").
+	Purpose string `json:"purpose,omitempty"`
+	// BuildFlags contains circuit build flags.
+	BuildFlags []string `json:"build_flags,omitempty"`
+	// TimeCreated is when Tor created the circuit, as reported by Tor.
+	TimeCreated string `json:"time_created,omitempty"`
+	// OnionAddress is the onion service this circuit serves ("" for exit circuits).
+	OnionAddress string `json:"onion_address,omitempty"`
+	// Path lists the relays of the circuit from guard to last hop.
+	Path []SnapshotRelay `json:"path"`
+	// Streams lists the streams attached to the circuit.
+	Streams []SnapshotStream `json:"streams"`
+}
+
+// SnapshotRelay describes a relay on a circuit path. Fields other than
+// Fingerprint and Nickname are empty when the relay is not in the consensus
+// (e.g. bridges) or GeoIP data is unavailable.
+type SnapshotRelay struct {
+	// Fingerprint is the 40-character uppercase hex identity digest.
+	Fingerprint string `json:"fingerprint"`
+	// Nickname is the relay's self-chosen name.
+	Nickname string `json:"nickname,omitempty"`
+	// Country is the lowercase ISO 3166 code of the relay.
+	Country string `json:"country,omitempty"`
+	// Bandwidth is the consensus weight in kilobytes per second.
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+	// Flags lists the relay's consensus flags.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// SnapshotStream describes a stream attached to a circuit.
+type SnapshotStream struct {
+	// ID is the stream identifier.
+	ID string `json:"id"`
+	// Status is the stream status (e.g. "SUCCEEDED").
+	Status string `json:"status"`
+	// Target is the destination address:port.
+	Target string `json:"target"`
+	// Purpose is the stream purpose.
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// SnapshotOnionService describes an onion service seen in a TopologySnapshot.
+type SnapshotOnionService struct {
+	// Address is the onion address without the ".onion" suffix.
+	Address string `json:"address"`
+	// Local reports whether the service is hosted by this Tor instance.
+	Local bool `json:"local"`
+	// CircuitIDs lists the circuits serving the onion service.
+	CircuitIDs []string `json:"circuit_ids,omitempty"`
+}
+
+// Snapshot returns the current circuit topology. Circuits and streams are
+// read with a single GETINFO so they are consistent with each other; relay
+// details are then looked up in the consensus and GeoIP database, and lookup
+// failures only leave those details empty. OnionServices is left empty when
+// the onion services cannot be listed.
+//
+// Example:
+//
+//	snap, err := manager.Snapshot(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	_ = json.NewEncoder(os.Stdout).Encode(snap)
+//	_ = os.WriteFile("circuits.dot", []byte(snap.DOT()), 0o600)
+func (m *CircuitManager) Snapshot(ctx context.Context) (TopologySnapshot, error) {
+	c := m.control
+	if err := c.ensureAuthenticated(); err != nil {
+		return TopologySnapshot{}, err
+	}
+	lines, err := c.execCommand(ctx, "GETINFO circuit-status stream-status")
+	if err != nil {
+		return TopologySnapshot{}, err
+	}
+	values := splitGetInfoValues(lines, "circuit-status", "stream-status")
+	snap := TopologySnapshot{TakenAt: time.Now()}
+
+	relays := make(map[string]*SnapshotRelay)
+	var fingerprints []string
+	index := make(map[string]int)
+	for _, line := range values["circuit-status"] {
+		info := parseCircuitLine(line)
+		if info.ID == "" {
+			continue
+		}
+		circuit := SnapshotCircuit{
+			ID:           info.ID,
+			Status:       info.Status,
+			Purpose:      info.Purpose,
+			BuildFlags:   info.BuildFlags,
+			TimeCreated:  info.TimeCreated,
+			OnionAddress: info.RendQuery,
+			Path:         make([]SnapshotRelay, 0, len(info.Path)),
+			Streams:      []SnapshotStream{},
+		}
+		for _, hop := range info.Path {
+			relay := parsePathHop(hop)
+			if relay.Fingerprint != "" && relays[relay.Fingerprint] == nil {
+				relays[relay.Fingerprint] = &relay
+				fingerprints = append(fingerprints, relay.Fingerprint)
+			}
+			circuit.Path = append(circuit.Path, relay)
+		}
+		index[circuit.ID] = len(snap.Circuits)
+		snap.Circuits = append(snap.Circuits, circuit)
+	}
+	for _, line := range values["stream-status"] {
+		stream := parseStreamLine(line)
+		i, ok := index[stream.CircuitID]
+		if stream.ID == "" || !ok {
+			continue
+		}
+		snap.Circuits[i].Streams = append(snap.Circuits[i].Streams, SnapshotStream{
+			ID:      stream.ID,
+			Status:  stream.Status,
+			Target:  stream.Target,
+			Purpose: stream.Purpose,
+		})
+	}
+
+	c.enrichSnapshotRelays(ctx, fingerprints, relays)
+	for i := range snap.Circuits {
+		for j, hop := range snap.Circuits[i].Path {
+			if r := relays[hop.Fingerprint]; r != nil {
+				nickname := hop.Nickname
+				snap.Circuits[i].Path[j] = *r
+				if snap.Circuits[i].Path[j].Nickname == "" {
+					snap.Circuits[i].Path[j].Nickname = nickname
+				}
+			}
+		}
+	}
+	slices.SortFunc(snap.Circuits, func(a, b SnapshotCircuit) int {
+		return compareControlIDs(a.ID, b.ID)
+	})
+
+	services, err := c.GetHiddenServiceStatus(ctx)
+	if err != nil {
+		m.logger.Log("warn", "failed to look up onion services for topology snapshot", "error", err)
+		return snap, nil
+	}
+	snap.OnionServices = snapshotOnionServices(services, snap.Circuits)
+	return snap, nil
+}
+
+// enrichSnapshotRelays fills consensus and GeoIP details for the given relays.
+func (c *ControlClient) enrichSnapshotRelays(ctx context.Context, fingerprints []string, relays map[string]*SnapshotRelay) {
+	found := make([]Relay, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		lines, err := c.getInfoLines(ctx, "ns/id/"+fp)
+		if err != nil {
+			continue
+		}
+		if parsed := parseRouterStatus(lines); len(parsed) > 0 {
+			found = append(found, parsed[0])
+		}
+	}
+	c.resolveCountries(ctx, found)
+	for _, r := range found {
+		relay := relays[r.Fingerprint]
+		if relay == nil {
+			continue
+		}
+		relay.Nickname = r.Nickname
+		relay.Country = r.Country
+		relay.Bandwidth = r.Bandwidth
+		relay.Flags = r.Flags
+	}
+}
+
+// snapshotOnionServices merges local onion services with those reached by circuits.
+func snapshotOnionServices(local []HiddenServiceStatus, circuits []SnapshotCircuit) []SnapshotOnionService {
+	services := make(map[string]*SnapshotOnionService)
+	var order []string
+	get := func(addr string) *SnapshotOnionService {
+		addr = strings.TrimSuffix(strings.ToLower(addr), ".onion")
+		if s := services[addr]; s != nil {
+			return s
+		}
+		s := &SnapshotOnionService{Address: addr}
+		services[addr] = s
+		order = append(order, addr)
+		return s
+	}
+	for _, hs := range local {
+		get(hs.ServiceID).Local = true
+	}
+	for _, circuit := range circuits {
+		if circuit.OnionAddress != "" {
+			s := get(circuit.OnionAddress)
+			s.CircuitIDs = append(s.CircuitIDs, circuit.ID)
+		}
+	}
+	out := make([]SnapshotOnionService, 0, len(order))
+	for _, addr := range order {
+		out = append(out, *services[addr])
+	}
+	return out
+}
+
+// parsePathHop parses a circuit path entry ("$FP~nickname", "$FP=nickname"
+// or "$FP").
+func parsePathHop(hop string) SnapshotRelay {
+	id, nickname, found := strings.Cut(strings.TrimPrefix(hop, "$"), "~")
+	if !found {
+		id, nickname, _ = strings.Cut(id, "=")
+	}
+	fp, err := normalizeFingerprint(id)
+	if err != nil {
+		return SnapshotRelay{Nickname: hop}
+	}
+	return SnapshotRelay{Fingerprint: fp, Nickname: nickname}
+}
+
+// splitGetInfoValues splits the reply of a multi-key GETINFO into the value
+// lines of each key. Single-line values ("key=value") become one line.
+func splitGetInfoValues(lines []string, keys ...string) map[string][]string {
+	values := make(map[string][]string, len(keys))
+	current := ""
+	for _, line := range lines {
+		matched := false
+		for _, key := range keys {
+			if value, ok := strings.CutPrefix(line, key+"="); ok {
+				current, matched = key, true
+				if value != "" {
+					values[key] = append(values[key], value)
+				}
+				break
+			}
+		}
+		if !matched && current != "" && line != "" {
+			values[current] = append(values[current], line)
+		}
+	}
+	return values
+}
+
+// DOT renders the snapshot as a Graphviz digraph: the client links to each
+// circuit's guard, hops link along the path labeled with the circuit ID, and
+// streams and onion services hang off the last hop.
+func (s TopologySnapshot) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph tornago {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+	b.WriteString("  \"client\" [label=\"client\", shape=ellipse];\n")
+
+	declared := make(map[string]bool)
+	node := func(id, label, attrs string) {
+		if declared[id] {
+			return
+		}
+		declared[id] = true
+		fmt.Fprintf(&b, "  %s [label=%s%s];\n", dotQuote(id), dotQuote(label), attrs)
+	}
+	for _, circuit := range s.Circuits {
+		for _, hop := range circuit.Path {
+			node(relayNodeID(hop), relayLabel(hop), "")
+		}
+	}
+	for _, hs := range s.OnionServices {
+		attrs := ", shape=hexagon"
+		if hs.Local {
+			attrs += ", style=bold"
+		}
+		node("onion:"+hs.Address, hs.Address+".onion", attrs)
+	}
+
+	for _, circuit := range s.Circuits {
+		style := ""
+		if circuit.Status != "BUILT" {
+			style = ", style=dashed"
+		}
+		label := "circ " + circuit.ID
+		if circuit.Purpose != "" {
+			label += " " + circuit.Purpose
+		}
+		prev := "client"
+		for i, hop := range circuit.Path {
+			edgeLabel := ""
+			if i == 0 {
+				edgeLabel = ", label=" + dotQuote(label)
+			}
+			fmt.Fprintf(&b, "  %s -> %s [tooltip=%s%s%s];\n", dotQuote(prev), dotQuote(relayNodeID(hop)), dotQuote(label), edgeLabel, style)
+			prev = relayNodeID(hop)
+		}
+		if circuit.OnionAddress != "" && len(circuit.Path) > 0 {
+			fmt.Fprintf(&b, "  %s -> %s [label=%s%s];\n", dotQuote(prev), dotQuote("onion:"+circuit.OnionAddress), dotQuote("circ "+circuit.ID), style)
+		}
+		for _, stream := range circuit.Streams {
+			id := "stream:" + stream.ID
+			node(id, "stream "+stream.ID+"\n"+stream.Target, ", shape=note")
+			from := prev
+			if len(circuit.Path) == 0 {
+				from = "client"
+			}
+			fmt.Fprintf(&b, "  %s -> %s [label=%s, style=dotted];\n", dotQuote(from), dotQuote(id), dotQuote(stream.Status))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// relayNodeID returns the DOT node identifier of a relay.
+func relayNodeID(r SnapshotRelay) string {
+	if r.Fingerprint != "" {
+		return "relay:" + r.Fingerprint
+	}
+	return "relay:" + r.Nickname
+}
+
+// relayLabel returns the DOT label of a relay.
+func relayLabel(r SnapshotRelay) string {
+	name := r.Nickname
+	if name == "" {
+		name = r.Fingerprint
+	}
+	var details []string
+	if r.Country != "" {
+		details = append(details, "{"+r.Country+"}")
+	}
+	if r.Bandwidth > 0 {
+		details = append(details, fmt.Sprintf("%d KB/s", r.Bandwidth))
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return name + "\n" + strings.Join(details, " ")
+}
+
+// dotQuote renders s as a DOT quoted string.
+func dotQuote(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", `\n`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -0,0 +1,175 @@
+package tornago
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitManager_Snapshot(t *testing.T) {
+	exitEntry := strings.SplitAfter(testConsensus, "p accept 80,443,8000-8100\r\n")[0]
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO circuit-status stream-status":
+			return "250+circuit-status=\r\n" +
+				"5 BUILT $" + testRelayFingerprintB + "~guardRelay,$" + testRelayFingerprintA + "~exitRelay PURPOSE=GENERAL\r\n" +
+				"12 BUILT $" + testRelayFingerprintB + "~guardRelay PURPOSE=HS_CLIENT_REND REND_QUERY=remoteservice\r\n" +
+				"13 LAUNCHED PURPOSE=GENERAL\r\n" +
+				".\r\n" +
+				"250-stream-status=7 SUCCEEDED 5 example.com:443\r\n" +
+				"250 OK\r\n"
+		case "GETINFO ns/id/" + testRelayFingerprintA:
+			return "250+ns/id/" + testRelayFingerprintA + "=\r\n" + exitEntry + ".\r\n250 OK\r\n"
+		case "GETINFO ns/id/" + testRelayFingerprintB:
+			return "552 Unrecognized key\r\n"
+		case "GETINFO ip-to-country/192.0.2.1":
+			return "250-ip-to-country/192.0.2.1=DE\r\n250 OK\r\n"
+		case "GETINFO onions/current":
+			return "250-onions/current=localservice\r\n250 OK\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	snap, err := NewCircuitManager(client).Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	t.Run("should order circuits and attach streams", func(t *testing.T) {
+		if len(snap.Circuits) != 3 {
+			t.Fatalf("expected 3 circuits, got %d", len(snap.Circuits))
+		}
+		ids := []string{snap.Circuits[0].ID, snap.Circuits[1].ID, snap.Circuits[2].ID}
+		if ids[0] != "5" || ids[1] != "12" || ids[2] != "13" {
+			t.Errorf("unexpected circuit order: %v", ids)
+		}
+		streams := snap.Circuits[0].Streams
+		if len(streams) != 1 || streams[0].ID != "7" || streams[0].Target != "example.com:443" {
+			t.Errorf("unexpected streams: %+v", streams)
+		}
+		if len(snap.Circuits[1].Streams) != 0 {
+			t.Errorf("expected no streams on circuit 12, got %+v", snap.Circuits[1].Streams)
+		}
+	})
+
+	t.Run("should enrich relays from the consensus", func(t *testing.T) {
+		path := snap.Circuits[0].Path
+		if len(path) != 2 {
+			t.Fatalf("expected 2 hops, got %d", len(path))
+		}
+		exit := path[1]
+		if exit.Fingerprint != testRelayFingerprintA || exit.Nickname != "exitRelay" || exit.Country != "de" || exit.Bandwidth != 5000 {
+			t.Errorf("unexpected exit relay: %+v", exit)
+		}
+		guard := path[0]
+		if guard.Fingerprint != testRelayFingerprintB || guard.Nickname != "guardRelay" || guard.Country != "" {
+			t.Errorf("unexpected guard relay: %+v", guard)
+		}
+	})
+
+	t.Run("should list local and remote onion services", func(t *testing.T) {
+		want := []SnapshotOnionService{
+			{Address: "localservice", Local: true},
+			{Address: "remoteservice", CircuitIDs: []string{"12"}},
+		}
+		if len(snap.OnionServices) != len(want) {
+			t.Fatalf("unexpected onion services: %+v", snap.OnionServices)
+		}
+		for i := range want {
+			got := snap.OnionServices[i]
+			if got.Address != want[i].Address || got.Local != want[i].Local || strings.Join(got.CircuitIDs, ",") != strings.Join(want[i].CircuitIDs, ",") {
+				t.Errorf("onion service %d = %+v, want %+v", i, got, want[i])
+			}
+		}
+	})
+
+	t.Run("should marshal to JSON with snake_case keys", func(t *testing.T) {
+		data, err := json.Marshal(snap)
+		if err != nil {
+			t.Fatalf("json.Marshal failed: %v", err)
+		}
+		for _, key := range []string{`"taken_at"`, `"onion_address":"remoteservice"`, `"circuit_ids":["12"]`, `"bandwidth":5000`} {
+			if !strings.Contains(string(data), key) {
+				t.Errorf("JSON missing %s: %s", key, data)
+			}
+		}
+	})
+
+	t.Run("should render DOT edges along circuit paths", func(t *testing.T) {
+		dot := snap.DOT()
+		for _, want := range []string{
+			"digraph tornago {",
+			`"client" -> "relay:` + testRelayFingerprintB + `"`,
+			`"relay:` + testRelayFingerprintB + `" -> "relay:` + testRelayFingerprintA + `"`,
+			`"relay:` + testRelayFingerprintB + `" -> "onion:remoteservice"`,
+			`"relay:` + testRelayFingerprintA + `" -> "stream:7"`,
+			`[label="exitRelay\n{de} 5000 KB/s"]`,
+		} {
+			if !strings.Contains(dot, want) {
+				t.Errorf("DOT missing %s:\n%s", want, dot)
+			}
+		}
+	})
+}
+
+func TestCircuitManager_SnapshotWithoutOnionServices(t *testing.T) {
+	addr := startMockControlServer(t, func(cmd string) string {
+		switch cmd {
+		case "GETINFO circuit-status stream-status":
+			return "250+circuit-status=\r\n" +
+				"12 BUILT $" + testRelayFingerprintB + "~guardRelay PURPOSE=HS_CLIENT_REND REND_QUERY=remoteservice\r\n" +
+				".\r\n" +
+				"250-stream-status=\r\n" +
+				"250 OK\r\n"
+		case "GETINFO onions/current":
+			return "552 Unrecognized key \"onions/current\"\r\n"
+		}
+		return ""
+	})
+	client, err := NewControlClient(addr, ControlAuth{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	snap, err := NewCircuitManager(client).Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snap.Circuits) != 1 {
+		t.Errorf("expected 1 circuit, got %+v", snap.Circuits)
+	}
+	if len(snap.OnionServices) != 1 || snap.OnionServices[0].Local {
+		t.Errorf("expected only the remote onion service, got %+v", snap.OnionServices)
+	}
+}
+
+func TestSplitGetInfoValues(t *testing.T) {
+	t.Run("should split multi-key replies", func(t *testing.T) {
+		lines := []string{"circuit-status=", "1 BUILT", "2 LAUNCHED", "stream-status=3 NEW 1 a:80"}
+		values := splitGetInfoValues(lines, "circuit-status", "stream-status")
+		if len(values["circuit-status"]) != 2 || len(values["stream-status"]) != 1 {
+			t.Errorf("unexpected values: %q", values)
+		}
+	})
+
+	t.Run("should return nothing for empty values", func(t *testing.T) {
+		values := splitGetInfoValues([]string{"circuit-status=", "stream-status="}, "circuit-status", "stream-status")
+		if len(values["circuit-status"]) != 0 || len(values["stream-status"]) != 0 {
+			t.Errorf("unexpected values: %q", values)
+		}
+	})
+}
+
+func TestDotQuote(t *testing.T) {
+	if got := dotQuote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("dotQuote() = %s", got)
+	}
+}
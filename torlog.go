@@ -0,0 +1,228 @@
+package tornago
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tor log severities, from most to least verbose.
+const (
+	// TorLogDebug is Tor's most verbose severity.
+	TorLogDebug = "debug"
+	// TorLogInfo reports routine events.
+	TorLogInfo = "info"
+	// TorLogNotice is Tor's default severity for messages worth reading.
+	TorLogNotice = "notice"
+	// TorLogWarn reports problems Tor can work around.
+	TorLogWarn = "warn"
+	// TorLogErr reports failures, usually just before Tor exits.
+	TorLogErr = "err"
+)
+
+// torLogTimeLayout is the timestamp format of Tor log lines. Tor omits the year.
+const torLogTimeLayout = "Jan 02 15:04:05.000"
+
+var (
+	// torLogLinePattern matches "Jan 02 15:04:05.000 [notice] {DOMAIN} message".
+	torLogLinePattern = regexp.MustCompile(`^([A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2}(?:\.\d{3})?) \[([a-z]+)\] (?:\{([A-Z_,]+)\} )?(.*)$`)
+	// torBootstrapPattern matches "Bootstrapped 45% (phase): summary".
+	torBootstrapPattern = regexp.MustCompile(`^Bootstrapped (\d{1,3})%`)
+	// torBindFailurePattern matches "Could not bind to 127.0.0.1:9050: Address already in use".
+	torBindFailurePattern = regexp.MustCompile(`^Could not bind to (\S+): `)
+	// torListenerPattern matches "Opening Socks listener on 127.0.0.1:9050" and
+	// "Opened Socks listener connection (ready) on 127.0.0.1:9050".
+	torListenerPattern = regexp.MustCompile(`^Open(?:ing|ed) (\w+) listener (?:connection \(ready\) )?on (\S+)`)
+)
+
+// TorLogEntry is one parsed line of Tor's log output.
+//
+// Example:
+//
+//	cfg, _ := tornago.NewTorLaunchConfig(
+//	    tornago.WithTorLogHandler(func(e tornago.TorLogEntry) {
+//	        if p, ok := e.BootstrapProgress(); ok {
+//	            log.Printf("bootstrap %d%%", p)
+//	        }
+//	    }),
+//	)
+type TorLogEntry struct {
+	// Time is when Tor logged the line, in local time (zero if unparsed).
+	Time time.Time
+	// Severity is one of the TorLog severities ("" if the line was not in Tor's log format).
+	Severity string
+	// Domain is the log domain such as "CONTROL" when LogMessageDomains is enabled.
+	Domain string
+	// Message is the log message without timestamp, severity and domain.
+	Message string
+}
+
+// ParseTorLogLine parses a line in Tor's log format, e.g.
+// "Nov 23 12:34:56.789 [notice] Bootstrapped 100% (done): Done". Lines in
+// another format are returned with only Message set and ok false.
+func ParseTorLogLine(line string) (entry TorLogEntry, ok bool) {
+	line = strings.TrimRight(line, "\r\n")
+	m := torLogLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		return TorLogEntry{Message: line}, false
+	}
+	return TorLogEntry{
+		Time:     parseTorLogTime(m[1], time.Now()),
+		Severity: m[2],
+		Domain:   m[3],
+		Message:  m[4],
+	}, true
+}
+
+// parseTorLogTime parses a yearless Tor timestamp, assuming it lies within a
+// day of now so lines logged around New Year get the right year.
+func parseTorLogTime(s string, now time.Time) time.Time {
+	t, err := time.ParseInLocation(torLogTimeLayout, s, now.Location())
+	if err != nil {
+		if t, err = time.ParseInLocation("Jan 02 15:04:05", s, now.Location()); err != nil {
+			return time.Time{}
+		}
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// BootstrapProgress returns the percentage of a "Bootstrapped N%" line.
+func (e TorLogEntry) BootstrapProgress() (int, bool) {
+	m := torBootstrapPattern.FindStringSubmatch(e.Message)
+	if m == nil {
+		return 0, false
+	}
+	progress, err := strconv.Atoi(m[1])
+	if err != nil || progress > 100 {
+		return 0, false
+	}
+	return progress, true
+}
+
+// ClockSkew reports whether the line warns that the local clock is wrong.
+// Tor cannot build circuits with a badly skewed clock.
+func (e TorLogEntry) ClockSkew() bool {
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "skewed time") ||
+		strings.Contains(msg, "requires an accurate clock") ||
+		(strings.Contains(msg, "clock is") && (strings.Contains(msg, " ahead") || strings.Contains(msg, " behind")))
+}
+
+// PortBindFailure returns the address of a "Could not bind to ADDR" line,
+// which Tor logs when a port is already in use or not permitted.
+func (e TorLogEntry) PortBindFailure() (string, bool) {
+	m := torBindFailurePattern.FindStringSubmatch(e.Message)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// ListenerOpened returns the kind ("Socks", "Control", ...) and address of an
+// "Opening ... listener on ADDR" or "Opened ... listener ... on ADDR" line.
+func (e TorLogEntry) ListenerOpened() (kind, addr string, ok bool) {
+	m := torListenerPattern.FindStringSubmatch(e.Message)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// loggerLevel maps the Tor severity to a Logger level.
+func (e TorLogEntry) loggerLevel() string {
+	switch e.Severity {
+	case TorLogDebug:
+		return "debug"
+	case TorLogWarn:
+		return "warn"
+	case TorLogErr:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// torLogSink receives Tor's output line by line, forwards it to the
+// configured reporter, handler and Logger, and remembers events that explain
+// a failed startup.
+type torLogSink struct {
+	// logger receives every line at the matching level.
+	logger Logger
+	// reporter receives raw lines (WithTorLogReporter).
+	reporter func(string)
+	// handler receives parsed entries (WithTorLogHandler).
+	handler func(TorLogEntry)
+	// mu protects the fields below; stdout and stderr write concurrently.
+	mu sync.Mutex
+	// bindFailure is the last "Could not bind" message.
+	bindFailure string
+	// clockSkew is the last clock skew warning.
+	clockSkew string
+	// lastError is the last err-severity message.
+	lastError string
+}
+
+// newTorLogSink returns a sink feeding the log destinations of cfg.
+func newTorLogSink(cfg TorLaunchConfig) *torLogSink {
+	logger := cfg.Logger()
+	if logger == nil {
+		logger = noopLogger{}
+	}
+	return &torLogSink{logger: logger, reporter: cfg.LogReporter(), handler: cfg.LogHandler()}
+}
+
+// line handles one complete line of Tor output.
+func (s *torLogSink) line(raw string) {
+	raw = strings.TrimRight(raw, "\r")
+	if raw == "" {
+		return
+	}
+	entry, _ := ParseTorLogLine(raw)
+
+	s.mu.Lock()
+	if _, ok := entry.PortBindFailure(); ok {
+		s.bindFailure = entry.Message
+	}
+	if entry.ClockSkew() {
+		s.clockSkew = entry.Message
+	}
+	if entry.Severity == TorLogErr {
+		s.lastError = entry.Message
+	}
+	reporter, handler := s.reporter, s.handler
+	s.mu.Unlock()
+
+	keyvals := []any{"source", "tor", "severity", entry.Severity}
+	if entry.Domain != "" {
+		keyvals = append(keyvals, "domain", entry.Domain)
+	}
+	s.logger.Log(entry.loggerLevel(), entry.Message, keyvals...)
+	if reporter != nil {
+		reporter(raw)
+	}
+	if handler != nil {
+		handler(entry)
+	}
+}
+
+// startupFailure describes why tor failed to start based on its log, or
+// returns "" when the log gives no explanation.
+func (s *torLogSink) startupFailure() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.bindFailure != "":
+		return "tor could not bind a listener port: " + s.bindFailure
+	case s.lastError != "":
+		return "tor failed to start: " + s.lastError
+	case s.clockSkew != "":
+		return "tor reported a skewed clock: " + s.clockSkew
+	}
+	return ""
+}
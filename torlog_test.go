@@ -0,0 +1,167 @@
+package tornago
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTorLogLine(t *testing.T) {
+	t.Run("should parse severity and message", func(t *testing.T) {
+		entry, ok := ParseTorLogLine("Nov 23 12:34:56.789 [notice] Bootstrapped 100% (done): Done\r\n")
+		if !ok {
+			t.Fatal("expected line to parse")
+		}
+		if entry.Severity != TorLogNotice || entry.Domain != "" || entry.Message != "Bootstrapped 100% (done): Done" {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		if entry.Time.Month() != time.November || entry.Time.Day() != 23 || entry.Time.Nanosecond() != 789*int(time.Millisecond) {
+			t.Errorf("unexpected time: %v", entry.Time)
+		}
+	})
+
+	t.Run("should parse the log domain", func(t *testing.T) {
+		entry, ok := ParseTorLogLine("Jan 02 03:04:05.000 [warn] {CONTROL} Bad password")
+		if !ok || entry.Severity != TorLogWarn || entry.Domain != "CONTROL" || entry.Message != "Bad password" {
+			t.Errorf("unexpected entry: %+v (ok=%v)", entry, ok)
+		}
+	})
+
+	t.Run("should keep unrecognized lines as messages", func(t *testing.T) {
+		entry, ok := ParseTorLogLine("Tor can't help you if you use it wrong!")
+		if ok || entry.Severity != "" || entry.Message != "Tor can't help you if you use it wrong!" {
+			t.Errorf("unexpected entry: %+v (ok=%v)", entry, ok)
+		}
+	})
+}
+
+func TestParseTorLogTime(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 5, 0, 0, time.UTC)
+	t.Run("should assume the current year", func(t *testing.T) {
+		got := parseTorLogTime("Jan 01 00:04:00.000", now)
+		if got.Year() != 2025 {
+			t.Errorf("expected 2025, got %v", got)
+		}
+	})
+
+	t.Run("should roll back lines logged before New Year", func(t *testing.T) {
+		got := parseTorLogTime("Dec 31 23:59:59.000", now)
+		if got.Year() != 2024 {
+			t.Errorf("expected 2024, got %v", got)
+		}
+	})
+}
+
+func TestTorLogEntryRecognizers(t *testing.T) {
+	t.Run("should recognize bootstrap progress", func(t *testing.T) {
+		p, ok := TorLogEntry{Message: "Bootstrapped 45% (requesting_descriptors): Asking for relay descriptors"}.BootstrapProgress()
+		if !ok || p != 45 {
+			t.Errorf("BootstrapProgress() = %d, %v", p, ok)
+		}
+		if _, ok := (TorLogEntry{Message: "Bootstrapping is done"}).BootstrapProgress(); ok {
+			t.Error("expected no progress")
+		}
+	})
+
+	t.Run("should recognize clock skew", func(t *testing.T) {
+		for _, msg := range []string{
+			"Received directory with skewed time (DIRSERV:1.2.3.4:80): It seems that our clock is ahead by 2 hours",
+			"Our clock is 3 hours, 0 minutes behind the time published in the consensus network status document",
+			"Tor requires an accurate clock to work: please check your time, timezone, and date settings.",
+		} {
+			if !(TorLogEntry{Message: msg}).ClockSkew() {
+				t.Errorf("expected clock skew for %q", msg)
+			}
+		}
+		if (TorLogEntry{Message: "Bootstrapped 100% (done): Done"}).ClockSkew() {
+			t.Error("unexpected clock skew")
+		}
+	})
+
+	t.Run("should recognize port binding failures", func(t *testing.T) {
+		addr, ok := TorLogEntry{Message: "Could not bind to 127.0.0.1:9050: Address already in use. Is Tor already running?"}.PortBindFailure()
+		if !ok || addr != "127.0.0.1:9050" {
+			t.Errorf("PortBindFailure() = %q, %v", addr, ok)
+		}
+	})
+
+	t.Run("should recognize opened listeners", func(t *testing.T) {
+		for _, msg := range []string{
+			"Opening Socks listener on 127.0.0.1:9050",
+			"Opened Socks listener connection (ready) on 127.0.0.1:9050",
+		} {
+			kind, addr, ok := TorLogEntry{Message: msg}.ListenerOpened()
+			if !ok || kind != "Socks" || addr != "127.0.0.1:9050" {
+				t.Errorf("ListenerOpened(%q) = %q, %q, %v", msg, kind, addr, ok)
+			}
+		}
+	})
+}
+
+// recordingLogger records Log calls.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) Log(level, msg string, _ ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, level+" "+msg)
+}
+
+func TestTorLogSink(t *testing.T) {
+	t.Run("should route complete lines to logger, reporter and handler", func(t *testing.T) {
+		logger := &recordingLogger{}
+		var reported []string
+		var handled []TorLogEntry
+		cfg, err := NewTorLaunchConfig(
+			WithTorLogger(logger),
+			WithTorLogReporter(func(line string) { reported = append(reported, line) }),
+			WithTorLogHandler(func(e TorLogEntry) { handled = append(handled, e) }),
+		)
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		sink := newTorLogSink(cfg)
+		w := &teeWriter{buf: &bytes.Buffer{}, reporter: sink.line}
+		_, _ = w.Write([]byte("Nov 23 12:34:56.789 [notice] Opening Socks "))           //nolint:errcheck
+		_, _ = w.Write([]byte("listener on 127.0.0.1:9050\r\nNov 23 12:34:57.000 [wa")) //nolint:errcheck
+		_, _ = w.Write([]byte("rn] Clock skew\r\n"))                                    //nolint:errcheck
+
+		if len(reported) != 2 || reported[0] != "Nov 23 12:34:56.789 [notice] Opening Socks listener on 127.0.0.1:9050" {
+			t.Errorf("unexpected reported lines: %q", reported)
+		}
+		if len(handled) != 2 || handled[1].Severity != TorLogWarn {
+			t.Fatalf("unexpected handled entries: %+v", handled)
+		}
+		if _, _, ok := handled[0].ListenerOpened(); !ok {
+			t.Error("expected listener entry")
+		}
+		want := []string{"info Opening Socks listener on 127.0.0.1:9050", "warn Clock skew"}
+		if strings.Join(logger.entries, "|") != strings.Join(want, "|") {
+			t.Errorf("unexpected logger entries: %q", logger.entries)
+		}
+	})
+
+	t.Run("should explain startup failures", func(t *testing.T) {
+		cfg, err := NewTorLaunchConfig()
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		sink := newTorLogSink(cfg)
+		if got := sink.startupFailure(); got != "" {
+			t.Errorf("expected no failure, got %q", got)
+		}
+		sink.line("Nov 23 12:34:56.789 [err] Reading config failed--see warnings above.")
+		if got := sink.startupFailure(); !strings.HasPrefix(got, "tor failed to start: Reading config failed") {
+			t.Errorf("unexpected failure: %q", got)
+		}
+		sink.line("Nov 23 12:34:56.789 [warn] Could not bind to 127.0.0.1:9050: Address already in use. Is Tor already running?")
+		if got := sink.startupFailure(); !strings.Contains(got, "could not bind a listener port: Could not bind to 127.0.0.1:9050") {
+			t.Errorf("unexpected failure: %q", got)
+		}
+	})
+}
@@ -0,0 +1,575 @@
+package tornago
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// opTorrc labels errors originating from Torrc operations.
+	opTorrc = "Torrc"
+	// maxTorrcIncludeDepth mirrors Tor's limit on nested %include directives.
+	maxTorrcIncludeDepth = 31
+)
+
+// torrcManagedKeys are set by StartTorDaemon itself when no torrc file is used,
+// so a Torrc passed with WithTorTorrc must not set them.
+var torrcManagedKeys = []string{
+	"SocksPort", "ControlPort", "ControlPortWriteToFile", "DataDirectory",
+	"CookieAuthentication", "CookieAuthFile", "RunAsDaemon",
+}
+
+// Torrc is an in-memory Tor configuration. Entries keep their order, so
+// repeated keys such as HiddenServiceDir/HiddenServicePort or Bridge behave
+// as they would in a torrc file. Option names are matched case-insensitively.
+// A Torrc is not safe for concurrent modification.
+//
+// Example:
+//
+//	rc := tornago.NewTorrc().
+//	    SetExitNodes(tornago.CountrySelector("de")).
+//	    SetStrictNodes(true).
+//	    Add("Log", "info file /var/log/tor/info.log")
+//	cfg, err := tornago.NewTorLaunchConfig(tornago.WithTorTorrc(rc))
+type Torrc struct {
+	// entries are the configuration lines in order.
+	entries []ConfEntry
+}
+
+// NewTorrc returns an empty Torrc.
+func NewTorrc() *Torrc {
+	return &Torrc{}
+}
+
+// LoadTorrc reads and parses the torrc file at path. %include directives are
+// expanded in place; relative include paths are resolved against the
+// directory of the including file.
+func LoadTorrc(path string) (*Torrc, error) {
+	t := NewTorrc()
+	if err := t.load(filepath.Clean(path), 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseTorrc parses torrc syntax from r. Relative %include paths are resolved
+// against the current working directory.
+func ParseTorrc(r io.Reader) (*Torrc, error) {
+	t := NewTorrc()
+	if err := t.parse(r, "torrc", ".", 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load parses the file at path into t.
+func (t *Torrc) load(path string, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return newError(ErrIO, opTorrc, "failed to open "+path, err)
+	}
+	defer f.Close()
+	return t.parse(f, path, filepath.Dir(path), depth)
+}
+
+// parse appends the entries in r to t. name labels errors and dir resolves
+// relative %include paths.
+func (t *Torrc) parse(r io.Reader, name, dir string, depth int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		start := lineNo
+		// A trailing backslash continues the line; comment lines inside a
+		// continuation are skipped like Tor does.
+		for strings.HasSuffix(line, `\`) {
+			line = strings.TrimSuffix(line, `\`)
+			if !scanner.Scan() {
+				break
+			}
+			lineNo++
+			next := strings.TrimRight(scanner.Text(), "\r")
+			if strings.HasPrefix(strings.TrimLeft(next, " \t"), "#") {
+				next = `\`
+			}
+			line += next
+		}
+
+		entry, ok, err := parseTorrcLine(line)
+		if err != nil {
+			return newError(ErrInvalidConfig, opTorrc, fmt.Sprintf("%s:%d: %v", name, start, err), nil)
+		}
+		if !ok {
+			continue
+		}
+		if entry.Key == "%include" {
+			if err := t.include(entry.Value, dir, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		t.entries = append(t.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return newError(ErrIO, opTorrc, "failed to read "+name, err)
+	}
+	return nil
+}
+
+// include expands an %include directive. Tor includes every non-hidden file
+// of a directory in lexical order and accepts glob patterns.
+func (t *Torrc) include(pattern, dir string, depth int) error {
+	if depth >= maxTorrcIncludeDepth {
+		return newError(ErrInvalidConfig, opTorrc, "too many nested %include directives", nil)
+	}
+	if pattern == "" {
+		return newError(ErrInvalidConfig, opTorrc, "%include without a path", nil)
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return newError(ErrInvalidConfig, opTorrc, "invalid %include pattern "+pattern, err)
+	}
+	if len(paths) == 0 && !strings.ContainsAny(pattern, `*?[`) {
+		return newError(ErrIO, opTorrc, "%include target not found: "+pattern, nil)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return newError(ErrIO, opTorrc, "failed to stat "+path, err)
+		}
+		if !info.IsDir() {
+			if err := t.load(path, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return newError(ErrIO, opTorrc, "failed to read "+path, err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			if err := t.load(filepath.Join(path, e.Name()), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseTorrcLine parses one logical torrc line. ok is false for blank and
+// comment lines.
+func parseTorrcLine(line string) (entry ConfEntry, ok bool, err error) {
+	line = strings.TrimLeft(line, " \t")
+	if line == "" || line[0] == '#' {
+		return ConfEntry{}, false, nil
+	}
+	key, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		key, rest = line[:i], strings.TrimLeft(line[i:], " \t")
+	}
+	if strings.HasPrefix(rest, `"`) {
+		value, tail, err := parseTorrcQuoted(rest)
+		if err != nil {
+			return ConfEntry{}, false, err
+		}
+		tail = strings.TrimLeft(tail, " \t")
+		if tail != "" && tail[0] != '#' {
+			return ConfEntry{}, false, fmt.Errorf("unexpected text after quoted value of %s", key)
+		}
+		return ConfEntry{Key: key, Value: value}, true, nil
+	}
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		rest = rest[:i]
+	}
+	return ConfEntry{Key: key, Value: strings.TrimRight(rest, " \t")}, true, nil
+}
+
+// parseTorrcQuoted decodes a C-style quoted string at the start of s and
+// returns it with the remaining text.
+func parseTorrcQuoted(s string) (value, rest string, err error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", fmt.Errorf("unterminated escape in %s", s)
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '"', '\'':
+				b.WriteByte(s[i])
+			case 'x':
+				if i+2 >= len(s) {
+					return "", "", fmt.Errorf("short hex escape in %s", s)
+				}
+				v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return "", "", fmt.Errorf("invalid hex escape in %s", s)
+				}
+				b.WriteByte(byte(v))
+				i += 2
+			default:
+				return "", "", fmt.Errorf("unknown escape \\%c in %s", s[i], s)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted value %s", s)
+}
+
+// Clone returns a deep copy of t.
+func (t *Torrc) Clone() *Torrc {
+	return &Torrc{entries: slices.Clone(t.entries)}
+}
+
+// Entries returns the configuration lines in order.
+func (t *Torrc) Entries() []ConfEntry {
+	return slices.Clone(t.entries)
+}
+
+// Get returns the last value of key, which is the one Tor uses for
+// single-valued options.
+func (t *Torrc) Get(key string) (string, bool) {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if strings.EqualFold(t.entries[i].Key, key) {
+			return t.entries[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns every value of key in order.
+func (t *Torrc) GetAll(key string) []string {
+	var values []string
+	for _, e := range t.entries {
+		if strings.EqualFold(e.Key, key) {
+			values = append(values, e.Value)
+		}
+	}
+	return values
+}
+
+// Add appends a key/value line, keeping existing lines with the same key.
+func (t *Torrc) Add(key, value string) *Torrc {
+	t.entries = append(t.entries, ConfEntry{Key: key, Value: value})
+	return t
+}
+
+// Set replaces every line of key with a single key/value line. The line takes
+// the position of the first existing one, or is appended.
+func (t *Torrc) Set(key, value string) *Torrc {
+	idx := slices.IndexFunc(t.entries, func(e ConfEntry) bool { return strings.EqualFold(e.Key, key) })
+	if idx < 0 {
+		return t.Add(key, value)
+	}
+	t.Remove(key)
+	t.entries = slices.Insert(t.entries, idx, ConfEntry{Key: key, Value: value})
+	return t
+}
+
+// Remove deletes every line of key.
+func (t *Torrc) Remove(key string) *Torrc {
+	t.entries = slices.DeleteFunc(t.entries, func(e ConfEntry) bool { return strings.EqualFold(e.Key, key) })
+	return t
+}
+
+// SetSocksPort sets the SocksPort, e.g. "127.0.0.1:9050" or "auto".
+func (t *Torrc) SetSocksPort(addr string) *Torrc { return t.Set("SocksPort", addr) }
+
+// SetControlPort sets the ControlPort, e.g. "127.0.0.1:9051" or "auto".
+func (t *Torrc) SetControlPort(addr string) *Torrc { return t.Set("ControlPort", addr) }
+
+// SetDataDirectory sets the DataDirectory.
+func (t *Torrc) SetDataDirectory(path string) *Torrc { return t.Set("DataDirectory", path) }
+
+// SetCookieAuthentication enables or disables ControlPort cookie authentication.
+func (t *Torrc) SetCookieAuthentication(enabled bool) *Torrc {
+	return t.Set("CookieAuthentication", boolSetting(enabled))
+}
+
+// SetHashedControlPassword sets the ControlPort password hash produced by
+// "tor --hash-password".
+func (t *Torrc) SetHashedControlPassword(hash string) *Torrc {
+	return t.Set("HashedControlPassword", hash)
+}
+
+// SetExitNodes restricts the relays used as the last hop. Selectors are
+// checked by Validate.
+func (t *Torrc) SetExitNodes(nodes ...NodeSelector) *Torrc {
+	return t.setNodes("ExitNodes", nodes)
+}
+
+// SetEntryNodes restricts the relays used as the first hop.
+func (t *Torrc) SetEntryNodes(nodes ...NodeSelector) *Torrc {
+	return t.setNodes("EntryNodes", nodes)
+}
+
+// SetExcludeNodes lists relays that must not be used.
+func (t *Torrc) SetExcludeNodes(nodes ...NodeSelector) *Torrc {
+	return t.setNodes("ExcludeNodes", nodes)
+}
+
+// SetStrictNodes makes ExcludeNodes a hard requirement.
+func (t *Torrc) SetStrictNodes(strict bool) *Torrc {
+	return t.Set("StrictNodes", boolSetting(strict))
+}
+
+// SetClientOnly prevents Tor from ever running as a relay.
+func (t *Torrc) SetClientOnly(clientOnly bool) *Torrc {
+	return t.Set("ClientOnly", boolSetting(clientOnly))
+}
+
+// AddLog adds a Log line, e.g. AddLog("notice", "file /var/log/tor/notices.log").
+func (t *Torrc) AddLog(severity, destination string) *Torrc {
+	return t.Add("Log", strings.TrimSpace(severity+" "+destination))
+}
+
+// AddHiddenService adds an onion service stored in dir, forwarding each of
+// ports ("80 127.0.0.1:8080") to a local target.
+func (t *Torrc) AddHiddenService(dir string, ports ...string) *Torrc {
+	t.Add("HiddenServiceDir", dir)
+	for _, p := range ports {
+		t.Add("HiddenServicePort", p)
+	}
+	return t
+}
+
+// AddBridge adds a Bridge line and enables UseBridges.
+func (t *Torrc) AddBridge(b Bridge) *Torrc {
+	t.Set("UseBridges", "1")
+	return t.Add("Bridge", b.String())
+}
+
+// AddTransportPlugin adds a ClientTransportPlugin line.
+func (t *Torrc) AddTransportPlugin(p TransportPlugin) *Torrc {
+	return t.Add("ClientTransportPlugin", p.String())
+}
+
+// setNodes renders nodes into a node list option, removing it when empty.
+func (t *Torrc) setNodes(key string, nodes []NodeSelector) *Torrc {
+	if len(nodes) == 0 {
+		return t.Remove(key)
+	}
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.String()
+	}
+	return t.Set(key, strings.Join(parts, ","))
+}
+
+// Validate checks that every line is well formed and that node lists parse.
+// It does not know every Tor option; use Verify for a full check by tor.
+func (t *Torrc) Validate() error {
+	for _, e := range t.entries {
+		key := strings.TrimLeft(e.Key, "+/")
+		if !validConfKey(key) || strings.Contains(key, "/") {
+			return newError(ErrInvalidConfig, opTorrc, fmt.Sprintf("invalid option name %q", e.Key), nil)
+		}
+		if strings.ContainsAny(e.Value, "\r\n") {
+			return newError(ErrInvalidConfig, opTorrc, fmt.Sprintf("value of %s must be a single line", e.Key), nil)
+		}
+		switch strings.ToLower(key) {
+		case "exitnodes", "entrynodes", "excludenodes", "excludeexitnodes", "hslayer2nodes", "hslayer3nodes":
+			nodes, err := ParseNodeSelectors(e.Value)
+			if err != nil {
+				return newError(ErrInvalidConfig, opTorrc, "invalid "+key, err)
+			}
+			if _, err := formatNodeList(nodes); err != nil {
+				return newError(ErrInvalidConfig, opTorrc, "invalid "+key, err)
+			}
+		case "bridge":
+			if _, err := ParseBridge(e.Value); err != nil {
+				return err
+			}
+		case "socksport", "controlport":
+			if err := validateTorrcPort(e.Value); err != nil {
+				return newError(ErrInvalidConfig, opTorrc, "invalid "+key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTorrcPort checks the address part of a SocksPort or ControlPort
+// line, ignoring isolation flags and unix sockets.
+func validateTorrcPort(value string) error {
+	addr, _, _ := strings.Cut(value, " ")
+	if addr == "" || addr == "auto" || strings.HasPrefix(addr, "unix:") {
+		return nil
+	}
+	port := addr
+	if strings.Contains(addr, ":") {
+		var err error
+		if _, port, err = net.SplitHostPort(addr); err != nil {
+			return err
+		}
+	}
+	if port == "auto" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// Verify runs "tor --verify-config" on t with the given tor binary ("tor" when
+// empty) and returns the warnings tor reported when it rejects the config.
+func (t *Torrc) Verify(ctx context.Context, torBinary string) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	if torBinary == "" {
+		torBinary = defaultTorBinary
+	}
+	binPath, err := exec.LookPath(torBinary)
+	if err != nil {
+		return newError(ErrTorBinaryNotFound, opTorrc, fmt.Sprintf("tor binary not found: %q", torBinary), err)
+	}
+	dir, err := os.MkdirTemp("", "tornago-torrc-*")
+	if err != nil {
+		return newError(ErrIO, opTorrc, "failed to create temporary directory", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "torrc")
+	if err := t.WriteFile(path); err != nil {
+		return err
+	}
+
+	// #nosec G204 -- the torrc path is generated here and the binary is chosen by the caller.
+	cmd := exec.CommandContext(ctx, binPath, "--verify-config", "-f", path, "--defaults-torrc", os.DevNull)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return newError(ErrTimeout, opTorrc, "tor --verify-config canceled", ctxErr)
+	}
+	var problems []string
+	for _, line := range strings.Split(string(out), "\n") {
+		entry, ok := ParseTorLogLine(line)
+		if ok && (entry.Severity == TorLogWarn || entry.Severity == TorLogErr) {
+			problems = append(problems, entry.Message)
+		}
+	}
+	msg := "tor rejected the configuration"
+	if len(problems) > 0 {
+		msg += ": " + strings.Join(problems, "; ")
+	}
+	return newError(ErrInvalidConfig, opTorrc, msg, err)
+}
+
+// Args renders t as tor command-line arguments ("--Key", "value", ...).
+// Keys prefixed with "+" (append) or "/" (clear) keep their prefix in place
+// of the dashes, as tor expects; a "/" key takes no value.
+func (t *Torrc) Args() []string {
+	args := make([]string, 0, 2*len(t.entries))
+	for _, e := range t.entries {
+		switch {
+		case strings.HasPrefix(e.Key, "/"):
+			args = append(args, e.Key)
+		case strings.HasPrefix(e.Key, "+"):
+			args = append(args, e.Key, e.Value)
+		default:
+			args = append(args, "--"+e.Key, e.Value)
+		}
+	}
+	return args
+}
+
+// String renders t in torrc file syntax.
+func (t *Torrc) String() string {
+	var buf bytes.Buffer
+	_, _ = t.WriteTo(&buf) //nolint:errcheck // bytes.Buffer writes cannot fail
+	return buf.String()
+}
+
+// WriteTo writes t in torrc file syntax to w.
+func (t *Torrc) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, e := range t.entries {
+		line := e.Key
+		if e.Value != "" {
+			line += " " + formatTorrcValue(e.Value)
+		}
+		n, err := io.WriteString(w, line+"\n")
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// WriteFile writes t to path with owner-only permissions.
+func (t *Torrc) WriteFile(path string) error {
+	if err := os.WriteFile(filepath.Clean(path), []byte(t.String()), 0o600); err != nil {
+		return newError(ErrIO, opTorrc, "failed to write "+path, err)
+	}
+	return nil
+}
+
+// formatTorrcValue quotes values that would not survive an unquoted torrc line.
+func formatTorrcValue(v string) string {
+	if strings.ContainsAny(v, "#\\\"\r\n") || strings.TrimSpace(v) != v {
+		return quotedString(v)
+	}
+	return v
+}
+
+// validateLaunchTorrc checks the Torrc given with WithTorTorrc.
+func validateLaunchTorrc(cfg TorLaunchConfig) error {
+	if cfg.torrc == nil {
+		return nil
+	}
+	if err := cfg.torrc.Validate(); err != nil {
+		return err
+	}
+	if cfg.torConfigFile != "" {
+		return nil
+	}
+	for _, e := range cfg.torrc.entries {
+		key := strings.TrimLeft(e.Key, "+/")
+		if slices.ContainsFunc(torrcManagedKeys, func(k string) bool { return strings.EqualFold(k, key) }) {
+			return newError(ErrInvalidConfig, "validateTorLaunchConfig",
+				fmt.Sprintf("Torrc sets %s, which StartTorDaemon manages. Use WithTorSocksAddr, WithTorControlAddr or WithTorDataDir instead", key), nil)
+		}
+	}
+	return nil
+}
+
+// torrcArgs renders the Torrc of cfg as tor CLI args.
+func torrcArgs(cfg TorLaunchConfig) []string {
+	if cfg.torrc == nil {
+		return nil
+	}
+	return cfg.torrc.Args()
+}
@@ -0,0 +1,238 @@
+package tornago
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTorrc(t *testing.T) {
+	t.Run("should parse comments, repeated keys and quoted values", func(t *testing.T) {
+		input := "# main config\n" +
+			"SocksPort 9050 # inline comment\n" +
+			"  Nickname\t\"quoted \\\"name\\\" # not a comment\"\n" +
+			"HiddenServiceDir /var/lib/tor/hs\n" +
+			"HiddenServicePort 80 127.0.0.1:8080\n" +
+			"HiddenServicePort 443 127.0.0.1:8443\n" +
+			"ExitNodes {de},\\\n" +
+			"# skipped inside continuation\n" +
+			"  {nl}\n" +
+			"ClientOnly\n"
+		rc, err := ParseTorrc(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("ParseTorrc failed: %v", err)
+		}
+		want := []ConfEntry{
+			{Key: "SocksPort", Value: "9050"},
+			{Key: "Nickname", Value: `quoted "name" # not a comment`},
+			{Key: "HiddenServiceDir", Value: "/var/lib/tor/hs"},
+			{Key: "HiddenServicePort", Value: "80 127.0.0.1:8080"},
+			{Key: "HiddenServicePort", Value: "443 127.0.0.1:8443"},
+			{Key: "ExitNodes", Value: "{de},  {nl}"},
+			{Key: "ClientOnly"},
+		}
+		if got := rc.Entries(); !slices.Equal(got, want) {
+			t.Errorf("Entries() = %+v\nwant %+v", got, want)
+		}
+		if got := rc.GetAll("hiddenserviceport"); len(got) != 2 {
+			t.Errorf("GetAll() = %q", got)
+		}
+	})
+
+	t.Run("should report the line of a syntax error", func(t *testing.T) {
+		_, err := ParseTorrc(strings.NewReader("SocksPort 9050\nNickname \"open\n"))
+		if err == nil || !strings.Contains(err.Error(), "torrc:2") {
+			t.Errorf("expected error on line 2, got %v", err)
+		}
+	})
+}
+
+func TestLoadTorrc(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("should expand includes of files, directories and globs", func(t *testing.T) {
+		write("torrc.d/20-exits", "ExitNodes {nl}\n")
+		write("torrc.d/10-log", "Log notice stdout\n")
+		write("torrc.d/.hidden", "Log debug stdout\n")
+		write("extra.conf", "ClientOnly 1\n")
+		main := write("torrc", "SocksPort 9050\n%include torrc.d\n%include *.conf\nExitNodes {de}\n")
+
+		rc, err := LoadTorrc(main)
+		if err != nil {
+			t.Fatalf("LoadTorrc failed: %v", err)
+		}
+		want := []ConfEntry{
+			{Key: "SocksPort", Value: "9050"},
+			{Key: "Log", Value: "notice stdout"},
+			{Key: "ExitNodes", Value: "{nl}"},
+			{Key: "ClientOnly", Value: "1"},
+			{Key: "ExitNodes", Value: "{de}"},
+		}
+		if got := rc.Entries(); !slices.Equal(got, want) {
+			t.Errorf("Entries() = %+v\nwant %+v", got, want)
+		}
+		if v, _ := rc.Get("ExitNodes"); v != "{de}" {
+			t.Errorf("expected the last ExitNodes to win, got %q", v)
+		}
+	})
+
+	t.Run("should reject include loops", func(t *testing.T) {
+		loop := write("loop", "%include loop\n")
+		if _, err := LoadTorrc(loop); err == nil {
+			t.Error("expected error for include loop")
+		}
+	})
+
+	t.Run("should fail on a missing include", func(t *testing.T) {
+		main := write("missing", "%include does-not-exist\n")
+		if _, err := LoadTorrc(main); err == nil {
+			t.Error("expected error for missing include")
+		}
+	})
+}
+
+func TestTorrcBuilder(t *testing.T) {
+	t.Run("should render typed setters", func(t *testing.T) {
+		rc := NewTorrc().
+			SetSocksPort("127.0.0.1:9050").
+			SetCookieAuthentication(true).
+			SetExitNodes(CountrySelector("de"), FingerprintSelector("$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")).
+			SetStrictNodes(true).
+			AddLog("notice", "stdout").
+			AddHiddenService("/var/lib/tor/hs", "80 127.0.0.1:8080").
+			Add("Nickname", "has # hash")
+		want := "SocksPort 127.0.0.1:9050\n" +
+			"CookieAuthentication 1\n" +
+			"ExitNodes {de},$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\n" +
+			"StrictNodes 1\n" +
+			"Log notice stdout\n" +
+			"HiddenServiceDir /var/lib/tor/hs\n" +
+			"HiddenServicePort 80 127.0.0.1:8080\n" +
+			"Nickname \"has # hash\"\n"
+		if got := rc.String(); got != want {
+			t.Errorf("String() =\n%s\nwant\n%s", got, want)
+		}
+		if err := rc.Validate(); err != nil {
+			t.Errorf("Validate failed: %v", err)
+		}
+
+		parsed, err := ParseTorrc(strings.NewReader(rc.String()))
+		if err != nil {
+			t.Fatalf("ParseTorrc failed: %v", err)
+		}
+		if !slices.Equal(parsed.Entries(), rc.Entries()) {
+			t.Errorf("round trip changed entries: %+v", parsed.Entries())
+		}
+		args := rc.Args()
+		if args[0] != "--SocksPort" || args[1] != "127.0.0.1:9050" || args[len(args)-1] != "has # hash" {
+			t.Errorf("unexpected args: %q", args)
+		}
+	})
+
+	t.Run("should render prefixed keys as tor command-line arguments", func(t *testing.T) {
+		rc := NewTorrc().Add("/ExitNodes", "").Add("+SocksPort", "9150").Add("ORPort", "auto")
+		if err := rc.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		want := []string{"/ExitNodes", "+SocksPort", "9150", "--ORPort", "auto"}
+		if got := rc.Args(); !slices.Equal(got, want) {
+			t.Errorf("Args() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("should replace keys in place", func(t *testing.T) {
+		rc := NewTorrc().Add("Log", "notice stdout").Add("SocksPort", "9050").Add("Log", "info file x")
+		rc.Set("log", "warn stderr")
+		want := []ConfEntry{{Key: "log", Value: "warn stderr"}, {Key: "SocksPort", Value: "9050"}}
+		if got := rc.Entries(); !slices.Equal(got, want) {
+			t.Errorf("Entries() = %+v", got)
+		}
+		rc.SetExitNodes()
+		rc.Remove("SOCKSPORT")
+		if len(rc.Entries()) != 1 {
+			t.Errorf("expected one entry, got %+v", rc.Entries())
+		}
+	})
+
+	t.Run("should reject invalid entries", func(t *testing.T) {
+		for _, rc := range []*Torrc{
+			NewTorrc().Add("Bad Key", "1"),
+			NewTorrc().Add("Nickname", "two\nlines"),
+			NewTorrc().SetExitNodes(CountrySelector("germany")),
+			NewTorrc().SetSocksPort("127.0.0.1:99999"),
+		} {
+			if err := rc.Validate(); err == nil {
+				t.Errorf("expected Validate to fail for %q", rc.String())
+			}
+		}
+		if err := NewTorrc().Add("+SocksPort", "auto IsolateDestAddr").Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestTorrcVerify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test relies on a shell script")
+	}
+	bin := filepath.Join(t.TempDir(), "fake-tor")
+	script := "#!/bin/sh\n" +
+		"if grep -q Bogus \"$3\"; then\n" +
+		"  echo 'Nov 23 12:34:56.789 [warn] Failed to parse/validate config: Unknown option '\"'\"'Bogus'\"'\"'.  Failing.'\n" +
+		"  exit 1\n" +
+		"fi\n" +
+		"echo 'Configuration was valid'\n"
+	if err := os.WriteFile(bin, []byte(script), 0o700); err != nil { //nolint:gosec // test script must be executable
+		t.Fatal(err)
+	}
+
+	if err := NewTorrc().SetClientOnly(true).Verify(context.Background(), bin); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	err := NewTorrc().Add("Bogus", "1").Verify(context.Background(), bin)
+	if err == nil || !strings.Contains(err.Error(), "Unknown option 'Bogus'") {
+		t.Errorf("expected tor warning in error, got %v", err)
+	}
+}
+
+func TestWithTorTorrc(t *testing.T) {
+	t.Run("should pass options to tor", func(t *testing.T) {
+		rc := NewTorrc().SetClientOnly(true)
+		cfg, err := NewTorLaunchConfig(WithTorTorrc(rc))
+		if err != nil {
+			t.Fatalf("NewTorLaunchConfig failed: %v", err)
+		}
+		rc.Add("Log", "debug stdout")
+		if got := torrcArgs(cfg); !slices.Equal(got, []string{"--ClientOnly", "1"}) {
+			t.Errorf("torrcArgs() = %q", got)
+		}
+	})
+
+	t.Run("should reject options managed by StartTorDaemon", func(t *testing.T) {
+		if _, err := NewTorLaunchConfig(WithTorTorrc(NewTorrc().Add("+SocksPort", "9150"))); err == nil {
+			t.Error("expected error for SocksPort")
+		}
+		cfg, err := NewTorLaunchConfig(WithTorConfigFile("/etc/tor/torrc"), WithTorTorrc(NewTorrc().SetSocksPort("9150")))
+		if err != nil {
+			t.Errorf("expected SocksPort to be allowed with a torrc file: %v", err)
+		}
+		if cfg.Torrc() == nil {
+			t.Error("expected Torrc to be kept")
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package tornago
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Transport is an http.RoundTripper that sends requests through Tor with the
+// retry policy, rate limiting, metrics and logging of a Client. Client.Do and
+// the *http.Client returned by Client.HTTP use it, so plugging a Transport
+// into an existing *http.Client behaves the same as calling Client.Do.
+//
+// Connections are dialed through the Client's dialer, so requests share the
+// Client's SocksPort (or HTTPTunnelPort or TorPool) and its isolation.
+//
+// Retries apply to each round trip, including those made to follow
+// redirects. Requests with a body are retried only when GetBody is set, as
+// it is for bodies created by http.NewRequest from bytes or strings; other
+// bodies, such as an io.Pipe, are sent once. The Timeout of the *http.Client
+// bounds the whole request, so it covers every retry and backoff delay.
+//
+// Metrics are recorded per round trip, so when the *http.Client follows a
+// redirect each hop counts as a request. Client.Do instead records each call
+// once, however many redirects it follows.
+//
+// Example:
+//
+//	transport, _ := tornago.NewTransport(cfg)
+//	defer transport.Close()
+//	sdk := someapi.NewClient(&http.Client{Transport: transport})
+type Transport struct {
+	// client supplies the retry policy, rate limiter, metrics and logger.
+	client *Client
+	// base performs single round trips over connections dialed through Tor.
+	base *http.Transport
+	// owned reports whether Close also closes client.
+	owned bool
+}
+
+// NewTransport builds a Transport with its own Client. Close the Transport to
+// release the Client's ControlPort connection and idle connections.
+func NewTransport(cfg ClientConfig) (*Transport, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := client.transport
+	t.owned = true
+	return t, nil
+}
+
+// newTransport builds the Transport of client. Its connections are dialed
+// with client's retry policy and counted in client's metrics.
+func newTransport(client *Client) *Transport {
+	cfg := client.cfg
+	// Connection reuse is tuned for Tor by the ClientConfig defaults
+	// - MaxIdleConnsPerHost: 10 (increased from default 2 for better connection reuse)
+	// - IdleConnTimeout: 90s (keep connections alive longer through Tor circuits)
+	// - DisableKeepAlives: false (enable connection reuse to avoid circuit churn)
+	base := &http.Transport{
+		TLSClientConfig:       cfg.TLSConfig(),
+		ForceAttemptHTTP2:     cfg.HTTP2(),
+		TLSHandshakeTimeout:   cfg.DialTimeout(),
+		MaxIdleConns:          cfg.MaxIdleConns(),
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost(),
+		MaxConnsPerHost:       cfg.MaxConnsPerHost(),
+		IdleConnTimeout:       cfg.IdleConnTimeout(),
+		DisableKeepAlives:     false,
+		DisableCompression:    false,
+		ResponseHeaderTimeout: cfg.RequestTimeout(),
+	}
+	if !cfg.HTTP2() {
+		// A non-nil empty map keeps net/http from enabling HTTP/2.
+		base.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if hook := cfg.TransportHook(); hook != nil {
+		hook(base)
+	}
+	// Whatever the hook did, connections must be dialed through Tor.
+	base.DialContext = client.dialContext
+	base.Proxy = nil
+	base.Dial = nil    //nolint:staticcheck // cleared so it cannot bypass Tor
+	base.DialTLS = nil //nolint:staticcheck // cleared so it cannot bypass Tor
+	base.DialTLSContext = nil
+	return &Transport{client: client, base: base}
+}
+
+// Transport returns the Client's Transport, for use in other *http.Clients.
+// Closing the Client closes the Transport's idle connections.
+func (c *Client) Transport() *Transport {
+	return c.transport
+}
+
+// clientDoKey marks request contexts of Client.Do, which records metrics
+// once per call instead of once per round trip.
+type clientDoKey struct{}
+
+// RoundTrip implements http.RoundTripper. It waits for the rate limiter,
+// retries failed round trips according to the Client's retry policy and
+// reports each request to the Client's metrics and logger.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req == nil {
+		return nil, newError(ErrInvalidConfig, opClient, "request is nil", nil)
+	}
+	// Once the original body is handed to the base transport, it is closed
+	// there, possibly after RoundTrip returns while an upload still streams.
+	// Only a body that never got that far is closed here.
+	sent := false
+	defer func() {
+		if !sent && req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}()
+	c := t.client
+
+	c.logger.Log("debug", "http request", "method", req.Method, "url", req.URL.String())
+
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.Wait(req.Context()); err != nil {
+			c.logger.Log("warn", "rate limit wait failed", "error", err)
+			return nil, newError(ErrHTTPFailed, opClient, "rate limit wait failed", err)
+		}
+	}
+
+	policy := c.retryPolicy
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body can only be sent once.
+		policy.attempts = 1
+	}
+	start := time.Now()
+	var resp *http.Response
+	attempt := 0
+	err := policy.run(req.Context(), 0, func(_ context.Context) error {
+		attemptReq := req
+		if attempt > 0 {
+			cloned, cloneErr := cloneRequestWithContext(req.Context(), req)
+			if cloneErr != nil {
+				return newError(ErrHTTPFailed, opClient, "failed to clone request", cloneErr)
+			}
+			attemptReq = cloned
+		}
+		attempt++
+		if attemptReq == req {
+			sent = true
+		}
+		attemptResp, rtErr := t.base.RoundTrip(attemptReq)
+		if rtErr != nil {
+			return newError(ErrHTTPFailed, opClient, "http request failed", rtErr)
+		}
+		resp = attemptResp
+		return nil
+	})
+	if err == nil {
+		err = t.capBody(resp)
+		if err != nil {
+			resp = nil
+		}
+	}
+	latency := time.Since(start)
+	if c.metrics != nil && req.Context().Value(clientDoKey{}) == nil {
+		c.metrics.recordRequest(latency, err)
+		c.observeResponse(req, resp, latency, err)
+	}
+	if err != nil {
+		c.logger.Log("error", "http request failed", "method", req.Method, "url", req.URL.String(), "latency", latency, "error", err)
+		return nil, err
+	}
+	c.logger.Log("debug", "http request succeeded", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "latency", latency)
+	return resp, nil
+}
+
+// capBody enforces MaxResponseBodySize on resp. Responses declaring a larger
+// body are closed and rejected; other bodies fail once they grow past the cap.
+func (t *Transport) capBody(resp *http.Response) error {
+	limit := t.client.cfg.MaxResponseBodySize()
+	// Upgraded connections (101) expose a writable body that must not be wrapped.
+	if limit == 0 || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		closeErr := resp.Body.Close()
+		return newError(ErrHTTPFailed, opClient,
+			fmt.Sprintf("response body of %d bytes exceeds the %d byte limit", resp.ContentLength, limit), closeErr)
+	}
+	resp.Body = &cappedBody{ReadCloser: resp.Body, limit: limit, remaining: limit}
+	return nil
+}
+
+// cappedBody fails reads once a response body grows past limit bytes.
+type cappedBody struct {
+	io.ReadCloser
+	// limit is the maximum body size in bytes.
+	limit int64
+	// remaining is the number of bytes that may still be read.
+	remaining int64
+}
+
+// Read implements io.Reader, returning ErrHTTPFailed for data past the limit.
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Probe for data past the limit; io.EOF here means the body fits.
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, newError(ErrHTTPFailed, opClient,
+				fmt.Sprintf("response body exceeds the %d byte limit", b.limit), nil)
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// CloseIdleConnections closes idle connections. *http.Client calls it from
+// its own CloseIdleConnections.
+func (t *Transport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// Close closes idle connections and, for a Transport built by NewTransport,
+// its Client.
+func (t *Transport) Close() error {
+	if t.owned {
+		return t.client.Close()
+	}
+	t.CloseIdleConnections()
+	return nil
+}
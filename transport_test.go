@@ -0,0 +1,446 @@
+package tornago
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closeTracker records whether a request body was closed.
+type closeTracker struct {
+	io.Reader
+	closed atomic.Bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// flakyServer drops the connection of its first request without replying.
+func flakyServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "ok "+string(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestTransport(t *testing.T) {
+	t.Run("should give a plain http.Client retries and metrics", func(t *testing.T) {
+		server, hits := flakyServer(t)
+		mockSOCKS := createMockSOCKS5ServerWithForwarding(t, server.Listener.Addr().String())
+		defer mockSOCKS.Close()
+		metrics := NewMetricsCollector()
+		cfg, err := NewClientConfig(
+			WithClientSocksAddr(mockSOCKS.Addr().String()),
+			WithRetryAttempts(3),
+			WithRetryDelay(10*time.Millisecond),
+			WithClientMetrics(metrics),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		transport, err := NewTransport(cfg)
+		if err != nil {
+			t.Fatalf("NewTransport failed: %v", err)
+		}
+		defer transport.Close()
+
+		httpClient := &http.Client{Transport: transport}
+		resp, err := httpClient.Post(server.URL, "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatalf("Post failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "ok payload" {
+			t.Errorf("body = %q, want %q", body, "ok payload")
+		}
+		if got := hits.Load(); got != 2 {
+			t.Errorf("server saw %d requests, want 2", got)
+		}
+		if metrics.RequestCount() != 1 || metrics.SuccessCount() != 1 {
+			t.Errorf("metrics requests = %d, successes = %d; want 1 and 1", metrics.RequestCount(), metrics.SuccessCount())
+		}
+	})
+
+	t.Run("should retry requests made through Client.HTTP", func(t *testing.T) {
+		server, hits := flakyServer(t)
+		mockSOCKS := createMockSOCKS5ServerWithForwarding(t, server.Listener.Addr().String())
+		defer mockSOCKS.Close()
+		cfg, err := NewClientConfig(
+			WithClientSocksAddr(mockSOCKS.Addr().String()),
+			WithRetryAttempts(2),
+			WithRetryDelay(10*time.Millisecond),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+		if client.HTTP().Transport != client.Transport() {
+			t.Error("Client.HTTP should use Client.Transport")
+		}
+
+		resp, err := client.HTTP().Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if got := hits.Load(); got != 2 {
+			t.Errorf("server saw %d requests, want 2", got)
+		}
+	})
+
+	t.Run("should report failures as TornagoError and close the request body", func(t *testing.T) {
+		mockSOCKS := createMockSOCKS5ServerClosingImmediately(t)
+		defer mockSOCKS.Close()
+		cfg, err := NewClientConfig(
+			WithClientSocksAddr(mockSOCKS.Addr().String()),
+			WithRetryAttempts(1),
+		)
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+
+		body := &closeTracker{Reader: strings.NewReader("payload")}
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com/", body)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("payload")), nil }
+		if _, err := client.Transport().RoundTrip(req); !errors.Is(err, &TornagoError{Kind: ErrHTTPFailed}) {
+			t.Errorf("RoundTrip error = %v, want ErrHTTPFailed", err)
+		}
+		if !body.closed.Load() {
+			t.Error("RoundTrip did not close the request body")
+		}
+
+		req, err = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com/", http.NoBody)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		_, err = client.Do(req)
+		var tornagoErr *TornagoError
+		if !errors.As(err, &tornagoErr) || tornagoErr.Kind != ErrHTTPFailed {
+			t.Errorf("Do error = %v, want *TornagoError with ErrHTTPFailed", err)
+		}
+	})
+
+	t.Run("should send streamed bodies once without retrying", func(t *testing.T) {
+		server, hits := flakyServer(t)
+		client := newTransportTestClient(t, server.Listener.Addr().String(),
+			WithRetryAttempts(3), WithRetryDelay(10*time.Millisecond))
+		post := func() (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				_, _ = io.WriteString(pw, "streamed")
+				_ = pw.Close()
+			}()
+			return client.HTTP().Post(server.URL, "text/plain", pr)
+		}
+
+		// The first request hits the dropped connection and cannot be replayed.
+		if resp, err := post(); err == nil {
+			_ = resp.Body.Close()
+			t.Error("expected the dropped request to fail")
+		}
+		if got := hits.Load(); got != 1 {
+			t.Errorf("server saw %d requests, want 1", got)
+		}
+		resp, err := post()
+		if err != nil {
+			t.Fatalf("Post failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "ok streamed" {
+			t.Errorf("body = %q, want %q", body, "ok streamed")
+		}
+	})
+
+	t.Run("should keep streaming a request body after the response headers", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			_ = rc.EnableFullDuplex()
+			w.WriteHeader(http.StatusOK)
+			_ = rc.Flush()
+			body, _ := io.ReadAll(r.Body)
+			_, _ = io.WriteString(w, "got "+string(body))
+		}))
+		t.Cleanup(server.Close)
+		client := newTransportTestClient(t, server.Listener.Addr().String())
+
+		pr, pw := io.Pipe()
+		go func() { _, _ = io.WriteString(pw, "head ") }()
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL, pr)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		resp, err := client.Transport().RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip failed: %v", err)
+		}
+		defer resp.Body.Close()
+		// The headers are back; the rest of the upload must still go through.
+		if _, err := io.WriteString(pw, "tail"); err != nil {
+			t.Fatalf("write after headers failed: %v", err)
+		}
+		_ = pw.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "got head tail" {
+			t.Errorf("body = %q, want %q", body, "got head tail")
+		}
+	})
+
+	t.Run("should count a redirected Client.Do call once", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/final" {
+				http.Redirect(w, r, "/final", http.StatusFound)
+				return
+			}
+			_, _ = io.WriteString(w, "done")
+		}))
+		t.Cleanup(server.Close)
+		metrics := NewMetricsCollector()
+		var outcomes atomic.Int32
+		client := newTransportTestClient(t, server.Listener.Addr().String(), WithClientMetrics(metrics))
+		metrics.OnRequest(func(RequestOutcome) { outcomes.Add(1) })
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/start", http.NoBody)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do failed: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if metrics.RequestCount() != 1 || outcomes.Load() != 1 {
+			t.Errorf("Do counted %d requests and %d outcomes, want 1 and 1", metrics.RequestCount(), outcomes.Load())
+		}
+
+		metrics.Reset()
+		resp, err = client.HTTP().Get(server.URL + "/start")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if metrics.RequestCount() != 2 {
+			t.Errorf("Client.HTTP counted %d requests, want one per hop (2)", metrics.RequestCount())
+		}
+	})
+
+	t.Run("should fail when the Client cannot be created", func(t *testing.T) {
+		cfg, err := NewClientConfig(WithClientControlAddr(unusedAddr(t)), WithClientDialTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("NewClientConfig failed: %v", err)
+		}
+		if _, err := NewTransport(cfg); err == nil {
+			t.Error("expected error for an unreachable ControlPort")
+		}
+	})
+}
+
+// newClientCertificate returns a self-signed certificate for TLS client
+// authentication.
+func newClientCertificate(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// newTransportTestClient returns a Client whose SocksPort forwards every
+// connection to target.
+func newTransportTestClient(t *testing.T, target string, opts ...ClientOption) *Client {
+	t.Helper()
+	mockSOCKS := createMockSOCKS5ServerWithForwarding(t, target)
+	t.Cleanup(mockSOCKS.Close)
+	cfg, err := NewClientConfig(append([]ClientOption{
+		WithClientSocksAddr(mockSOCKS.Addr().String()),
+		WithRetryAttempts(1),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClientConfig failed: %v", err)
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestTransportOptions(t *testing.T) {
+	t.Run("should present client certificates to mTLS servers", func(t *testing.T) {
+		clientCert := newClientCertificate(t, "tornago-partner")
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCert.Leaf)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "%s %d %x", r.TLS.PeerCertificates[0].Subject.CommonName, r.ProtoMajor, r.TLS.Version)
+		}))
+		server.EnableHTTP2 = true
+		// The rejected handshake below is expected.
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+		server.StartTLS()
+		defer server.Close()
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(server.Certificate())
+
+		for _, tc := range []struct {
+			http2 bool
+			want  string
+		}{
+			{true, "tornago-partner 2 304"},
+			{false, "tornago-partner 1 304"},
+		} {
+			client := newTransportTestClient(t, server.Listener.Addr().String(),
+				WithClientRootCAs(rootCAs),
+				WithClientCertificates(clientCert),
+				WithClientTLSMinVersion(tls.VersionTLS13),
+				WithClientHTTP2(tc.http2),
+			)
+			resp, err := client.HTTP().Get(server.URL)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if string(body) != tc.want {
+				t.Errorf("HTTP2 %v: server saw %q, want %q", tc.http2, body, tc.want)
+			}
+		}
+
+		client := newTransportTestClient(t, server.Listener.Addr().String(), WithClientRootCAs(rootCAs))
+		if resp, err := client.HTTP().Get(server.URL); err == nil {
+			_ = resp.Body.Close()
+			t.Error("expected the server to reject a client without a certificate")
+		}
+	})
+
+	t.Run("should cap response bodies", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := strings.Repeat("x", 10)
+			if r.URL.Path == "/fixed" {
+				w.Header().Set("Content-Length", "10")
+			}
+			if r.URL.Path == "/small" {
+				body = body[:5]
+			}
+			_, _ = io.WriteString(w, body)
+			w.(http.Flusher).Flush()
+		}))
+		defer server.Close()
+		client := newTransportTestClient(t, server.Listener.Addr().String(), WithClientMaxResponseBodySize(5))
+		get := func(path string) (*http.Response, error) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+path, http.NoBody)
+			if err != nil {
+				t.Fatalf("NewRequest failed: %v", err)
+			}
+			return client.Do(req)
+		}
+
+		if _, err := get("/fixed"); !errors.Is(err, &TornagoError{Kind: ErrHTTPFailed}) {
+			t.Errorf("declared oversized body: error = %v, want ErrHTTPFailed", err)
+		}
+		resp, err := get("/streamed")
+		if err != nil {
+			t.Fatalf("Do failed: %v", err)
+		}
+		_, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if !errors.Is(err, &TornagoError{Kind: ErrHTTPFailed}) {
+			t.Errorf("streamed oversized body: read error = %v, want ErrHTTPFailed", err)
+		}
+		resp, err = get("/small")
+		if err != nil {
+			t.Fatalf("Do failed: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil || string(body) != "xxxxx" {
+			t.Errorf("body within the cap = %q, %v", body, err)
+		}
+	})
+
+	t.Run("should apply the transport hook but keep dialing through Tor", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		}))
+		defer server.Close()
+		var hooked *http.Transport
+		client := newTransportTestClient(t, server.Listener.Addr().String(), WithClientTransportHook(func(tr *http.Transport) {
+			hooked = tr
+			tr.MaxResponseHeaderBytes = 4096
+			tr.DialContext = func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("direct dial")
+			}
+		}))
+		if hooked == nil || hooked.MaxResponseHeaderBytes != 4096 {
+			t.Fatal("transport hook was not applied")
+		}
+		// The URL points at a port nothing listens on; only the SOCKS mock
+		// knows the real server.
+		resp, err := client.HTTP().Get("http://" + unusedAddr(t) + "/")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	})
+}